@@ -0,0 +1,342 @@
+package termemu
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// CastHeader is the first line of an asciicast v2 recording.
+type CastHeader struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Command       string            `json:"command,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+// CastRecorder is a Backend that records another Backend as an asciicast v2
+// recording (https://docs.asciinema.org/manual/asciicast/v2/): output read
+// from it as "o" events, input written to it as "i" events and resizes as
+// "r" events.
+type CastRecorder struct {
+	backend Backend
+
+	mu          sync.Mutex
+	w           io.Writer
+	clock       Clock
+	start       time.Time
+	recordInput bool
+	// partial holds the start of a UTF-8 sequence split across reads, since
+	// events must be valid UTF-8.
+	partial []byte
+	err     error
+}
+
+// NewCastRecorder returns a CastRecorder wrapping backend and writes the
+// header to w. The version is always 2, and the timestamp defaults to now.
+func NewCastRecorder(backend Backend, w io.Writer, header CastHeader) (*CastRecorder, error) {
+	return newCastRecorder(backend, w, header, systemClock{})
+}
+
+func newCastRecorder(backend Backend, w io.Writer, header CastHeader, clock Clock) (*CastRecorder, error) {
+	r := &CastRecorder{backend: backend, w: w, clock: clock, start: clock.Now(), recordInput: true}
+	header.Version = 2
+	if header.Timestamp == 0 {
+		header.Timestamp = r.start.Unix()
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetRecordInput sets whether input is recorded. It is on by default; turn it
+// off to keep passwords and other typed secrets out of the recording.
+func (r *CastRecorder) SetRecordInput(on bool) {
+	r.mu.Lock()
+	r.recordInput = on
+	r.mu.Unlock()
+}
+
+// Err returns the first error writing the recording. Recording errors do not
+// affect the wrapped Backend.
+func (r *CastRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Unwrap returns the wrapped Backend.
+func (r *CastRecorder) Unwrap() Backend {
+	return r.backend
+}
+
+func (r *CastRecorder) Read(p []byte) (int, error) {
+	n, err := r.backend.Read(p)
+	if n > 0 {
+		r.mu.Lock()
+		data := append(r.partial, p[:n]...)
+		cut := completeUTF8(data)
+		r.partial = append([]byte(nil), data[cut:]...)
+		r.eventLocked("o", data[:cut])
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+func (r *CastRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	if r.recordInput {
+		r.eventLocked("i", p)
+	}
+	r.mu.Unlock()
+	return r.backend.Write(p)
+}
+
+func (r *CastRecorder) SetSize(w, h int) error {
+	r.mu.Lock()
+	r.eventLocked("r", []byte(fmt.Sprintf("%dx%d", w, h)))
+	r.mu.Unlock()
+	return r.backend.SetSize(w, h)
+}
+
+// Close records any incomplete output and closes the wrapped Backend if it
+// can be closed. It does not close the recording's writer.
+func (r *CastRecorder) Close() error {
+	r.mu.Lock()
+	r.eventLocked("o", r.partial)
+	r.partial = nil
+	r.mu.Unlock()
+	if c, ok := r.backend.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// eventLocked writes one event line. The caller must hold r.mu.
+func (r *CastRecorder) eventLocked(code string, data []byte) {
+	if len(data) == 0 || r.err != nil {
+		return
+	}
+	text, err := json.Marshal(string(data))
+	if err != nil {
+		r.err = err
+		return
+	}
+	elapsed := r.clock.Now().Sub(r.start).Seconds()
+	line := []byte("[")
+	line = strconv.AppendFloat(line, elapsed, 'f', 6, 64)
+	line = append(line, `, "`...)
+	line = append(line, code...)
+	line = append(line, `", `...)
+	line = append(line, text...)
+	line = append(line, "]\n"...)
+	if _, err := r.w.Write(line); err != nil {
+		r.err = err
+	}
+}
+
+// completeUTF8 returns the length of data without a UTF-8 sequence cut off at
+// its end.
+func completeUTF8(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
+// ReplayBackend is a Backend that plays back the output of an asciicast v2
+// recording. Input written to it is discarded.
+//
+// A Terminal reading from it waits for events and applies resizes between
+// escape sequences, without the terminal locked. Output that finishes a
+// sequence cut off by the end of an event is read at once, and a resize
+// recorded in between is applied after it.
+type ReplayBackend struct {
+	// OnResize, if set, is called for each resize event, for example to
+	// resize the Terminal. It is called from the Terminal's read loop, without
+	// the terminal locked, so set it before the Terminal is made.
+	OnResize func(w, h int)
+
+	header  CastHeader
+	scanner *bufio.Scanner
+	speed   float64
+	clock   Clock
+	start   time.Time
+	pending []byte
+	resizes []Pos
+	err     error
+	line    int
+	// managed is set once a Terminal's read loop calls idle, after which Read
+	// no longer waits for events itself.
+	managed bool
+}
+
+// NewReplayBackend reads the header of an asciicast v2 recording from r and
+// returns a backend that plays its output. speed scales the recorded timing:
+// 1 is real time, 2 twice as fast, and 0 or less plays everything as fast as
+// it is read.
+func NewReplayBackend(r io.Reader, speed float64) (*ReplayBackend, error) {
+	return newReplayBackend(r, speed, systemClock{})
+}
+
+func newReplayBackend(r io.Reader, speed float64, clock Clock) (*ReplayBackend, error) {
+	b := &ReplayBackend{scanner: bufio.NewScanner(r), speed: speed, clock: clock}
+	b.scanner.Buffer(nil, 16*1024*1024)
+	if !b.scanner.Scan() {
+		if err := b.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("asciicast: missing header")
+	}
+	b.line = 1
+	if err := json.Unmarshal(b.scanner.Bytes(), &b.header); err != nil {
+		return nil, fmt.Errorf("asciicast: header: %w", err)
+	}
+	if b.header.Version != 2 {
+		return nil, fmt.Errorf("asciicast: unsupported version %d", b.header.Version)
+	}
+	return b, nil
+}
+
+// Header returns the recording's header, such as its size.
+func (b *ReplayBackend) Header() CastHeader {
+	return b.header
+}
+
+// Read returns recorded output. Unless a Terminal is reading, it first waits
+// for the output to be due.
+func (b *ReplayBackend) Read(p []byte) (int, error) {
+	if !b.managed {
+		b.advance()
+	}
+	// The Terminal may be in the middle of an escape sequence, with the
+	// terminal locked, so read on without waiting.
+	for len(b.pending) == 0 && b.err == nil {
+		b.next(false)
+	}
+	if len(b.pending) == 0 {
+		return 0, b.err
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// idle is called by the Terminal's read loop, without the terminal locked,
+// when it has handled all the output read so far.
+func (b *ReplayBackend) idle() {
+	b.managed = true
+	b.advance()
+}
+
+// advance applies queued resizes, then waits for events until there is output
+// to read or the recording ends.
+func (b *ReplayBackend) advance() {
+	b.applyResizes()
+	for len(b.pending) == 0 && b.err == nil {
+		b.next(true)
+		b.applyResizes()
+	}
+}
+
+func (b *ReplayBackend) applyResizes() {
+	for _, size := range b.resizes {
+		if b.OnResize != nil {
+			b.OnResize(size.X, size.Y)
+		}
+	}
+	b.resizes = nil
+}
+
+// next reads the next event, waiting until it is due if wait is set. Output
+// goes to pending, resizes are queued for applyResizes, and errors and the
+// end of the recording are kept in err.
+func (b *ReplayBackend) next(wait bool) {
+	if !b.scanner.Scan() {
+		b.err = b.scanner.Err()
+		if b.err == nil {
+			b.err = io.EOF
+		}
+		return
+	}
+	b.line++
+	if len(b.scanner.Bytes()) == 0 {
+		return
+	}
+
+	var ev [3]json.RawMessage
+	var at float64
+	var code, data string
+	if err := json.Unmarshal(b.scanner.Bytes(), &ev); err != nil {
+		b.err = fmt.Errorf("asciicast: line %d: %w", b.line, err)
+		return
+	}
+	if err := errors.Join(json.Unmarshal(ev[0], &at), json.Unmarshal(ev[1], &code), json.Unmarshal(ev[2], &data)); err != nil {
+		b.err = fmt.Errorf("asciicast: line %d: %w", b.line, err)
+		return
+	}
+
+	switch code {
+	case "o":
+		if wait {
+			b.wait(at)
+		}
+		b.pending = []byte(data)
+	case "r":
+		var w, h int
+		if _, err := fmt.Sscanf(data, "%dx%d", &w, &h); err != nil {
+			debugPrintf(debugErrors, "asciicast: line %d: bad resize %q\n", b.line, data)
+			return
+		}
+		if wait {
+			b.wait(at)
+		}
+		b.resizes = append(b.resizes, Pos{X: w, Y: h})
+	}
+}
+
+// wait sleeps until an event at elapsed seconds is due.
+func (b *ReplayBackend) wait(elapsed float64) {
+	if b.speed <= 0 {
+		return
+	}
+	if b.start.IsZero() {
+		b.start = b.clock.Now()
+	}
+	due := b.start.Add(time.Duration(elapsed / b.speed * float64(time.Second)))
+	d := due.Sub(b.clock.Now())
+	if d <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	b.clock.AfterFunc(d, func() { close(done) })
+	<-done
+}
+
+func (b *ReplayBackend) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (b *ReplayBackend) SetSize(w, h int) error {
+	return nil
+}
@@ -0,0 +1,176 @@
+package termemu
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestCastRecorder(t *testing.T) {
+	var out bytes.Buffer
+	clock := &fakeClock{now: time.Unix(1700000000, 0), step: 100 * time.Millisecond}
+	r, err := newCastRecorder(NewNoPTYBackend(iotest.OneByteReader(strings.NewReader("hé")), io.Discard), &out, CastHeader{Width: 80, Height: 24, Title: "demo"}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 8)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			break
+		}
+	}
+	_, _ = r.Write([]byte("q"))
+	_ = r.SetSize(100, 30)
+	r.SetRecordInput(false)
+	_, _ = r.Write([]byte("secret"))
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	var header CastHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("header %q: %v", lines[0], err)
+	}
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Timestamp != 1700000000 || header.Title != "demo" {
+		t.Errorf("header = %+v", header)
+	}
+	// é arrives split across two reads but is recorded whole.
+	want := []string{
+		`[0.100000, "o", "h"]`,
+		`[0.200000, "o", "é"]`,
+		`[0.300000, "i", "q"]`,
+		`[0.400000, "r", "100x30"]`,
+	}
+	if got := lines[1:]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+const testCast = `{"version": 2, "width": 20, "height": 3}
+[0.0, "o", "hello"]
+[0.1, "i", "x"]
+[0.2, "r", "30x4"]
+[0.3, "o", "\r\nworld"]
+`
+
+func TestReplayBackend(t *testing.T) {
+	rb, err := NewReplayBackend(strings.NewReader(testCast), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizes []Pos
+	rb.OnResize = func(w, h int) { sizes = append(sizes, Pos{X: w, Y: h}) }
+	h := rb.Header()
+	term := NewTerminal(rb, WithSize(h.Width, h.Height))
+	<-term.Done()
+
+	if err := term.Err(); err != nil {
+		t.Fatalf("Err = %v", err)
+	}
+	if len(sizes) != 1 || sizes[0] != (Pos{X: 30, Y: 4}) {
+		t.Errorf("resizes = %v, want [30x4]", sizes)
+	}
+	term.Lock()
+	defer term.Unlock()
+	if got := strings.TrimRight(term.Line(0), " ") + "|" + strings.TrimRight(term.Line(1), " "); got != "hello|world" {
+		t.Errorf("screen = %q", got)
+	}
+}
+
+func TestReplayBackend_Speed(t *testing.T) {
+	rb, err := NewReplayBackend(strings.NewReader(testCast), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := io.ReadAll(rb); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 25*time.Millisecond {
+		t.Errorf("replay at 10x took %v, want about 30ms", d)
+	}
+}
+
+func TestReplayBackend_SplitEscape(t *testing.T) {
+	// The resize and the long wait fall inside an escape sequence. The clock
+	// never fires, so waiting there would hang the replay.
+	const cast = `{"version": 2, "width": 10, "height": 2}
+[0.0, "o", "a\u001b["]
+[0.1, "r", "30x4"]
+[10.0, "o", "1mb"]
+`
+	rb, err := newReplayBackend(strings.NewReader(cast), 1, &fakeClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var term Terminal
+	ready := make(chan struct{})
+	rb.OnResize = func(w, h int) {
+		<-ready
+		term.Resize(w, h)
+	}
+	term = NewTerminal(rb, WithSize(10, 2))
+	close(ready)
+	select {
+	case <-term.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay did not finish")
+	}
+
+	term.Lock()
+	defer term.Unlock()
+	if w, h := term.Size(); w != 30 || h != 4 {
+		t.Errorf("size = %dx%d, want 30x4", w, h)
+	}
+	if got := term.ANSILine(0); !strings.HasPrefix(term.Line(0), "ab ") || !strings.Contains(got, "\x1b[1mb") {
+		t.Errorf("line = %q, want a and a bold b", got)
+	}
+}
+
+func TestReplayBackend_BadInput(t *testing.T) {
+	for _, input := range []string{"", "not json\n", `{"version": 1}` + "\n"} {
+		if _, err := NewReplayBackend(strings.NewReader(input), 0); err == nil {
+			t.Errorf("NewReplayBackend(%q) succeeded", input)
+		}
+	}
+	rb, err := NewReplayBackend(strings.NewReader(`{"version": 2}`+"\n[0, \"o\"\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(rb); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("err = %v, want one for line 2", err)
+	}
+}
+
+func TestCastRoundTrip(t *testing.T) {
+	var cast bytes.Buffer
+	rec, err := NewCastRecorder(NewNoPTYBackend(strings.NewReader("\x1b[1mbold\x1b[0m ✓"), io.Discard), &cast, CastHeader{Width: 20, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	term := NewTerminal(rec, WithSize(20, 2))
+	<-term.Done()
+
+	rb, err := NewReplayBackend(&cast, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := NewTerminal(rb, WithSize(20, 2))
+	<-replayed.Done()
+
+	term.Lock()
+	want := term.ANSILine(0)
+	term.Unlock()
+	replayed.Lock()
+	got := replayed.ANSILine(0)
+	replayed.Unlock()
+	if got != want {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/creack/pty"
 )
@@ -21,8 +23,18 @@ type Backend interface {
 
 // PTYBackend implements Backend using github.com/creack/pty.
 type PTYBackend struct {
+	// Term is the TERM value StartCommand gives the command. It defaults to
+	// xterm-256color, and the WithTerm option sets it.
+	Term string
+
 	master *os.File
 	slave  *os.File
+
+	cmd      *exec.Cmd
+	waitOnce sync.Once
+	waitErr  error
+	mu       sync.Mutex
+	state    *os.ProcessState
 }
 
 // Open creates a new pty pair and returns the slave for external use.
@@ -40,6 +52,8 @@ func (p *PTYBackend) Open() (*os.File, error) {
 }
 
 // StartCommand starts the command connected to a new PTY master.
+// A Terminal using the backend waits for the command once its output ends, so
+// use Wait, ProcessState or ExitCode instead of calling c.Wait.
 func (p *PTYBackend) StartCommand(c *exec.Cmd) error {
 	if p.master != nil {
 		return errors.New("pty already initialized; start command before using backend")
@@ -48,16 +62,20 @@ func (p *PTYBackend) StartCommand(c *exec.Cmd) error {
 		c.Env = os.Environ()
 	}
 
+	term := termStr
+	if p.Term != "" {
+		term = "TERM=" + p.Term
+	}
 	found := false
 	for i, v := range c.Env {
 		if strings.HasPrefix(v, "TERM=") {
 			found = true
-			c.Env[i] = termStr
+			c.Env[i] = term
 			break
 		}
 	}
 	if !found {
-		c.Env = append(c.Env, termStr)
+		c.Env = append(c.Env, term)
 	}
 
 	master, err := pty.Start(c)
@@ -66,14 +84,85 @@ func (p *PTYBackend) StartCommand(c *exec.Cmd) error {
 	}
 	p.master = master
 	p.slave = nil
+	p.cmd = c
 	return nil
 }
 
+// Cmd returns the command started by StartCommand, or nil.
+func (p *PTYBackend) Cmd() *exec.Cmd {
+	return p.cmd
+}
+
+// Wait waits for the command started by StartCommand to exit and returns the
+// error from exec.Cmd.Wait. It may be called more than once, and returns nil
+// if no command was started.
+func (p *PTYBackend) Wait() error {
+	if p.cmd == nil {
+		return nil
+	}
+	p.waitOnce.Do(func() {
+		p.waitErr = p.cmd.Wait()
+		p.mu.Lock()
+		p.state = p.cmd.ProcessState
+		p.mu.Unlock()
+	})
+	return p.waitErr
+}
+
+// ProcessState returns the state of the exited command, or nil if it has not
+// exited or been waited for yet.
+func (p *PTYBackend) ProcessState() *os.ProcessState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// ExitCode returns the exit code of the command, or -1 if it has not exited
+// or was killed by a signal.
+func (p *PTYBackend) ExitCode() int {
+	state := p.ProcessState()
+	if state == nil {
+		return -1
+	}
+	return state.ExitCode()
+}
+
+// closeKillDelay is how long Close gives a command to exit after SIGHUP
+// before killing it.
+const closeKillDelay = time.Second
+
+// Close closes the PTY. A running command gets SIGHUP, and it and its process
+// group are killed if it has not exited a second later, so that a Terminal
+// waiting for it stops.
+func (p *PTYBackend) Close() error {
+	var err, err2 error
+	if p.master != nil {
+		err = p.master.Close()
+	}
+	if p.slave != nil {
+		err2 = p.slave.Close()
+	}
+	if p.cmd != nil && p.cmd.Process != nil {
+		time.AfterFunc(closeKillDelay, func() {
+			if p.ProcessState() == nil {
+				_ = killCommand(p.cmd.Process)
+			}
+		})
+	}
+	return errors.Join(err, err2)
+}
+
+// Read reads the command's output. Once the other side of the PTY is closed,
+// for example because the command exited, it returns io.EOF.
 func (p *PTYBackend) Read(b []byte) (int, error) {
 	if p.master == nil {
 		return 0, io.EOF
 	}
-	return p.master.Read(b)
+	n, err := p.master.Read(b)
+	if errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrClosed) {
+		err = io.EOF
+	}
+	return n, err
 }
 
 func (p *PTYBackend) Write(b []byte) (int, error) {
@@ -180,3 +269,16 @@ func (t *TeeBackend) Write(p []byte) (int, error) {
 func (t *TeeBackend) SetSize(w, h int) error {
 	return t.backend.SetSize(w, h)
 }
+
+// Unwrap returns the wrapped backend.
+func (t *TeeBackend) Unwrap() Backend {
+	return t.backend
+}
+
+// Close closes the wrapped backend if it can be closed.
+func (t *TeeBackend) Close() error {
+	if c, ok := t.backend.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
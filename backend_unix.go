@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package termemu
+
+import (
+	"os"
+	"syscall"
+)
+
+// killCommand kills a command started by StartCommand along with the rest of
+// its process group, so that nothing is left holding the PTY open.
+func killCommand(p *os.Process) error {
+	// pty.Start makes the command a session leader, so its group id is its
+	// pid.
+	if err := syscall.Kill(-p.Pid, syscall.SIGKILL); err == nil {
+		return nil
+	}
+	return p.Kill()
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package termemu
+
+import "os"
+
+// killCommand kills a command started by StartCommand.
+func killCommand(p *os.Process) error {
+	return p.Kill()
+}
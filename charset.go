@@ -0,0 +1,121 @@
+package termemu
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// charset is a 94-character set that can be designated into G0-G3.
+type charset byte
+
+const (
+	charsetASCII       charset = iota // ESC ( B
+	charsetDECGraphics                // ESC ( 0  DEC Special Graphics
+	charsetUK                         // ESC ( A  United Kingdom
+)
+
+// decGraphics maps 0x5f-0x7e to the DEC Special Graphics characters.
+var decGraphics = [...]rune{
+	' ', '◆', '▒', '␉', '␌', '␍', '␊', '°', '±', '␤', '␋', '┘', '┐', '┌', '└', '┼',
+	'⎺', '⎻', '─', '⎼', '⎽', '├', '┤', '┴', '┬', '│', '≤', '≥', 'π', '≠', '£', '·',
+}
+
+// charsetForFinal returns the charset selected by the final byte of a
+// designation sequence.
+func charsetForFinal(b byte) (charset, bool) {
+	switch b {
+	case 'B':
+		return charsetASCII, true
+	case '0':
+		return charsetDECGraphics, true
+	case 'A':
+		return charsetUK, true
+	}
+	return charsetASCII, false
+}
+
+// translate returns the character that r maps to in this charset.
+func (c charset) translate(r rune) rune {
+	switch c {
+	case charsetDECGraphics:
+		if r >= 0x5f && r <= 0x7e {
+			return decGraphics[r-0x5f]
+		}
+	case charsetUK:
+		if r == '#' {
+			return '£'
+		}
+	}
+	return r
+}
+
+// charsetState holds the G0-G3 designations and shift state of a screen.
+type charsetState struct {
+	g [4]charset
+	// gl is the set invoked into GL by a locking shift (SI, SO, LS2, LS3).
+	gl int
+	// single is the set invoked for the next character by SS2 or SS3, or 0.
+	single int
+}
+
+func (t *terminal) charsets() *charsetState {
+	if t.onAltScreen {
+		return &t.charsetAlt
+	}
+	return &t.charsetMain
+}
+
+// active reports whether printed text needs translating at all.
+func (cs *charsetState) active() bool {
+	return cs.single != 0 || cs.g[cs.gl] != charsetASCII
+}
+
+// next returns the charset for the next printed character and consumes any
+// pending single shift.
+func (cs *charsetState) next() charset {
+	if cs.single != 0 {
+		c := cs.g[cs.single]
+		cs.single = 0
+		return c
+	}
+	return cs.g[cs.gl]
+}
+
+// translateString maps printable text through the active charsets.
+func (cs *charsetState) translateString(text string) string {
+	if !cs.active() {
+		return text
+	}
+	var sb strings.Builder
+	sb.Grow(len(text))
+	for i, r := range text {
+		if !cs.active() {
+			sb.WriteString(text[i:])
+			break
+		}
+		sb.WriteRune(cs.next().translate(r))
+	}
+	return sb.String()
+}
+
+// translateTokens maps single-character grapheme tokens through the active
+// charsets, in place.
+func (cs *charsetState) translateTokens(tokens []GraphemeToken) {
+	for i := range tokens {
+		if !cs.active() {
+			return
+		}
+		tok := &tokens[i]
+		if tok.Merge {
+			continue
+		}
+		r, size := utf8.DecodeRune(tok.Bytes)
+		c := cs.next()
+		if size != len(tok.Bytes) {
+			continue
+		}
+		if tr := c.translate(r); tr != r {
+			tok.Bytes = utf8.AppendRune(nil, tr)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package termemu
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCharset_DECSpecialGraphics(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"G0 designation", "\x1b(0lqqk\x1b(Bx", "┌──┐x"},
+		{"SO and SI", "\x1b)0a\x0eqx\x0fq", "a─│q"},
+		{"UK pound", "\x1b(A#1\x1b(B#", "£1#"},
+		{"single shift G2", "\x1b*0\x1bNqq", "─q"},
+		{"single shift G3", "\x1b+A\x1bO##", "£#"},
+		{"locking shift G2", "\x1b*0\x1bnjm\x0fj", "┘└j"},
+		{"non-ASCII untouched", "\x1b(0é─q", "é──"},
+		{"unknown G0 designation ignored", "\x1b(0q\x1b(<q", "──"},
+		{"unknown G1 designation ignored", "\x1b)0\x1b)4\x0eq", "─"},
+	}
+
+	for _, mode := range []TextReadMode{TextReadModeRune, TextReadModeGrapheme} {
+		for _, tt := range tests {
+			forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+				t1 := makeTerminalWithScreens(newFn)
+				t1.textReadMode = mode
+				if err := t1.testFeedTerminalInputFromBackend([]byte(tt.input), mode); err != nil {
+					t.Fatal(err)
+				}
+				if got := strings.TrimRight(t1.Line(0), " "); got != tt.want {
+					t.Errorf("%s (mode %v): line = %q, want %q", tt.name, mode, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCharset_PerScreenAndReset(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b(0\x1b[?1049hq\x1b[?1049lq"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimRight(t1.Line(0), " "); got != "─" {
+		t.Errorf("main screen line = %q, want %q", got, "─")
+	}
+
+	t1.mustHandleCommand(t, "[!p")
+	if err := t1.testFeedTerminalInputFromBackend([]byte("q"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimRight(t1.Line(0), " "); got != "─q" {
+		t.Errorf("after soft reset line = %q, want %q", got, "─q")
+	}
+}
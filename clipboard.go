@@ -0,0 +1,111 @@
+package termemu
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// Clipboard receives OSC 52 clipboard requests from the application.
+//
+// selection is one of the OSC 52 selection characters: 'c' clipboard,
+// 'p' primary, 'q' secondary, 's' select, or '0'-'7' for the cut buffers.
+//
+// Clipboard methods are called with the terminal lock held, like Frontend
+// methods.
+type Clipboard interface {
+	// SetClipboard stores data in the selection. Empty data clears it.
+	SetClipboard(selection byte, data []byte)
+	// GetClipboard returns the contents of the selection, or ok == false if
+	// it cannot be read.
+	GetClipboard(selection byte) (data []byte, ok bool)
+}
+
+// ClipboardPolicy limits what OSC 52 may do. The zero value allows both
+// reads and writes.
+type ClipboardPolicy struct {
+	// DenyRead ignores queries, so the application cannot read the clipboard.
+	DenyRead bool
+	// DenyWrite ignores requests to set or clear the clipboard.
+	DenyWrite bool
+}
+
+// defaultSelections is used when an OSC 52 request names no selection, as in
+// xterm.
+const defaultSelections = "s0"
+
+// SetClipboard sets the Clipboard that handles OSC 52. If it is nil, a
+// Frontend that implements Clipboard is used instead.
+func (t *terminal) SetClipboard(c Clipboard) {
+	t.WithLock(func() {
+		t.clipboard = c
+	})
+}
+
+// SetClipboardPolicy sets what OSC 52 may do.
+func (t *terminal) SetClipboardPolicy(p ClipboardPolicy) {
+	t.WithLock(func() {
+		t.clipboardPolicy = p
+	})
+}
+
+// clipboardTarget returns the Clipboard for OSC 52 requests, or nil.
+func (t *terminal) clipboardTarget() Clipboard {
+	if t.clipboard != nil {
+		return t.clipboard
+	}
+	if c, ok := t.frontend.(Clipboard); ok {
+		return c
+	}
+	return nil
+}
+
+// oscClipboard handles OSC 52 ; Pc ; Pd, which sets the selections in Pc to
+// the base64 data Pd, or reports the first of them if Pd is "?".
+func (t *terminal) oscClipboard(arg string) bool {
+	targets, data, ok := strings.Cut(arg, ";")
+	if !ok {
+		return false
+	}
+	selections := make([]byte, 0, len(targets))
+	for i := 0; i < len(targets); i++ {
+		if c := targets[i]; strings.IndexByte("cpqs01234567", c) >= 0 {
+			selections = append(selections, c)
+		}
+	}
+	if len(selections) == 0 {
+		selections = append(selections, defaultSelections...)
+	}
+
+	clipboard := t.clipboardTarget()
+	if clipboard == nil {
+		debugPrintln(debugTodo, "TODO: OSC 52 without a Clipboard")
+		return true
+	}
+
+	if data == "?" {
+		if t.clipboardPolicy.DenyRead {
+			return true
+		}
+		content, ok := clipboard.GetClipboard(selections[0])
+		if !ok {
+			return true
+		}
+		t.replyf("\x1b]52;%c;%s%s", selections[0], base64.StdEncoding.EncodeToString(content), t.oscTerminator())
+		return true
+	}
+
+	if t.clipboardPolicy.DenyWrite {
+		return true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		if decoded, err = base64.RawStdEncoding.DecodeString(data); err != nil {
+			debugPrintln(debugErrors, "OSC 52 data is not base64:", err)
+			return false
+		}
+	}
+	for _, sel := range selections {
+		clipboard.SetClipboard(sel, decoded)
+	}
+	return true
+}
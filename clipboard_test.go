@@ -0,0 +1,128 @@
+package termemu
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+type mapClipboard struct {
+	data map[byte]string
+	sets []byte
+}
+
+func (c *mapClipboard) SetClipboard(selection byte, data []byte) {
+	if c.data == nil {
+		c.data = map[byte]string{}
+	}
+	c.data[selection] = string(data)
+	c.sets = append(c.sets, selection)
+}
+
+func (c *mapClipboard) GetClipboard(selection byte) ([]byte, bool) {
+	d, ok := c.data[selection]
+	return []byte(d), ok
+}
+
+// clipboardFrontend is a frontend that also handles OSC 52.
+type clipboardFrontend struct {
+	EmptyFrontend
+	mapClipboard
+}
+
+// readReply reads whatever the terminal has written back to the application.
+func readReply(t *testing.T, r io.Reader) string {
+	t.Helper()
+	buf := make([]byte, 256)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestOSC52_Set(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	cb := &mapClipboard{}
+	t1.SetClipboard(cb)
+
+	t1.mustHandleCommand(t, "]52;c;aGVsbG8=\a")
+	if got := cb.data['c']; got != "hello" {
+		t.Errorf("clipboard c = %q, want hello", got)
+	}
+
+	t1.mustHandleCommand(t, "]52;pc;d29ybGQ\x1b\\")
+	if cb.data['p'] != "world" || cb.data['c'] != "world" {
+		t.Errorf("clipboard = %q, want p and c set to world", cb.data)
+	}
+
+	// No selection means xterm's default, s0.
+	cb.sets = nil
+	t1.mustHandleCommand(t, "]52;;eA==\a")
+	if string(cb.sets) != "s0" || cb.data['s'] != "x" {
+		t.Errorf("default selections = %q, data %q", cb.sets, cb.data)
+	}
+
+	// Empty data clears.
+	t1.mustHandleCommand(t, "]52;c;\a")
+	if got, ok := cb.data['c']; !ok || got != "" {
+		t.Errorf("clipboard c = %q, %v after clearing", got, ok)
+	}
+
+	if err := t1.testHandleCommand(t, "]52;c;!!not base64!!\a"); err == nil {
+		t.Errorf("invalid base64 was accepted")
+	}
+}
+
+func TestOSC52_Query(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	cb := &mapClipboard{data: map[byte]string{'p': "secret"}}
+	t1.SetClipboard(cb)
+
+	// Replies end the way the query did.
+	t1.mustHandleCommand(t, "]52;p;?\a")
+	if got, want := readReply(t, r), "\x1b]52;p;c2VjcmV0\a"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	t1.mustHandleCommand(t, "]52;p;?\x1b\\")
+	if got, want := readReply(t, r), "\x1b]52;p;c2VjcmV0\x1b\\"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+}
+
+func TestOSC52_Policy(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	cb := &mapClipboard{data: map[byte]string{'c': "secret"}}
+	t1.SetClipboard(cb)
+	t1.SetClipboardPolicy(ClipboardPolicy{DenyRead: true, DenyWrite: true})
+
+	t1.mustHandleCommand(t, "]52;c;?\a")
+	t1.mustHandleCommand(t, "]52;c;aGVsbG8=\a")
+	t1.mustHandleCommand(t, "[5n")
+
+	// Only the status report comes back.
+	if got, want := readReply(t, r), "\x1b[0n"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	if cb.data['c'] != "secret" {
+		t.Errorf("write was not denied: %q", cb.data['c'])
+	}
+}
+
+func TestOSC52_FrontendClipboard(t *testing.T) {
+	f := &clipboardFrontend{}
+	t1 := newTerminal(f, NewNoPTYBackend(bytes.NewReader(nil), io.Discard), TextReadModeRune)
+
+	t1.mustHandleCommand(t, "]52;c;aGk=\a")
+	if got := f.data['c']; got != "hi" {
+		t.Errorf("frontend clipboard = %q, want hi", got)
+	}
+
+	// An explicit Clipboard takes precedence.
+	cb := &mapClipboard{}
+	t1.SetClipboard(cb)
+	t1.mustHandleCommand(t, "]52;c;eW8=\a")
+	if cb.data['c'] != "yo" || f.data['c'] != "hi" {
+		t.Errorf("clipboard = %q, frontend = %q", cb.data, f.data)
+	}
+}
@@ -0,0 +1,221 @@
+package termemu
+
+// Damage describes what changed on the active screen since the last
+// TakeDamage.
+type Damage struct {
+	// Scroll is a hint that the whole screen scrolled up by Scroll rows (down
+	// if negative) before Rects were damaged. Rows that only moved are not in
+	// Rects, so a frontend that does not move its old rows itself must redraw
+	// everything when Scroll is not zero.
+	Scroll int
+	// Rects are the damaged regions from top to bottom. Consecutive rows with
+	// the same damaged columns share a rect.
+	Rects []Region
+	// CursorMoved is set if the cursor moved, and Cursor is where it is now.
+	CursorMoved bool
+	Cursor      Pos
+}
+
+// Empty reports whether nothing changed.
+func (d Damage) Empty() bool {
+	return d.Scroll == 0 && len(d.Rects) == 0 && !d.CursorMoved
+}
+
+// scrollNotifier is implemented by frontends that can make use of a scroll
+// instead of a redraw of the rows that moved.
+type scrollNotifier interface {
+	regionScrolled(r Region, dy int)
+}
+
+// regionScrolled tells f that the rows in r are the rows above or below them
+// moved by dy (down if positive). Frontends that cannot use that get a
+// RegionChanged.
+func regionScrolled(f Frontend, r Region, dy int) {
+	if n, ok := f.(scrollNotifier); ok {
+		n.regionScrolled(r, dy)
+		return
+	}
+	f.RegionChanged(r, CRScroll)
+}
+
+// rowDamage is the damaged columns [x1, x2) of a row; x1 >= x2 means none.
+type rowDamage struct {
+	x1, x2 int
+}
+
+// damageTracker stands in for the frontend on both screens while damage
+// tracking is on. It records region changes and cursor moves for TakeDamage;
+// other notifications pass straight through.
+type damageTracker struct {
+	Frontend
+
+	t      *terminal
+	width  int
+	rows   []rowDamage
+	scroll int
+	cursor Pos
+	moved  bool
+}
+
+func newDamageTracker(f Frontend, t *terminal) *damageTracker {
+	d := &damageTracker{Frontend: f, t: t}
+	d.fit()
+	d.markAll()
+	return d
+}
+
+// fit resizes the row set to the active screen, marking everything damaged
+// if the size changed.
+func (d *damageTracker) fit() {
+	size := d.t.screen().Size()
+	if size.X == d.width && size.Y == len(d.rows) {
+		return
+	}
+	d.width = size.X
+	d.rows = make([]rowDamage, size.Y)
+	d.scroll = 0
+	d.markAll()
+}
+
+func (d *damageTracker) markAll() {
+	for y := range d.rows {
+		d.rows[y] = rowDamage{0, d.width}
+	}
+}
+
+func (d *damageTracker) RegionChanged(r Region, cr ChangeReason) {
+	d.fit()
+	r = r.Intersect(Region{X2: d.width, Y2: len(d.rows)})
+	if r.Empty() {
+		return
+	}
+	for y := r.Y; y < r.Y2; y++ {
+		row := &d.rows[y]
+		if row.x1 >= row.x2 {
+			*row = rowDamage{r.X, r.X2}
+			continue
+		}
+		row.x1 = min(row.x1, r.X)
+		row.x2 = max(row.x2, r.X2)
+	}
+}
+
+func (d *damageTracker) CursorMoved(x, y int) {
+	d.cursor = Pos{X: x, Y: y}
+	d.moved = true
+}
+
+// regionScrolled turns a scroll of the whole screen into a scroll hint and
+// moves the damage recorded so far with the rows. Other scrolls are damage.
+func (d *damageTracker) regionScrolled(r Region, dy int) {
+	d.fit()
+	h := len(d.rows)
+	whole := r.X == 0 && r.X2 == d.width &&
+		((dy < 0 && r.Y == 0 && r.Y2 == h+dy) || (dy > 0 && r.Y == dy && r.Y2 == h))
+	if !whole {
+		d.RegionChanged(r, CRScroll)
+		return
+	}
+	if dy < 0 {
+		copy(d.rows, d.rows[-dy:])
+		clear(d.rows[h+dy:])
+	} else {
+		copy(d.rows[dy:], d.rows)
+		clear(d.rows[:dy])
+	}
+	d.scroll -= dy
+	if d.scroll >= h || d.scroll <= -h {
+		d.scroll = 0
+		d.markAll()
+	}
+}
+
+// take returns the damage and starts over.
+func (d *damageTracker) take() Damage {
+	d.fit()
+	dmg := Damage{Scroll: d.scroll, CursorMoved: d.moved, Cursor: d.cursor}
+	for y, row := range d.rows {
+		if row.x1 >= row.x2 {
+			continue
+		}
+		if n := len(dmg.Rects); n > 0 {
+			last := &dmg.Rects[n-1]
+			if last.Y2 == y && last.X == row.x1 && last.X2 == row.x2 {
+				last.Y2++
+				continue
+			}
+		}
+		dmg.Rects = append(dmg.Rects, Region{X: row.x1, Y: y, X2: row.x2, Y2: y + 1})
+	}
+	clear(d.rows)
+	d.scroll = 0
+	d.moved = false
+	return dmg
+}
+
+// SetDamageTracking switches between pushed and pulled screen updates. While
+// it is on, the Frontend gets no RegionChanged or CursorMoved calls and should
+// call TakeDamage at its own frame rate instead. The first TakeDamage covers
+// the whole screen. Turning it off sends the Frontend a full redraw.
+func (t *terminal) SetDamageTracking(on bool) {
+	t.WithLock(func() {
+		if on == (t.damage != nil) {
+			return
+		}
+		if on {
+			t.damage = newDamageTracker(t.frontend, t)
+		} else {
+			t.damage = nil
+		}
+		if t.sync != nil {
+			t.sync.Frontend = t.notifyTarget()
+		} else {
+			t.attachScreens()
+		}
+		if !on {
+			size := t.screen().Size()
+			t.regionChanged(Region{X2: size.X, Y2: size.Y}, CRRedraw)
+			pos := t.screen().CursorPos()
+			if t.sync != nil {
+				t.sync.CursorMoved(pos.X, pos.Y)
+			} else {
+				t.frontend.CursorMoved(pos.X, pos.Y)
+			}
+		}
+	})
+}
+
+// TakeDamage returns what changed on the active screen since the last call
+// and clears it. It returns nothing while damage tracking is off or a
+// synchronized output frame is being drawn.
+// TakeDamage locks the terminal, so it must not be called from a Frontend
+// method.
+func (t *terminal) TakeDamage() Damage {
+	var d Damage
+	t.WithLock(func() {
+		if t.damage != nil && t.sync == nil {
+			d = t.damage.take()
+		}
+	})
+	return d
+}
+
+// notifyTarget returns where screen changes go outside synchronized output:
+// the damage tracker if it is on, or else the frontend.
+func (t *terminal) notifyTarget() Frontend {
+	if t.damage != nil {
+		return t.damage
+	}
+	return t.frontend
+}
+
+// attachScreens points both screens at whatever should receive their
+// notifications now.
+func (t *terminal) attachScreens() {
+	f := t.notifyTarget()
+	if t.sync != nil {
+		f = t.sync
+	}
+	t.mainScreen.SetFrontend(f)
+	t.altScreen.SetFrontend(f)
+}
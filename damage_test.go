@@ -0,0 +1,175 @@
+package termemu
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDamage_InitialAndMerged(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		mf := t1.frontend.(*MockFrontend)
+		_ = t1.Resize(20, 5)
+
+		t1.SetDamageTracking(true)
+		d := t1.TakeDamage()
+		if want := []Region{{X2: 20, Y2: 5}}; !reflect.DeepEqual(d.Rects, want) {
+			t.Fatalf("initial damage = %v, want %v", d.Rects, want)
+		}
+		if d := t1.TakeDamage(); !d.Empty() {
+			t.Fatalf("damage after taking it = %+v, want none", d)
+		}
+
+		mf.Regions = nil
+		moves := mf.CursorMovedCount
+		feed(t, t1, "\x1b[2;3Habc\x1b[3;3Hxyz\x1b[5;1Hq")
+		if len(mf.Regions) != 0 || mf.CursorMovedCount != moves {
+			t.Errorf("frontend got %d region changes and %d cursor moves while tracking", len(mf.Regions), mf.CursorMovedCount-moves)
+		}
+
+		d = t1.TakeDamage()
+		want := []Region{{X: 2, Y: 1, X2: 5, Y2: 3}, {X: 0, Y: 4, X2: 1, Y2: 5}}
+		if !reflect.DeepEqual(d.Rects, want) {
+			t.Errorf("damage = %v, want %v", d.Rects, want)
+		}
+		if !d.CursorMoved || d.Cursor != (Pos{X: 1, Y: 4}) {
+			t.Errorf("cursor = %v (moved %v), want 1,4", d.Cursor, d.CursorMoved)
+		}
+	})
+}
+
+func TestDamage_ScrollHint(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(10, 4)
+		t1.SetDamageTracking(true)
+		feed(t, t1, "\x1b[2;1Hold\x1b[4;1H")
+		t1.TakeDamage()
+
+		feed(t, t1, "\x1b[1;1Hx\x1b[4;1H\nab\nc")
+		d := t1.TakeDamage()
+		if d.Scroll != 2 {
+			t.Fatalf("scroll = %d, want 2", d.Scroll)
+		}
+		// The change to the top row scrolled off; only the new rows are damaged.
+		for _, r := range d.Rects {
+			if r.Y < 2 {
+				t.Errorf("moved rows reported as damaged: %v", d.Rects)
+			}
+		}
+	})
+}
+
+func TestDamage_ScrollRegionIsDamage(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 6)
+	t1.SetDamageTracking(true)
+	t1.TakeDamage()
+
+	feed(t, t1, "\x1b[2;4r\x1b[4;1H\n")
+	d := t1.TakeDamage()
+	if d.Scroll != 0 {
+		t.Errorf("scroll = %d for a partial scroll region, want 0", d.Scroll)
+	}
+	if len(d.Rects) != 1 || d.Rects[0].Y != 1 || d.Rects[0].Y2 != 4 {
+		t.Errorf("damage = %v, want rows 1-3", d.Rects)
+	}
+}
+
+func TestDamage_TurnOff(t *testing.T) {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(20, 5)
+	t1.SetDamageTracking(true)
+	feed(t, t1, "abc")
+	mf.Regions = nil
+
+	t1.SetDamageTracking(false)
+	if len(mf.Regions) != 1 || mf.Regions[0].R != (Region{X2: 20, Y2: 5}) || mf.Regions[0].C != CRRedraw {
+		t.Fatalf("regions after turning tracking off = %v, want one full redraw", mf.Regions)
+	}
+	if mf.CursorX != 3 || mf.CursorY != 0 {
+		t.Errorf("cursor = %d,%d, want 3,0", mf.CursorX, mf.CursorY)
+	}
+	if d := t1.TakeDamage(); !d.Empty() {
+		t.Errorf("TakeDamage with tracking off = %+v", d)
+	}
+	feed(t, t1, "d")
+	if len(mf.Regions) != 2 {
+		t.Errorf("got %d region changes, want 2", len(mf.Regions))
+	}
+}
+
+func TestDamage_SyncOutput(t *testing.T) {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(20, 5)
+	t1.SetDamageTracking(true)
+	t1.TakeDamage()
+	mf.Regions = nil
+
+	feed(t, t1, "\x1b[?2026h\x1b[3;1Habc")
+	if d := t1.TakeDamage(); !d.Empty() {
+		t.Fatalf("damage during synchronized output = %+v", d)
+	}
+	feed(t, t1, "\x1b[?2026l")
+	d := t1.TakeDamage()
+	if want := []Region{{Y: 2, X2: 3, Y2: 3}}; !reflect.DeepEqual(d.Rects, want) {
+		t.Errorf("damage after the frame = %v, want %v", d.Rects, want)
+	}
+	if len(mf.Regions) != 0 {
+		t.Errorf("frontend got region changes: %v", mf.Regions)
+	}
+}
+
+func TestDamage_ResetFromAltScreen(t *testing.T) {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(20, 5)
+	feed(t, t1, "\x1b[?1049h")
+	t1.SetDamageTracking(true)
+	t1.TakeDamage()
+	mf.Regions = nil
+
+	feed(t, t1, "\x1bc")
+	if len(mf.Regions) != 0 {
+		t.Errorf("frontend got region changes: %v", mf.Regions)
+	}
+	d := t1.TakeDamage()
+	if want := []Region{{X2: 20, Y2: 5}}; !reflect.DeepEqual(d.Rects, want) {
+		t.Errorf("damage after RIS = %v, want %v", d.Rects, want)
+	}
+}
+
+func TestTTYFrontend_Pull(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 3)
+	var out bytes.Buffer
+	tty := NewTTYFrontend(t1, &out)
+	t1.SetFrontend(tty)
+	tty.Attach(Region{X2: 10, Y2: 3})
+	tty.SetPull(true)
+	tty.Render()
+	out.Reset()
+
+	feed(t, t1, "\x1b[2;4Hhi")
+	if out.Len() != 0 {
+		t.Fatalf("wrote %q before Render", out.String())
+	}
+	tty.Render()
+	got := out.String()
+	if !strings.Contains(got, ansiMoveCursor(3, 1)) || !strings.Contains(got, "hi") {
+		t.Errorf("Render output %q does not redraw the change", got)
+	}
+	if strings.Contains(got, ansiMoveCursor(0, 0)) {
+		t.Errorf("Render output %q redraws undamaged rows", got)
+	}
+	if !strings.HasSuffix(got, ansiMoveCursor(5, 1)+ansiCursorShow) {
+		t.Errorf("Render output %q does not end at the cursor", got)
+	}
+
+	out.Reset()
+	tty.Render()
+	if out.Len() != 0 {
+		t.Errorf("Render with no damage wrote %q", out.String())
+	}
+}
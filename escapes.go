@@ -3,7 +3,6 @@ package termemu
 import (
 	"bufio"
 	"bytes"
-	"fmt"
 	"io"
 	"strconv"
 )
@@ -19,12 +18,25 @@ var colorModes = [7]Mode{
 	ModeInvisible, // SGR 8
 }
 
+// idleBackend is implemented by backends that have work to do between
+// reads, such as ReplayBackend, which waits for recorded events there.
+type idleBackend interface {
+	// idle is called without the terminal locked once all the output read so
+	// far has been handled, before reading more.
+	idle()
+}
+
 func (t *terminal) ptyReadLoop() {
 	reader := bufio.NewReader(t.backend)
 	gr := NewGraphemeReaderWithMode(reader, t.textReadMode)
+	idler, _ := unwrapBackend[idleBackend](t.backend)
 
 	for {
+		if idler != nil && gr.Buffered() == 0 && reader.Buffered() == 0 {
+			idler.idle()
+		}
 		if err := t.ptyReadOne(gr); err != nil {
+			t.finish(t.readLoopError(err))
 			return
 		}
 	}
@@ -38,7 +50,12 @@ func (t *terminal) ptyReadOne(gr *GraphemeReader) error {
 		maxWidth := 0
 		t.WithLock(func() {
 			if t.screen().AutoWrap() {
-				maxWidth = t.screen().Size().X - t.screen().CursorPos().X
+				x := t.screen().CursorPos().X
+				edge := t.screen().Size().X
+				if x <= t.screen().RightMargin() {
+					edge = t.screen().RightMargin() + 1
+				}
+				maxWidth = edge - x
 				if maxWidth < 1 {
 					maxWidth = 1
 				}
@@ -53,7 +70,11 @@ func (t *terminal) ptyReadOne(gr *GraphemeReader) error {
 		}
 		if len(data) > 0 {
 			t.WithLock(func() {
+				if !merge {
+					data = t.charsets().translateString(data)
+				}
 				bw.writeString(data, width, merge, t.textReadMode)
+				t.notifyWaiters()
 			})
 			if *debugTxt {
 				debugPrintf(debugTxt, "\033[32mtxt: %#v\033[0m %v\n", data, len(data))
@@ -70,7 +91,9 @@ func (t *terminal) ptyReadOne(gr *GraphemeReader) error {
 		}
 		if len(tokens) > 0 {
 			t.WithLock(func() {
+				t.charsets().translateTokens(tokens)
 				t.screen().writeTokens(tokens)
+				t.notifyWaiters()
 			})
 			if *debugTxt {
 				var buf bytes.Buffer
@@ -91,77 +114,88 @@ func (t *terminal) ptyReadOne(gr *GraphemeReader) error {
 		return err
 	}
 
+	if b != asciiESC {
+		pending := false
+		t.WithLock(func() {
+			t.execute(b)
+			t.notifyWaiters()
+			pending = len(t.replies) > 0
+		})
+		if pending {
+			t.flushReplies()
+		}
+		return nil
+	}
+
+	t.WithLock(func() {
+		if *debugCmd || *debugTodo {
+			var cmdBytes bytes.Buffer
+			cmdReader := &captureReader{r: gr, buf: &cmdBytes}
+			success := t.handleCommand(cmdReader)
+			cmd := cmdBytes.Bytes()
+
+			if success {
+				debugPrintf(debugCmd, "%v cmd: %#v\n", t.screen().CursorPos(), string(cmd))
+			} else {
+				debugPrintf(debugTodo, "TODO: Unhandled command: %#v\n", string(cmd))
+			}
+		} else {
+			_ = t.handleCommand(gr)
+		}
+		t.notifyWaiters()
+	})
+	t.flushReplies()
+	return nil
+}
+
+// execute performs a C0 control character other than ESC. The caller must
+// hold the lock.
+func (t *terminal) execute(b byte) {
 	// ABCDEFGHIJKLMNOPQRSTUVWXYZ
 
 	switch b {
 	case 0: // NUL Null byte, ignore
 
 	case 5: // ENQ ^E Return Terminal Status
-		debugPrintln(debugTodo, "TODO: ENQ")
+		if t.answerback != "" {
+			t.replyf("%s", t.answerback)
+		}
 	case 7: // BEL ^G Bell
-		t.WithLock(func() {
-			t.frontend.Bell()
-		})
+		t.frontend.Bell()
 
 	case 8: // BS ^H Backspace
-		t.WithLock(func() {
-			t.screen().moveCursor(-1, 0, false, false)
-		})
+		t.screen().moveCursor(-1, 0, false, false)
 
 	case 9: // HT ^I Horizontal TAB
-		t.WithLock(func() {
-			cursorPos := t.screen().CursorPos()
-			cursorPos.X = ((cursorPos.X >> 3) + 1) << 3
-			t.screen().setCursorPos(cursorPos.X, cursorPos.Y)
-		})
+		t.tabForward(1)
 
 	case 10: // LF ^J Linefeed (newline)
-		t.WithLock(func() {
-			cursorPos := t.screen().CursorPos()
-			t.screen().setCursorPos(0, cursorPos.Y)
-			t.screen().moveCursor(0, 1, true, true)
-		})
+		cursorPos := t.screen().CursorPos()
+		t.screen().setCursorPos(t.lineStart(), cursorPos.Y)
+		t.screen().moveCursor(0, 1, true, true)
 
 	case 11: // VT ^K Vertical TAB
 		debugPrintln(debugTodo, "TODO: vtab")
 
 	case 12: // FF ^L Formfeed (also: New page NP)
-		t.WithLock(func() {
-			t.screen().moveCursor(0, 1, false, true)
-		})
+		t.screen().moveCursor(0, 1, false, true)
 
 	case 13: // CR ^M Carriage Return
-		t.WithLock(func() {
-			t.screen().moveCursor(-t.screen().CursorPos().X, 0, true, true)
-		})
+		t.screen().moveCursor(t.lineStart()-t.screen().CursorPos().X, 0, true, true)
 
-	case 27: // ESC ^[ Escape Character
+	case 14: // SO ^N Shift Out, invoke G1 into GL
+		t.charsets().gl = 1
 
-		t.WithLock(func() {
-			if *debugCmd || *debugTodo {
-				var cmdBytes bytes.Buffer
-				cmdReader := &captureReader{r: gr, buf: &cmdBytes}
-				success := t.handleCommand(cmdReader)
-				cmd := cmdBytes.Bytes()
-
-				if success {
-					debugPrintf(debugCmd, "%v cmd: %#v\n", t.screen().CursorPos(), string(cmd))
-				} else {
-					debugPrintf(debugTodo, "TODO: Unhandled command: %#v\n", string(cmd))
-				}
-			} else {
-				_ = t.handleCommand(gr)
-			}
-		})
+	case 15: // SI ^O Shift In, invoke G0 into GL
+		t.charsets().gl = 0
 
-	case 127: // DEL  Delete Character (treat as backspace)
-		t.WithLock(func() {
-			t.screen().moveCursor(-1, 0, false, false)
-		})
+	case asciiCAN, asciiSUB: // Cancel, outside a sequence there is nothing to cancel
+
+	case asciiDEL: // DEL  Delete Character (treat as backspace)
+		t.screen().moveCursor(-1, 0, false, false)
 	default:
 		debugPrintf(debugTodo, "TODO: unhandled char %v %#v\n", b, string(b))
 	}
-	return nil
 }
 
 type escapeReader interface {
@@ -186,20 +220,72 @@ func (c *captureReader) ReadByte() (byte, error) {
 // 	return c.r.Buffered()
 // }
 
+// handleCommand handles everything after an ESC, up to the end of the escape
+// sequence, control sequence or control string it starts. The caller must
+// hold the lock.
 func (t *terminal) handleCommand(r escapeReader) bool {
-	b, err := r.ReadByte()
-	if err != nil {
-		if err != io.EOF {
-			debugPrintln(debugErrors, "ERR ReadByte3:", err)
+	for {
+		ok, restart := t.handleEscape(r)
+		if !restart {
+			return ok
 		}
-		return false
+	}
+}
+
+// handleEscape handles one sequence after an ESC. restart reports that it was
+// ended by another ESC, which starts the next sequence.
+func (t *terminal) handleEscape(r escapeReader) (ok, restart bool) {
+	seq := &t.seq
+	seq.reset()
+
+	var b byte
+	for {
+		var err error
+		b, err = r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				debugPrintln(debugErrors, "ERR ReadByte3:", err)
+			}
+			return false, false
+		}
+
+		switch {
+		case b >= 0x20 && b <= 0x2f:
+			seq.intermediates = append(seq.intermediates, b)
+			continue
+		case b == asciiCAN || b == asciiSUB:
+			return true, false
+		case b == asciiESC:
+			seq.reset()
+			continue
+		case b < 0x20:
+			t.execute(b)
+			continue
+		case b == asciiDEL:
+			continue
+		}
+		break
+	}
+
+	if len(seq.intermediates) > 0 {
+		seq.final = b
+		return t.handleEscIntermediate(seq), false
 	}
 
 	// short commands
 	switch b {
 
-	case 'c': // reset
-		debugPrintln(debugTodo, "TODO: cmd: reset") // TODO
+	case '7': // DECSC Save cursor
+		t.saveCursor()
+
+	case '8': // DECRC Restore cursor
+		t.restoreCursor()
+
+	case 'c': // RIS Full reset
+		t.reset()
+
+	case 'H': // HTS Horizontal Tab Set
+		t.screen().tabStops().set(t.screen().CursorPos().X)
 
 	case 'D': // Index, scroll down if necessary
 		t.screen().moveCursor(0, 1, false, true)
@@ -216,22 +302,20 @@ func (t *terminal) handleCommand(r escapeReader) bool {
 	case ']': // OSC Operating System Commands
 		return t.handleCmdOSC(r)
 
-	case '(': // G0
-		fallthrough
-	case ')': // G1
-		fallthrough
-	case '*': // G2
-		fallthrough
-	case '+': // G3
-		C, err := r.ReadByte()
-		if err != nil {
-			if err != io.EOF {
-				debugPrintln(debugErrors, "ERR ReadByte4:", err)
-			}
-			return false
-		}
-		_ = C
-		return true
+	case 'X', '^', '_': // SOS Start of String, PM Privacy Message, APC Application Program Command
+		return t.handleIgnoredString(r)
+
+	case 'N': // SS2 Single shift G2
+		t.charsets().single = 2
+
+	case 'O': // SS3 Single shift G3
+		t.charsets().single = 3
+
+	case 'n': // LS2 Locking shift G2
+		t.charsets().gl = 2
+
+	case 'o': // LS3 Locking shift G3
+		t.charsets().gl = 3
 
 	case '=': // Application Keypad
 		t.setViewFlag(VFAppKeypad, true)
@@ -240,7 +324,7 @@ func (t *terminal) handleCommand(r escapeReader) bool {
 		t.setViewFlag(VFAppKeypad, false)
 
 	case '\\': // ST String Terminator
-		return true
+		return true, false
 
 	/*case 'l': // Memory Lock
 		debugPrintln("Memory Lock") // TODO
@@ -249,788 +333,871 @@ func (t *terminal) handleCommand(r escapeReader) bool {
 		debugPrintln("Memory Unlock") // TODO*/
 
 	default:
-		return false
+		return false, false
 	}
-	return true
+	return true, false
 }
 
-func (t *terminal) handleCmdCSI(r escapeReader) bool {
-
-	b, err := r.ReadByte()
-	if err != nil {
-		if err != io.EOF {
-			debugPrintln(debugErrors, "ERR ReadByte5:", err)
+// handleEscIntermediate handles an escape sequence with intermediate bytes,
+// such as a character set designation.
+func (t *terminal) handleEscIntermediate(seq *sequence) bool {
+	switch i := seq.intermediates[0]; i {
+	case '(', ')', '*', '+': // Designate G0, G1, G2, G3 character set
+		cs, ok := charsetForFinal(seq.final)
+		if !ok {
+			// Like xterm, leave the designation alone.
+			debugPrintf(debugTodo, "TODO: Unhandled charset %#v\n", string(seq.intermediates[1:])+string(seq.final))
+			return true
 		}
-		return false
+		t.charsets().g[i-'('] = cs
+		return true
 	}
+	return false
+}
 
-	prefix := byte(0)
-	if b == '?' || b == '>' || b == '<' || b == '=' {
-		prefix = b
-		b, err = r.ReadByte()
-		if err != nil {
-			if err != io.EOF {
-				debugPrintln(debugErrors, "ERR ReadByte6:", err)
-			}
-			return false
-		}
+// handleCmdCSI reads a control sequence and dispatches it through csiHandlers.
+func (t *terminal) handleCmdCSI(r escapeReader) (ok, restart bool) {
+	seq := &t.seq
+	switch t.readControl(r, seq, true) {
+	case parseDispatch:
+	case parseEscape:
+		return true, true
+	case parseFailed:
+		return false, false
+	default: // cancelled or malformed
+		return true, false
 	}
 
-	// This cannot escape to the heap! Use append([]int(nil), params...) instead of params to make a copy if needed, such as to debug calls
-	var paramStore [8]int
-	paramCount := 0
-	param := 0
-	paramSet := false
-	sawSeparator := false
-	for b == ';' || (b >= '0' && b <= '9') {
-		if b == ';' {
-			value := 0
-			if paramSet || sawSeparator || paramCount > 0 {
-				value = param
-			}
-			if paramCount < len(paramStore) {
-				paramStore[paramCount] = value
-				paramCount++
-			}
-			param = 0
-			paramSet = false
-			sawSeparator = true
-		} else {
-			param = param*10 + int(b-'0')
-			paramSet = true
-			sawSeparator = false
-		}
+	if h, ok := csiHandlers[seq.key()]; ok {
+		return h(t, seq), false
+	}
+	debugPrintf(debugTodo, "TODO: Unhandled CSI command: %v\n", seq)
+	return true, false
+}
 
-		b, err = r.ReadByte()
-		if err != nil {
-			if err != io.EOF {
-				debugPrintln(debugErrors, "ERR ReadByte7:", err)
-			}
-			return false
-		}
+// handleCmdOSC reads an operating system command and dispatches it through
+// oscHandlers.
+func (t *terminal) handleCmdOSC(r escapeReader) (ok, restart bool) {
+	seq := &t.seq
+	seq.reset()
+	res, tooLong := t.readString(r, seq, true, true)
+	switch res {
+	case parseDispatch, parseEscape:
+	case parseFailed:
+		return false, false
+	default:
+		return true, false
 	}
-	if paramSet || sawSeparator {
-		value := 0
-		if paramSet {
-			value = param
-		}
-		if paramCount < len(paramStore) {
-			paramStore[paramCount] = value
-			paramCount++
-		}
+	restart = res == parseEscape
+	if tooLong {
+		debugPrintln(debugErrors, "OSC string too long, ignored")
+		return true, restart
 	}
 
-	params := paramStore[:paramCount]
+	num, arg, _ := bytes.Cut(seq.data, []byte{';'})
+	cmd, err := strconv.Atoi(string(num))
+	if err != nil {
+		debugPrintf(debugTodo, "TODO: Unhandled OSC command: %q\n", seq.data)
+		return true, restart
+	}
+	h, ok := oscHandlers[cmd]
+	if !ok {
+		debugPrintln(debugTodo, "TODO: Unhandled OSC Command: ", cmd, string(arg))
+		return true, restart
+	}
+	if *debugCmd {
+		debugPrintf(debugCmd, "OSC %d: %q\n", cmd, string(arg))
+	}
+	return h(t, string(arg)), restart
+}
 
-	if prefix == 0 {
-		switch b {
-		case 'A': // Move cursor up
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
-			t.screen().moveCursor(0, -params[0], false, false)
-		case 'B': // Move cursor down
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
-			t.screen().moveCursor(0, params[0], false, true)
-		case 'C': // Move cursor forward
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
-			t.screen().moveCursor(params[0], 0, false, false)
-		case 'D': // Move cursor backward
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
-			t.screen().moveCursor(-params[0], 0, false, false)
+// handleDCS reads a device control string and dispatches it through
+// dcsHandlers.
+func (t *terminal) handleDCS(r escapeReader) (ok, restart bool) {
+	seq := &t.seq
+	switch t.readControl(r, seq, false) {
+	case parseDispatch:
+	case parseEscape:
+		return true, true
+	case parseFailed:
+		return false, false
+	case parseCancel:
+		return true, false
+	case parseIgnore: // malformed header, skip the payload
+		return t.handleIgnoredString(r)
+	}
 
-		case 'G': // Cursor Character Absolute
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
-			t.screen().setCursorPos(params[0]-1, t.screen().CursorPos().Y)
+	res, tooLong := t.readString(r, seq, true, false)
+	switch res {
+	case parseDispatch, parseEscape:
+	case parseFailed:
+		return false, false
+	default:
+		return true, false
+	}
+	restart = res == parseEscape
+	if tooLong {
+		debugPrintln(debugErrors, "DCS string too long, ignored")
+		return true, restart
+	}
 
-		case 'c': // Send Device Attributes
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
-			switch params[0] {
-			case 0:
-				_, _ = t.Write([]byte("\033[?1;2c"))
-			}
+	if h, ok := dcsHandlers[seq.key()]; ok {
+		return h(t, seq), restart
+	}
+	debugPrintf(debugTodo, "TODO: Unhandled DCS: %v\n", seq)
+	return true, restart
+}
 
-		case 'd': // Line Position Absolute
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
-			t.screen().setCursorPos(t.screen().CursorPos().X, params[0]-1)
+// handleIgnoredString reads and discards a control string.
+func (t *terminal) handleIgnoredString(r escapeReader) (ok, restart bool) {
+	res, _ := t.readString(r, &t.seq, false, false)
+	return res != parseFailed, res == parseEscape
+}
 
-		case 'f', 'H': // Cursor Home
-			x := 1
-			y := 1
-			if len(params) >= 1 {
-				y = params[0]
-			}
-			if len(params) >= 2 {
-				x = params[1]
-			}
-			// debugPrintf("cursor home: %v, %v\n", x, y)
-			t.screen().setCursorPos(x-1, y-1)
+// oscHandlers maps OSC command numbers to their handlers.
+var oscHandlers = map[int]oscHandler{
+	0:   (*terminal).oscSetWindowTitle,
+	2:   (*terminal).oscSetWindowTitle,
+	4:   (*terminal).oscSetPaletteColor,
+	6:   (*terminal).oscSetCurrentDirectory,
+	7:   (*terminal).oscSetCurrentFile,
+	8:   (*terminal).oscHyperlink,
+	10:  oscDynamicColor(10),
+	11:  oscDynamicColor(11),
+	12:  oscDynamicColor(12),
+	52:  (*terminal).oscClipboard,
+	104: (*terminal).oscResetPaletteColor,
+	110: oscResetDynamicColor(PaletteForeground),
+	111: oscResetDynamicColor(PaletteBackground),
+	112: oscResetDynamicColor(PaletteCursor),
+	133: (*terminal).oscShellIntegration,
+}
 
-		case 'h', 'l': // h=Set, l=Reset Mode
-			var value bool
-			if b == 'h' {
-				value = true
-			}
+func (t *terminal) oscSetWindowTitle(arg string) bool {
+	t.setViewString(VSWindowTitle, arg)
+	return true
+}
 
-			if len(params) != 1 {
-				debugPrintln(debugTodo, "TODO: Unhandled CSI mode params: ", append([]int(nil), params...), b)
-				return false
-			}
+func (t *terminal) oscSetCurrentDirectory(arg string) bool {
+	t.setViewString(VSCurrentDirectory, arg)
+	return true
+}
 
-			switch params[0] {
-			case 4:
-				debugPrintln(debugTodo, "TODO: Insert Mode = ", value) // TODO
-			default:
-				debugPrintln(debugTodo, "TODO: Unhandled CSI mode param: ", params[0])
-				return false
-			}
+func (t *terminal) oscSetCurrentFile(arg string) bool {
+	t.setViewString(VSCurrentFile, arg)
+	return true
+}
 
-		case 'm': // Set color/mode
-			if paramCount == 0 {
-				paramStore[0] = 0
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
+// dcsHandlers maps device control strings to their handlers. The payload is
+// in seq.data.
+var dcsHandlers = map[csiKey]csiHandler{
+	{intermediate: '$', final: 'q'}: (*terminal).dcsRequestStatusString,
+	{intermediate: '+', final: 'q'}: (*terminal).dcsRequestTermcap,
+}
 
-			style := t.screen().Style()
+// csiHandlers maps control sequences to their handlers. To support a new
+// sequence, add its key and a handler here.
+var csiHandlers = map[csiKey]csiHandler{
+	{final: 'A'}: (*terminal).csiCursorUp,
+	{final: 'B'}: (*terminal).csiCursorDown,
+	{final: 'C'}: (*terminal).csiCursorForward,
+	{final: 'D'}: (*terminal).csiCursorBackward,
+	{final: 'G'}: (*terminal).csiCursorColumn,
+	{final: 'd'}: (*terminal).csiCursorLine,
+	{final: 'H'}: (*terminal).csiCursorPosition,
+	{final: 'f'}: (*terminal).csiCursorPosition,
+	{final: '@'}: (*terminal).csiInsertChars,
+	{final: 'P'}: (*terminal).csiDeleteChars,
+	{final: 'X'}: (*terminal).csiEraseChars,
+	{final: 'K'}: (*terminal).csiEraseInLine,
+	{final: 'J'}: (*terminal).csiEraseInDisplay,
+	{final: 'L'}: (*terminal).csiInsertLines,
+	{final: 'M'}: (*terminal).csiDeleteLines,
+	{final: 'S'}: (*terminal).csiScrollUp,
+	{final: 'T'}: (*terminal).csiScrollDown,
+	{final: 'I'}: (*terminal).csiTabForward,
+	{final: 'Z'}: (*terminal).csiTabBackward,
+	{final: 'g'}: (*terminal).csiTabClear,
+	{final: 'c'}: (*terminal).csiPrimaryDeviceAttributes,
+	{final: 'n'}: (*terminal).csiDeviceStatusReport,
+	{final: 'h'}: (*terminal).csiSetMode,
+	{final: 'l'}: (*terminal).csiSetMode,
+	{final: 'm'}: (*terminal).csiSetGraphicRendition,
+	{final: 'r'}: (*terminal).csiSetTopBottomMargins,
+	{final: 's'}: (*terminal).csiSetLeftRightMarginsOrSaveCursor,
+	{final: 'u'}: (*terminal).csiRestoreCursor,
+	{final: 't'}: (*terminal).csiWindowManipulation,
+	{final: '%'}: (*terminal).csiIgnore, // Select character set
+
+	{intermediate: '!', final: 'p'}: (*terminal).csiSoftReset,
+	{intermediate: ' ', final: 'q'}: (*terminal).csiSetCursorStyle,
+	{intermediate: '$', final: 'p'}: (*terminal).csiRequestMode,
+
+	{prefix: '?', final: 'h'}:                    (*terminal).csiSetPrivateMode,
+	{prefix: '?', final: 'l'}:                    (*terminal).csiSetPrivateMode,
+	{prefix: '?', final: 'm'}:                    (*terminal).csiIgnore, // Private SGR
+	{prefix: '?', final: 'u'}:                    (*terminal).csiQueryKeyboardFlags,
+	{prefix: '?', intermediate: '$', final: 'p'}: (*terminal).csiRequestMode,
+
+	{prefix: '>', final: 'c'}: (*terminal).csiSecondaryDeviceAttributes,
+	{prefix: '>', final: 'm'}: (*terminal).csiModifyOtherKeys,
+	{prefix: '>', final: 'u'}: (*terminal).csiPushKeyboardFlags,
+
+	{prefix: '<', final: 'u'}: (*terminal).csiPopKeyboardFlags,
+	{prefix: '<', final: 'M'}: (*terminal).csiIgnore, // SGR mouse report
+	{prefix: '<', final: 'm'}: (*terminal).csiIgnore, // SGR mouse report
+
+	{prefix: '=', final: 'u'}: (*terminal).csiSetKeyboardFlags,
+}
 
-			for i := 0; i < len(params); i++ {
-				p := params[i]
-				switch {
-				case p == 0: // reset mode
-					style.ResetAll()
+func (t *terminal) csiIgnore(seq *sequence) bool {
+	return true
+}
 
-				case p >= 1 && p <= 5:
-					style.SetMode(colorModes[p-1])
+func (t *terminal) csiCursorUp(seq *sequence) bool {
+	t.screen().moveCursor(0, -seq.param(0, 1), false, false)
+	return true
+}
 
-				case p == 6: // rapid blink
-					style.SetMode(ModeRapidBlink)
+func (t *terminal) csiCursorDown(seq *sequence) bool {
+	t.screen().moveCursor(0, seq.param(0, 1), false, true)
+	return true
+}
 
-				case p == 7:
-					style.SetMode(ModeReverse)
+func (t *terminal) csiCursorForward(seq *sequence) bool {
+	t.screen().moveCursor(seq.param(0, 1), 0, false, false)
+	return true
+}
 
-				case p == 8:
-					style.SetMode(ModeInvisible)
+func (t *terminal) csiCursorBackward(seq *sequence) bool {
+	t.screen().moveCursor(-seq.param(0, 1), 0, false, false)
+	return true
+}
 
-				case p == 9: // strikethrough / crossed-out
-					style.SetMode(ModeStrike)
+// csiCursorColumn is CHA Cursor Character Absolute.
+func (t *terminal) csiCursorColumn(seq *sequence) bool {
+	t.screen().setCursorPos(t.originX(seq.param(0, 1)-1), t.screen().CursorPos().Y)
+	return true
+}
 
-				case p == 21: // double underline
-					style.SetMode(ModeDoubleUnderline)
+// csiCursorLine is VPA Line Position Absolute.
+func (t *terminal) csiCursorLine(seq *sequence) bool {
+	t.screen().setCursorPos(t.screen().CursorPos().X, t.originY(seq.param(0, 1)-1))
+	return true
+}
 
-				case p == 22:
-					style.ResetMode(ModeBold, ModeDim)
+// csiCursorPosition is CUP Cursor Position.
+func (t *terminal) csiCursorPosition(seq *sequence) bool {
+	y := seq.param(0, 1)
+	x := seq.param(1, 1)
+	t.screen().setCursorPos(t.originX(x-1), t.originY(y-1))
+	return true
+}
 
-				case p == 23:
-					style.ResetMode(ModeItalic)
+// csiInsertChars is ICH Insert Characters.
+func (t *terminal) csiInsertChars(seq *sequence) bool {
+	pos := t.screen().CursorPos()
+	t.screen().insertChars(pos.X, pos.Y, max(seq.param(0, 1), 1), CRText)
+	return true
+}
 
-				case p == 24:
-					style.ResetMode(ModeUnderline, ModeDoubleUnderline)
+// csiDeleteChars is DCH Delete Characters.
+func (t *terminal) csiDeleteChars(seq *sequence) bool {
+	t.screen().deleteChars(t.screen().CursorPos().X, t.screen().CursorPos().Y, seq.param(0, 1), CRClear)
+	return true
+}
 
-				case p == 25:
-					style.ResetMode(ModeBlink, ModeRapidBlink)
+// csiEraseChars is ECH Erase Characters, from the cursor to the right.
+func (t *terminal) csiEraseChars(seq *sequence) bool {
+	t.screen().eraseRegion(Region{
+		X:  t.screen().CursorPos().X,
+		Y:  t.screen().CursorPos().Y,
+		X2: t.screen().CursorPos().X + seq.param(0, 1),
+		Y2: t.screen().CursorPos().Y + 1,
+	}, CRClear)
+	return true
+}
 
-				case p == 27:
-					style.ResetMode(ModeReverse)
+// csiEraseInLine is EL Erase in Line.
+func (t *terminal) csiEraseInLine(seq *sequence) bool {
+	// eraseRegion clamps the region to the window, so we don't have to be too careful here
+	switch seq.param(0, 0) {
+	case 0: // Erase to end of line
+		t.screen().eraseRegion(Region{
+			X:  t.screen().CursorPos().X,
+			Y:  t.screen().CursorPos().Y,
+			X2: t.screen().Size().X,
+			Y2: t.screen().CursorPos().Y + 1,
+		}, CRClear)
+	case 1: // Erase to start of line
+		t.screen().eraseRegion(Region{
+			X:  0,
+			Y:  t.screen().CursorPos().Y,
+			X2: t.screen().CursorPos().X,
+			Y2: t.screen().CursorPos().Y + 1,
+		}, CRClear)
+	case 2: // Erase entire line
+		t.screen().eraseRegion(Region{
+			X:  0,
+			Y:  t.screen().CursorPos().Y,
+			X2: t.screen().Size().X,
+			Y2: t.screen().CursorPos().Y + 1,
+		}, CRClear)
+	default:
+		debugPrintln(debugTodo, "TODO: Unhandled K params: ", append([]int(nil), seq.params...))
+		return false
+	}
+	return true
+}
 
-				case p == 28:
-					style.ResetMode(ModeInvisible)
+// csiEraseInDisplay is ED Erase in Display.
+func (t *terminal) csiEraseInDisplay(seq *sequence) bool {
+	// eraseRegion clamps the region to the window, so we don't have to be too careful here
+	switch seq.param(0, 0) {
+	case 0: // Erase to bottom of screen
+		cursorPos := t.screen().CursorPos()
+		// Erase from cursor to end of current line
+		t.screen().eraseRegion(Region{
+			X:  cursorPos.X,
+			Y:  cursorPos.Y,
+			X2: t.screen().Size().X,
+			Y2: cursorPos.Y + 1,
+		}, CRClear)
+		// Erase all lines below current line
+		if cursorPos.Y+1 < t.screen().Size().Y {
+			t.screen().eraseRegion(Region{
+				X:  0,
+				Y:  cursorPos.Y + 1,
+				X2: t.screen().Size().X,
+				Y2: t.screen().Size().Y,
+			}, CRClear)
+		}
+	case 1: // Erase to top of screen
+		cursorPos := t.screen().CursorPos()
+		// Erase all lines above current line
+		if cursorPos.Y > 0 {
+			t.screen().eraseRegion(Region{
+				X:  0,
+				Y:  0,
+				X2: t.screen().Size().X,
+				Y2: cursorPos.Y,
+			}, CRClear)
+		}
+		// Erase from beginning of current line to cursor (inclusive)
+		t.screen().eraseRegion(Region{
+			X:  0,
+			Y:  cursorPos.Y,
+			X2: cursorPos.X + 1,
+			Y2: cursorPos.Y + 1,
+		}, CRClear)
+	case 2: // Erase screen and home cursor
+		t.screen().eraseRegion(Region{
+			X:  0,
+			Y:  0,
+			X2: t.screen().Size().X,
+			Y2: t.screen().Size().Y,
+		}, CRClear)
+		t.screen().setCursorPos(0, 0)
+	case 3: // Erase scrollback
+		t.scrollback.clear()
+	default:
+		return false
+	}
+	return true
+}
 
-				case p == 29: // not crossed-out
-					style.ResetMode(ModeStrike)
+// csiInsertLines is IL Insert Lines, scrolling down.
+func (t *terminal) csiInsertLines(seq *sequence) bool {
+	if t.insideMargins() {
+		t.screen().scroll(t.screen().CursorPos().Y, t.screen().BottomMargin(), seq.param(0, 1))
+	}
+	return true
+}
 
-				case p == 51: // framed
-					style.SetMode(ModeFramed)
+// csiDeleteLines is DL Delete Lines, scrolling up.
+func (t *terminal) csiDeleteLines(seq *sequence) bool {
+	if t.insideMargins() {
+		t.screen().scroll(t.screen().CursorPos().Y, t.screen().BottomMargin(), -seq.param(0, 1))
+	}
+	return true
+}
 
-				case p == 52: // encircled
-					style.SetMode(ModeEncircled)
+// csiScrollUp is SU Scroll Up.
+func (t *terminal) csiScrollUp(seq *sequence) bool {
+	n := seq.param(0, 1)
+	t.screen().saveScrollback(n)
+	t.screen().scroll(t.screen().TopMargin(), t.screen().BottomMargin(), -n)
+	return true
+}
 
-				case p == 53: // overline
-					style.SetMode(ModeOverline)
+// csiScrollDown is SD Scroll Down.
+func (t *terminal) csiScrollDown(seq *sequence) bool {
+	t.screen().scroll(t.screen().TopMargin(), t.screen().BottomMargin(), seq.param(0, 1))
+	return true
+}
 
-				case p == 54: // not framed, not encircled
-					style.ResetMode(ModeFramed, ModeEncircled)
+// csiTabForward is CHT Cursor Forward Tabulation.
+func (t *terminal) csiTabForward(seq *sequence) bool {
+	t.tabForward(max(seq.param(0, 1), 1))
+	return true
+}
 
-				case p == 55: // not overline
-					style.ResetMode(ModeOverline)
+// csiTabBackward is CBT Cursor Backward Tabulation.
+func (t *terminal) csiTabBackward(seq *sequence) bool {
+	t.tabBackward(max(seq.param(0, 1), 1))
+	return true
+}
 
-				case p >= 30 && p <= 37:
-					_ = style.SetColor256(ComponentFG, p-30)
+// csiTabClear is TBC Tab Clear.
+func (t *terminal) csiTabClear(seq *sequence) bool {
+	switch seq.param(0, 0) {
+	case 0: // Clear the stop at the cursor
+		t.screen().tabStops().clear(t.screen().CursorPos().X)
+	case 3: // Clear all stops
+		t.screen().tabStops().clearAll()
+	default:
+		debugPrintln(debugTodo, "TODO: Unhandled TBC params: ", append([]int(nil), seq.params...))
+	}
+	return true
+}
 
-				case p == 39: // default color
-					_ = style.SetColorDefault(ComponentFG)
+// csiPrimaryDeviceAttributes is DA1 Send Device Attributes.
+func (t *terminal) csiPrimaryDeviceAttributes(seq *sequence) bool {
+	if seq.param(0, 0) == 0 {
+		t.replyf("\033[%sc", t.primaryDA)
+	}
+	return true
+}
 
-				case p >= 40 && p <= 47:
-					_ = style.SetColor256(ComponentBG, p-40)
+// csiSecondaryDeviceAttributes is DA2 Send Device Attributes.
+func (t *terminal) csiSecondaryDeviceAttributes(seq *sequence) bool {
+	if seq.param(0, 0) == 0 {
+		t.replyf("\033[%sc", t.secondaryDA)
+	}
+	return true
+}
 
-				case p == 49: // default color
-					_ = style.SetColorDefault(ComponentBG)
+// csiDeviceStatusReport is DSR Device Status Report.
+func (t *terminal) csiDeviceStatusReport(seq *sequence) bool {
+	switch seq.param(0, 0) {
+	case 5:
+		t.replyf("\033[0n")
+	case 6:
+		row := t.screen().CursorPos().Y + 1
+		col := t.screen().CursorPos().X + 1
+		if t.screen().OriginMode() {
+			row -= t.screen().TopMargin()
+			col -= t.screen().LeftMargin()
+		}
+		t.replyf("\033[%d;%dR", row, col)
+	default:
+		debugPrintln(debugTodo, "TODO: Unhandled DSR params: ", append([]int(nil), seq.params...))
+	}
+	return true
+}
 
-				case p == 38 || p == 48: // extended set color
-					if i+2 < len(params) {
-						switch params[i+1] {
-						case 5: // 256 color
-							component := ComponentFG
-							if p == 48 {
-								component = ComponentBG
-							}
-							_ = style.SetColor256(component, params[i+2]&0xff)
-							i += 2
-						case 2: // RGB Color
-							if i+4 < len(params) {
-								component := ComponentFG
-								if p == 48 {
-									component = ComponentBG
-								}
-								_ = style.SetColorRGB(component, params[i+2], params[i+3], params[i+4])
-								i += 4
-							}
-						default:
-							debugPrintln(debugTodo, "TODO: unhandled extended color: ", params[i+1])
-							continue
-						}
-					}
+// csiSetMode is SM Set Mode (h) and RM Reset Mode (l).
+func (t *terminal) csiSetMode(seq *sequence) bool {
+	value := seq.final == 'h'
 
-				case p >= 90 && p <= 97:
-					_ = style.SetColorBright(ComponentFG, int(p-90))
+	if len(seq.params) != 1 {
+		debugPrintln(debugTodo, "TODO: Unhandled CSI mode params: ", append([]int(nil), seq.params...), seq.final)
+		return false
+	}
 
-				case p >= 100 && p <= 107:
-					_ = style.SetColorBright(ComponentBG, int(p-100))
+	switch seq.params[0] {
+	case 4:
+		t.screen().SetInsertMode(value)
+	default:
+		debugPrintln(debugTodo, "TODO: Unhandled CSI mode param: ", seq.params[0])
+		return false
+	}
+	return true
+}
 
-				default:
-					debugPrintln(debugTodo, "TODO: Unhandled set color: ", p)
-					continue
-				}
-			}
+// csiSetPrivateMode is DECSET (? h) and DECRST (? l).
+func (t *terminal) csiSetPrivateMode(seq *sequence) bool {
+	value := seq.final == 'h'
 
-			t.screen().setStyle(style)
+	for _, p := range seq.params {
+		switch p {
+		case 1: // Application / Normal Cursor Keys
+			t.setViewFlag(VFAppCursorKeys, value)
 
-		case 's': // Save cursor pos
-			t.screen().saveCursorPos()
+		case 6: // DECOM Origin mode
+			t.screen().SetOriginMode(value)
+			t.cursorHome()
 
-		case 'u': // Restore cursor pos
-			t.screen().restoreCursorPos()
+		case 7: // Wraparound
+			t.screen().SetAutoWrap(value)
 
-		case 't': // Window manipulation
-			if len(params) > 0 {
-				switch params[0] {
-				case 22, 23:
-					// save/restore window title/icon; no-op for now
-					if *debugCmd {
-						debugPrintf(debugCmd, "CSI t params: %v\n", append([]int(nil), params...))
-					}
-					return true
-				}
-			}
-			debugPrintln(debugTodo, "TODO: Window manipulation: ", append([]int(nil), params...))
-			if *debugCmd {
-				debugPrintf(debugCmd, "CSI t params: %v\n", append([]int(nil), params...))
+		case 9: // Send MouseXY on press
+			debugPrintln(debugTodo, "TODO: Send MouseXY on press =", value) // TODO
+			if value {
+				t.setViewInt(VIMouseMode, MMPress)
+			} else {
+				t.setViewInt(VIMouseMode, MMNone)
 			}
 
-		case 'K': // Erase
-			// eraseRegion clamps the region to the window, so we don't have to be too careful here
-			// debugPrintln("erase: ", params)
+		case 12: // Blink Cursor
+			t.setViewFlag(VFBlinkCursor, value)
 
-			switch {
-			case len(params) == 0 || params[0] == 0: // Erase to end of line
-				t.screen().eraseRegion(Region{
-					X:  t.screen().CursorPos().X,
-					Y:  t.screen().CursorPos().Y,
-					X2: t.screen().Size().X,
-					Y2: t.screen().CursorPos().Y + 1,
-				}, CRClear)
-			case params[0] == 1: // Erase to start of line
-				t.screen().eraseRegion(Region{
-					X:  0,
-					Y:  t.screen().CursorPos().Y,
-					X2: t.screen().CursorPos().X,
-					Y2: t.screen().CursorPos().Y + 1,
-				}, CRClear)
-			case params[0] == 2: // Erase entire line
-				t.screen().eraseRegion(Region{
-					X:  0,
-					Y:  t.screen().CursorPos().Y,
-					X2: t.screen().Size().X,
-					Y2: t.screen().CursorPos().Y + 1,
-				}, CRClear)
-			default:
-				debugPrintln(debugTodo, "TODO: Unhandled K params: ", append([]int(nil), params...))
-				return false
-			}
+		case 69: // DECLRMM Left/right margin mode
+			t.screen().SetLeftRightMarginMode(value)
 
-		case 'J': // Erase Lines
-			// eraseRegion clamps the region to the window, so we don't have to be too careful here
-			// debugPrintln("erase lines: ", params)
+		case 25: // Show Cursor
+			t.setViewFlag(VFShowCursor, value)
 
-			switch {
-			case len(params) == 0 || params[0] == 0: // Erase to bottom of screen
-				cursorPos := t.screen().CursorPos()
-				// Erase from cursor to end of current line
-				t.screen().eraseRegion(Region{
-					X:  cursorPos.X,
-					Y:  cursorPos.Y,
-					X2: t.screen().Size().X,
-					Y2: cursorPos.Y + 1,
-				}, CRClear)
-				// Erase all lines below current line
-				if cursorPos.Y+1 < t.screen().Size().Y {
-					t.screen().eraseRegion(Region{
-						X:  0,
-						Y:  cursorPos.Y + 1,
-						X2: t.screen().Size().X,
-						Y2: t.screen().Size().Y,
-					}, CRClear)
-				}
-			case params[0] == 1: // Erase to top of screen
-				cursorPos := t.screen().CursorPos()
-				// Erase all lines above current line
-				if cursorPos.Y > 0 {
-					t.screen().eraseRegion(Region{
-						X:  0,
-						Y:  0,
-						X2: t.screen().Size().X,
-						Y2: cursorPos.Y,
-					}, CRClear)
-				}
-				// Erase from beginning of current line to cursor (inclusive)
-				t.screen().eraseRegion(Region{
-					X:  0,
-					Y:  cursorPos.Y,
-					X2: cursorPos.X + 1,
-					Y2: cursorPos.Y + 1,
-				}, CRClear)
-			case params[0] == 2: // Erase screen and home cursor
-				t.screen().eraseRegion(Region{
-					X:  0,
-					Y:  0,
-					X2: t.screen().Size().X,
-					Y2: t.screen().Size().Y,
-				}, CRClear)
-				t.screen().setCursorPos(0, 0)
-			default:
-				// debugPrintln(debugTodo, "TODO: Unhandled J params: ", params)
-				return false
+		case 1000: // Send MouseXY on press/release
+			if value {
+				t.setViewInt(VIMouseMode, MMPressRelease)
+			} else {
+				t.setViewInt(VIMouseMode, MMNone)
 			}
 
-		case 'L': // Insert lines, scroll down
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
+		case 1002: // Cell Motion Mouse Tracking
+			if value {
+				t.setViewInt(VIMouseMode, MMPressReleaseMove)
+			} else {
+				t.setViewInt(VIMouseMode, MMNone)
 			}
-			t.screen().scroll(t.screen().CursorPos().Y, t.screen().BottomMargin(), params[0])
 
-		case 'M': // Delete lines, scroll up
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
+		case 1003: // All Motion Mouse Tracking
+			if value {
+				t.setViewInt(VIMouseMode, MMPressReleaseMoveAll)
+			} else {
+				t.setViewInt(VIMouseMode, MMNone)
 			}
-			t.screen().scroll(t.screen().CursorPos().Y, t.screen().BottomMargin(), -params[0])
 
-		case 'S': // Scroll up
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
-			}
-			t.screen().scroll(t.screen().TopMargin(), t.screen().BottomMargin(), -params[0])
+		case 1004: // Report focus changed
+			t.setViewFlag(VFReportFocus, value)
 
-		case 'T': // Scroll down
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
+		case 1005: // xterm UTF-8 extended mouse reporting
+			if value {
+				t.setViewInt(VIMouseEncoding, MEUTF8)
+			} else {
+				t.setViewInt(VIMouseEncoding, MEX10)
 			}
-			t.screen().scroll(t.screen().TopMargin(), t.screen().BottomMargin(), params[0])
 
-		case 'P': // Delete n characters
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
+		case 1006: // xterm SGR extended mouse reporting
+			if value {
+				t.setViewInt(VIMouseEncoding, MESGR)
+			} else {
+				t.setViewInt(VIMouseEncoding, MEX10)
 			}
-			t.screen().deleteChars(t.screen().CursorPos().X, t.screen().CursorPos().Y, params[0], CRClear)
 
-		case 'X': // Erase from cursor pos to the right
-			if paramCount == 0 {
-				paramStore[0] = 1
-				paramCount = 1
-				params = paramStore[:paramCount]
+		case 1015: // urxvt mouse mode
+			if value {
+				t.setViewInt(VIMouseEncoding, MEUTF8)
+			} else {
+				t.setViewInt(VIMouseEncoding, MEX10)
 			}
-			t.screen().eraseRegion(Region{
-				X:  t.screen().CursorPos().X,
-				Y:  t.screen().CursorPos().Y,
-				X2: t.screen().CursorPos().X + params[0],
-				Y2: t.screen().CursorPos().Y + 1,
-			}, CRClear)
 
-		case 'r': // Set Scroll margins
-			top := 1
-			bottom := t.screen().Size().Y
-			if len(params) >= 1 {
-				top = params[0]
-			}
-			if len(params) >= 2 {
-				bottom = params[1]
+		case 1034:
+			debugPrintf(debugTodo, "TODO: Interpret Meta key = %v\n", value)
+
+		case 47: // Alternate screen
+			t.setAltScreen(value)
+
+		case 1047: // Alternate screen, cleared when leaving it
+			if !value && t.onAltScreen {
+				t.eraseScreen()
 			}
-			// debugPrintf("cursor home: %v, %v\n", x, y)
-			t.screen().setScrollMarginTopBottom(top-1, bottom-1)
-
-		case 'n': // Device Status Report
-			if paramCount == 0 {
-				paramStore[0] = 0
-				paramCount = 1
-				params = paramStore[:paramCount]
+			t.setAltScreen(value)
+
+		case 1048: // Save/Restore cursor as in DECSC/DECRC
+			if value {
+				t.saveCursor()
+			} else {
+				t.restoreCursor()
 			}
-			switch params[0] {
-			case 5:
-				_, _ = t.Write([]byte("\033[0n"))
-			case 6:
-				row := t.screen().CursorPos().Y + 1
-				col := t.screen().CursorPos().X + 1
-				_, _ = fmt.Fprintf(t, "\033[%d;%dR", row, col)
-			default:
-				debugPrintln(debugTodo, "TODO: Unhandled DSR params: ", append([]int(nil), params...))
+
+		case 1049: // Save cursor and switch to a cleared alternate screen, or switch back and restore
+			if value {
+				t.saveCursor()
+				t.setAltScreen(true)
+				t.eraseScreen()
+			} else {
+				t.setAltScreen(false)
+				t.restoreCursor()
 			}
 
-		case '%': // Select character set (ignored)
-			// ignored
+		case 2004: // Bracketed paste
+			t.setViewFlag(VFBracketedPaste, value)
 
-		case '<': // SGR mouse or other private mode (ignored)
-			if *debugCmd {
-				debugPrintf(debugCmd, "CSI < params: %v\n", append([]int(nil), params...))
-			}
+		case 2026: // Synchronized output
+			t.setSyncOutput(value)
 
 		default:
-			debugPrintf(debugTodo, "TODO: Unhandled CSI Command: %v %#v\n", append([]int(nil), params...), string(b))
-			return true
+			debugPrintf(debugTodo, "TODO: Unhandled flag: %v, %v\n", seq, p)
 		}
-	} else if string(prefix) == "?" {
-		switch b {
-		case 'u': // Query keyboard mode
-			flags := t.keyboardFlags()
-			_, _ = fmt.Fprintf(t, "\033[?%du", flags)
-			return true
-		case 'm': // Private SGR (ignored)
-			if *debugCmd {
-				debugPrintf(debugCmd, "CSI ? m params: %v\n", append([]int(nil), params...))
-			}
-			return true
-		case 'h', 'l': // h == set, l == reset  for various modes
-			var value bool
-			if b == 'h' {
-				value = true
-			}
+	}
+	return true
+}
 
-			for _, p := range params {
-				switch p {
-				case 1: // Application / Normal Cursor Keys
-					t.setViewFlag(VFAppCursorKeys, value)
+// csiSetTopBottomMargins is DECSTBM Set Top and Bottom Margins.
+func (t *terminal) csiSetTopBottomMargins(seq *sequence) bool {
+	top := seq.param(0, 1)
+	bottom := seq.param(1, t.screen().Size().Y)
+	if top < bottom {
+		t.screen().setScrollMarginTopBottom(top-1, bottom-1)
+		t.cursorHome()
+	}
+	return true
+}
 
-				case 7: // Wraparound
-					t.screen().SetAutoWrap(value)
+// csiSetLeftRightMarginsOrSaveCursor is DECSLRM Set Left and Right Margins
+// when DECLRMM is on, and SCOSC Save Cursor otherwise.
+func (t *terminal) csiSetLeftRightMarginsOrSaveCursor(seq *sequence) bool {
+	if !t.screen().LeftRightMarginMode() {
+		t.saveCursor()
+		return true
+	}
+	left := seq.param(0, 0)
+	right := seq.param(1, 0)
+	if left == 0 {
+		left = 1
+	}
+	if right == 0 {
+		right = t.screen().Size().X
+	}
+	if left < right {
+		t.screen().setScrollMarginLeftRight(left-1, right-1)
+		t.cursorHome()
+	}
+	return true
+}
 
-				case 9: // Send MouseXY on press
-					debugPrintln(debugTodo, "TODO: Send MouseXY on press =", value) // TODO
-					if value {
-						t.setViewInt(VIMouseMode, MMPress)
-					} else {
-						t.setViewInt(VIMouseMode, MMNone)
-					}
+// csiRestoreCursor is SCORC Restore Cursor.
+func (t *terminal) csiRestoreCursor(seq *sequence) bool {
+	t.restoreCursor()
+	return true
+}
 
-				case 12: // Blink Cursor
-					t.setViewFlag(VFBlinkCursor, value)
+// csiSoftReset is DECSTR Soft Terminal Reset.
+func (t *terminal) csiSoftReset(seq *sequence) bool {
+	t.softReset()
+	return true
+}
 
-				case 25: // Show Cursor
-					t.setViewFlag(VFShowCursor, value)
+// csiSetCursorStyle is DECSCUSR Set Cursor Style.
+func (t *terminal) csiSetCursorStyle(seq *sequence) bool {
+	shape := seq.param(0, CSDefault)
+	if shape > CSSteadyBar {
+		return false
+	}
+	t.setViewInt(VICursorShape, shape)
+	return true
+}
 
-				case 1000: // Send MouseXY on press/release
-					if value {
-						t.setViewInt(VIMouseMode, MMPressRelease)
-					} else {
-						t.setViewInt(VIMouseMode, MMNone)
-					}
+// csiWindowManipulation is XTWINOPS.
+func (t *terminal) csiWindowManipulation(seq *sequence) bool {
+	switch seq.param(0, 0) {
+	case 22, 23:
+		// save/restore window title/icon; no-op for now
+	default:
+		debugPrintln(debugTodo, "TODO: Window manipulation: ", append([]int(nil), seq.params...))
+	}
+	return true
+}
 
-				case 1002: // Cell Motion Mouse Tracking
-					if value {
-						t.setViewInt(VIMouseMode, MMPressReleaseMove)
-					} else {
-						t.setViewInt(VIMouseMode, MMNone)
-					}
+// csiSetGraphicRendition is SGR Select Graphic Rendition.
+func (t *terminal) csiSetGraphicRendition(seq *sequence) bool {
+	params := seq.params
+	if len(params) == 0 {
+		params = []int{0}
+	}
 
-				case 1003: // All Motion Mouse Tracking
-					if value {
-						t.setViewInt(VIMouseMode, MMPressReleaseMoveAll)
-					} else {
-						t.setViewInt(VIMouseMode, MMNone)
-					}
+	style := t.screen().Style()
 
-				case 1004: // Report focus changed
-					t.setViewFlag(VFReportFocus, value)
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		// Colon-separated sub-parameters of p.
+		subCount := 0
+		for i+1+subCount < len(params) && seq.subParams[i+1+subCount] {
+			subCount++
+		}
+		subs := params[i+1 : i+1+subCount]
+		i += subCount
 
-				case 1005: // xterm UTF-8 extended mouse reporting
-					if value {
-						t.setViewInt(VIMouseEncoding, MEUTF8)
-					} else {
-						t.setViewInt(VIMouseEncoding, MEX10)
-					}
+		switch {
+		case p == 0: // reset mode
+			style.ResetAll()
 
-				case 1006: // xterm SGR extended mouse reporting
-					if value {
-						t.setViewInt(VIMouseEncoding, MESGR)
-					} else {
-						t.setViewInt(VIMouseEncoding, MEX10)
-					}
+		case p == 4 && len(subs) > 0: // underline style
+			if subs[0] < len(underlineStyles) {
+				style.SetUnderlineMode(underlineStyles[subs[0]])
+			} else {
+				debugPrintln(debugTodo, "TODO: Unhandled underline style: ", subs[0])
+			}
 
-				case 1015: // urxvt mouse mode
-					if value {
-						t.setViewInt(VIMouseEncoding, MEUTF8)
-					} else {
-						t.setViewInt(VIMouseEncoding, MEX10)
-					}
+		case p == 4:
+			style.SetUnderlineMode(ModeUnderline)
 
-				case 1034:
-					debugPrintf(debugTodo, "TODO: Interpret Meta key = %v\n", value)
+		case p >= 1 && p <= 5:
+			style.SetMode(colorModes[p-1])
 
-				case 1049: // Save/Restore cursor and alternate screen
-					t.switchScreen()
+		case p == 6: // rapid blink
+			style.SetMode(ModeRapidBlink)
 
-				case 2004: // Bracketed paste
-					t.setViewFlag(VFBracketedPaste, value)
+		case p == 7:
+			style.SetMode(ModeReverse)
 
-				default:
-					debugPrintf(debugTodo, "TODO: Unhandled flag: %#v %v, %v %#v\n", string(prefix), append([]int(nil), params...), p, string(b))
-				}
-			}
+		case p == 8:
+			style.SetMode(ModeInvisible)
 
-		default:
-			debugPrintf(debugTodo, "TODO: Unhandled ? command: %#v %v, %#v\n", string(prefix), append([]int(nil), params...), string(b))
-		}
-	} else if string(prefix) == ">" {
-		switch b {
-		case 'c': // Send Device Attributes
-			attrs := "\x1b[>1;4402;0c"
-			if _, err := t.Write([]byte(attrs)); err != nil {
-				debugPrintln(debugErrors, "Error sending device attrs:", err)
-			}
+		case p == 9: // strikethrough / crossed-out
+			style.SetMode(ModeStrike)
 
-		case 'm': // modifyOtherKeys
-			mode := -1
-			for i := 0; i < len(params); i++ {
-				if params[i] == 4 {
-					if i+1 < len(params) {
-						mode = params[i+1]
-					} else {
-						mode = 0
-					}
-				}
-			}
-			if mode >= 0 {
-				t.setViewInt(VIModifyOtherKeys, mode)
-			}
-			if *debugCmd {
-				debugPrintf(debugCmd, "CSI > m params: %v mode=%d\n", append([]int(nil), params...), mode)
-			}
+		case p == 21: // double underline
+			style.SetUnderlineMode(ModeDoubleUnderline)
 
-		case 'u': // key encoding mode (ignored)
-			flags := 0
-			if len(params) > 0 {
-				flags = params[0]
-			}
-			t.pushKeyboardFlags(flags)
+		case p == 22:
+			style.ResetMode(ModeBold, ModeDim)
 
-		default:
-			debugPrintf(debugTodo, "TODO: Unhandled > command: %#v %v, %#v\n", string(prefix), append([]int(nil), params...), string(b))
-			return true
-		}
-	} else if string(prefix) == "<" {
-		switch b {
-		case 'u': // key encoding mode pop
-			count := 1
-			if len(params) > 0 {
-				count = params[0]
-			}
-			t.popKeyboardFlags(count)
-			return true
-		case 'M', 'm': // SGR mouse report (ignored)
-			if *debugCmd {
-				debugPrintf(debugCmd, "CSI < mouse params: %v %c\n", append([]int(nil), params...), b)
-			}
-			return true
-		default:
-			debugPrintf(debugTodo, "TODO: Unhandled < command: %#v %v, %#v\n", string(prefix), append([]int(nil), params...), string(b))
-			return true
-		}
-	} else if string(prefix) == "=" {
-		switch b {
-		case 'u': // key encoding mode set
-			flags := 0
-			mode := 1
-			if len(params) > 0 {
-				flags = params[0]
-			}
-			if len(params) > 1 {
-				mode = params[1]
-			}
-			t.updateKeyboardFlags(flags, mode)
-			return true
-		default:
-			debugPrintf(debugTodo, "TODO: Unhandled = command: %#v %v, %#v\n", string(prefix), append([]int(nil), params...), string(b))
-			return true
-		}
-	} else {
-		debugPrintf(debugTodo, "TODO: Unhandled prefix: %#v %v, %#v\n", string(prefix), append([]int(nil), params...), string(b))
-		return true
-	}
+		case p == 23:
+			style.ResetMode(ModeItalic)
 
-	return true
-}
+		case p == 24:
+			style.SetUnderlineMode(0)
 
-func (t *terminal) handleCmdOSC(r escapeReader) bool {
-	paramBytes := []byte{}
-	var err error
-	var b byte
-	for {
-		b, err = r.ReadByte()
-		if err != nil {
-			if err != io.EOF {
-				debugPrintln(debugErrors, "ERR ReadByte8:", err)
-			}
-			return false
-		}
+		case p == 25:
+			style.ResetMode(ModeBlink, ModeRapidBlink)
 
-		if b >= '0' && b <= '9' {
-			paramBytes = append(paramBytes, byte(b))
-		} else {
-			break
-		}
-	}
+		case p == 27:
+			style.ResetMode(ModeReverse)
 
-	param, _ := strconv.Atoi(string(paramBytes))
+		case p == 28:
+			style.ResetMode(ModeInvisible)
 
-	param2 := []byte{}
+		case p == 29: // not crossed-out
+			style.ResetMode(ModeStrike)
 
-	if b == ';' {
-		// skip the ';'
-		for {
-			b, err = r.ReadByte()
-			if err != nil {
-				if err != io.EOF {
-					debugPrintln(debugErrors, "ERR ReadByte9:", err)
-				}
-				return false
-			}
-			if b == 7 || b == 0x9c { // BEL , ST
-				break
-			}
-			if len(param2) > 0 && param2[len(param2)-1] == 27 && b == '\\' { // ESC \ is also ST
-				param2 = param2[:len(param2)-1]
-				break
-			}
+		case p == 51: // framed
+			style.SetMode(ModeFramed)
 
-			param2 = append(param2, byte(b))
-		}
-	} else if b != 7 && b != 0x9c { // BEL, ST
-		debugPrintln(debugErrors, "OSC command number not followed by ;, BEL, or ST?", b)
-		return false
-	}
+		case p == 52: // encircled
+			style.SetMode(ModeEncircled)
 
-	switch param {
-	case 0:
-		t.setViewString(VSWindowTitle, string(param2))
+		case p == 53: // overline
+			style.SetMode(ModeOverline)
 
-	case 2:
-		t.setViewString(VSWindowTitle, string(param2))
+		case p == 54: // not framed, not encircled
+			style.ResetMode(ModeFramed, ModeEncircled)
 
-	case 4:
-		debugPrintf(debugTodo, "TODO: change color : %#v\n", string(param2))
+		case p == 55: // not overline
+			style.ResetMode(ModeOverline)
 
-	case 6:
-		t.setViewString(VSCurrentDirectory, string(param2))
+		case p >= 30 && p <= 37:
+			_ = style.SetColor256(ComponentFG, p-30)
+
+		case p == 39: // default color
+			_ = style.SetColorDefault(ComponentFG)
+
+		case p >= 40 && p <= 47:
+			_ = style.SetColor256(ComponentBG, p-40)
 
-	case 7:
-		t.setViewString(VSCurrentFile, string(param2))
+		case p == 49: // default color
+			_ = style.SetColorDefault(ComponentBG)
 
-	case 10:
-		debugPrintln(debugTodo, "TODO: OSC foreground color: ", string(param2))
+		case (p == 38 || p == 48 || p == 58) && len(subs) > 0: // extended set color, ISO 8613-6 form
+			component := ComponentFG
+			switch p {
+			case 48:
+				component = ComponentBG
+			case 58:
+				component = ComponentUnderline
+			}
+			switch {
+			case subs[0] == 5 && len(subs) >= 2: // 38:5:idx
+				_ = style.SetColor256(component, subs[1]&0xff)
+			case subs[0] == 2 && len(subs) >= 5: // 38:2:colorspace:r:g:b
+				_ = style.SetColorRGB(component, subs[2], subs[3], subs[4])
+			case subs[0] == 2 && len(subs) == 4: // 38:2:r:g:b
+				_ = style.SetColorRGB(component, subs[1], subs[2], subs[3])
+			default:
+				debugPrintln(debugTodo, "TODO: unhandled extended color: ", append([]int(nil), subs...))
+			}
 
-	case 11:
-		debugPrintln(debugTodo, "TODO: OSC background color: ", string(param2))
+		case p == 38 || p == 48 || p == 58: // extended set color
+			component := ComponentFG
+			switch p {
+			case 48:
+				component = ComponentBG
+			case 58:
+				component = ComponentUnderline
+			}
+			if i+2 < len(params) {
+				switch params[i+1] {
+				case 5: // 256 color
+					_ = style.SetColor256(component, params[i+2]&0xff)
+					i += 2
+				case 2: // RGB Color
+					if i+4 < len(params) {
+						_ = style.SetColorRGB(component, params[i+2], params[i+3], params[i+4])
+						i += 4
+					}
+				default:
+					debugPrintln(debugTodo, "TODO: unhandled extended color: ", params[i+1])
+					continue
+				}
+			}
 
-	case 104:
-		debugPrintln(debugTodo, "TODO: Reset Color Palette", string(param2))
+		case p == 59: // default underline color
+			_ = style.SetColorDefault(ComponentUnderline)
 
-	case 112:
-		debugPrintln(debugTodo, "TODO: Reset Cursor Color", string(param2))
+		case p >= 90 && p <= 97:
+			_ = style.SetColorBright(ComponentFG, int(p-90))
 
-	default:
-		debugPrintln(debugTodo, "TODO: Unhandled OSC Command: ", param, string(b))
-		return true
-	}
+		case p >= 100 && p <= 107:
+			_ = style.SetColorBright(ComponentBG, int(p-100))
 
-	if *debugCmd {
-		debugPrintf(debugCmd, "OSC %d: %q\n", param, string(param2))
+		default:
+			debugPrintln(debugTodo, "TODO: Unhandled set color: ", p)
+			continue
+		}
 	}
 
+	t.screen().setStyle(style)
 	return true
 }
 
-func (t *terminal) handleDCS(r escapeReader) bool {
-	prev := byte(0)
-	var payload []byte
-	for {
-		b, err := r.ReadByte()
-		if err != nil {
-			if err != io.EOF {
-				debugPrintln(debugErrors, "ERR ReadByteDCS:", err)
-			}
-			return false
-		}
-		if b == 0x9c {
-			if *debugCmd {
-				debugPrintf(debugCmd, "DCS payload: %q\n", string(payload))
-			}
-			return true
-		}
-		if prev == 27 && b == '\\' {
-			if *debugCmd {
-				if len(payload) > 0 && payload[len(payload)-1] == 27 {
-					payload = payload[:len(payload)-1]
-				}
-				debugPrintf(debugCmd, "DCS payload: %q\n", string(payload))
-			}
-			return true
-		}
-		if *debugCmd {
-			payload = append(payload, b)
+// csiQueryKeyboardFlags reports the kitty keyboard protocol flags.
+func (t *terminal) csiQueryKeyboardFlags(seq *sequence) bool {
+	flags := t.keyboardFlags()
+	t.replyf("\033[?%du", flags)
+	return true
+}
+
+// csiPushKeyboardFlags pushes kitty keyboard protocol flags.
+func (t *terminal) csiPushKeyboardFlags(seq *sequence) bool {
+	t.pushKeyboardFlags(seq.param(0, 0))
+	return true
+}
+
+// csiPopKeyboardFlags pops kitty keyboard protocol flags.
+func (t *terminal) csiPopKeyboardFlags(seq *sequence) bool {
+	t.popKeyboardFlags(seq.param(0, 1))
+	return true
+}
+
+// csiSetKeyboardFlags updates the current kitty keyboard protocol flags.
+func (t *terminal) csiSetKeyboardFlags(seq *sequence) bool {
+	t.updateKeyboardFlags(seq.param(0, 0), seq.param(1, 1))
+	return true
+}
+
+// csiModifyOtherKeys is XTMODKEYS; only the modifyOtherKeys resource (4) is
+// tracked.
+func (t *terminal) csiModifyOtherKeys(seq *sequence) bool {
+	mode := -1
+	for i := 0; i < len(seq.params); i++ {
+		if seq.params[i] == 4 {
+			mode = seq.param(i+1, 0)
 		}
-		prev = b
 	}
+	if mode >= 0 {
+		t.setViewInt(VIModifyOtherKeys, mode)
+	}
+	return true
 }
@@ -1,8 +1,12 @@
 package termemu
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHandleCmdCSI_CursorMovement(t *testing.T) {
@@ -131,6 +135,33 @@ func TestHandleCmdCSI_ModifyOtherKeys(t *testing.T) {
 	}
 }
 
+func TestHandleCmdCSI_CursorStyle(t *testing.T) {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+
+	t1.mustHandleCommand(t, "[6 q")
+	if v := mf.ViewInts[VICursorShape]; v != CSSteadyBar {
+		t.Fatalf("expected steady bar cursor, got %v", v)
+	}
+
+	if err := t1.testHandleCommand(t, "[7 q"); err == nil {
+		t.Errorf("DECSCUSR 7 was accepted")
+	}
+	if v := mf.ViewInts[VICursorShape]; v != CSSteadyBar {
+		t.Errorf("invalid DECSCUSR changed the cursor to %v", v)
+	}
+
+	t1.mustHandleCommand(t, "[ q")
+	if v := mf.ViewInts[VICursorShape]; v != CSDefault {
+		t.Errorf("expected default cursor, got %v", v)
+	}
+
+	t1.mustHandleCommand(t, "[3 q")
+	t1.mustHandleCommand(t, "c")
+	if v := mf.ViewInts[VICursorShape]; v != CSDefault {
+		t.Errorf("expected RIS to reset the cursor, got %v", v)
+	}
+}
+
 func TestHandleCmdOSC_WindowTitleAndStrings(t *testing.T) {
 	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
 
@@ -440,8 +471,7 @@ func TestCSI_DeviceAttributes(t *testing.T) {
 		seq         string
 		wantContain string
 	}{
-		// Note: [c without params defaults to 1, but only case 0 writes response
-		// So only [0c triggers primary DA response
+		{"primary DA with [c", "[c", "\x1b[?1;2c"},
 		{"primary DA with [0c", "[0c", "\x1b[?"},
 		{"secondary DA with [>c", "[>c", "\x1b[>1;4402;0c"},
 	}
@@ -923,7 +953,7 @@ func TestCSI_UnknownCommands(t *testing.T) {
 	}{
 		{
 			name:     "unknown command with no prefix",
-			sequence: "[Z",
+			sequence: "[y",
 		},
 		{
 			name:     "unknown ? command",
@@ -1223,3 +1253,549 @@ func TestCSI_ScrollMargins(t *testing.T) {
 		})
 	}
 }
+
+func TestESC_FullReset(t *testing.T) {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+
+	for _, seq := range []string{"[?7h", "[3;8r", "[1;31m", "[?1000h", "[?1006h", "[?25l", "[?1h", "]0;title\x07", "[>5u"} {
+		t1.mustHandleCommand(t, seq)
+	}
+	if err := t1.testFeedTerminalInputFromBackend([]byte("hello\x1b[?1049hworld"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+
+	t1.mustHandleCommand(t, "c")
+
+	if t1.onAltScreen {
+		t.Fatalf("expected reset to return to the main screen")
+	}
+	if line := strings.TrimRight(t1.Line(0), " "); line != "" {
+		t.Errorf("line 0 after reset = %q, want empty", line)
+	}
+	if pos := t1.screen().CursorPos(); pos != (Pos{}) {
+		t.Errorf("cursor after reset = %v, want {0 0}", pos)
+	}
+	if top, bottom := t1.screen().TopMargin(), t1.screen().BottomMargin(); top != 0 || bottom != 13 {
+		t.Errorf("margins after reset = %d;%d, want 0;13", top, bottom)
+	}
+	if t1.screen().AutoWrap() {
+		t.Errorf("expected autowrap to be reset")
+	}
+	if st := t1.screen().Style(); st != NewStyle() {
+		t.Errorf("style after reset = %v, want default", st)
+	}
+	if !mf.ViewFlags[VFShowCursor] || mf.ViewFlags[VFAppCursorKeys] {
+		t.Errorf("view flags not reset: %v", mf.ViewFlags)
+	}
+	if mf.ViewInts[VIMouseMode] != 0 || mf.ViewInts[VIMouseEncoding] != 0 {
+		t.Errorf("mouse modes not reset: %v", mf.ViewInts)
+	}
+	if mf.ViewStrings[VSWindowTitle] != "" {
+		t.Errorf("window title not reset: %q", mf.ViewStrings[VSWindowTitle])
+	}
+	if flags := t1.keyboardMain.flags; flags != 0 {
+		t.Errorf("keyboard flags not reset: %d", flags)
+	}
+}
+
+func TestCSI_SoftReset(t *testing.T) {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+
+	for _, seq := range []string{"[?7h", "[3;8r", "[1;31m", "[?25l", "[?1h", "[?1000h"} {
+		t1.mustHandleCommand(t, seq)
+	}
+	if err := t1.testFeedTerminalInputFromBackend([]byte("hello"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+
+	t1.mustHandleCommand(t, "[!p")
+
+	if line := strings.TrimRight(t1.Line(0), " "); line != "hello" {
+		t.Errorf("soft reset should keep screen contents, line 0 = %q", line)
+	}
+	if top, bottom := t1.screen().TopMargin(), t1.screen().BottomMargin(); top != 0 || bottom != 13 {
+		t.Errorf("margins after soft reset = %d;%d, want 0;13", top, bottom)
+	}
+	if t1.screen().AutoWrap() {
+		t.Errorf("expected autowrap to be reset")
+	}
+	if st := t1.screen().Style(); st != NewStyle() {
+		t.Errorf("style after soft reset = %v, want default", st)
+	}
+	if !mf.ViewFlags[VFShowCursor] || mf.ViewFlags[VFAppCursorKeys] {
+		t.Errorf("view flags not reset: %v", mf.ViewFlags)
+	}
+	if mf.ViewInts[VIMouseMode] == 0 {
+		t.Errorf("soft reset should keep the mouse mode")
+	}
+}
+
+func TestTabStops(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(30, 4)
+
+		if got, want := fmt.Sprint(t1.TabStops()), "[8 16 24]"; got != want {
+			t.Fatalf("default tab stops = %v, want %v", got, want)
+		}
+
+		// Clear all, set stops at 3 and 10, then tab through them.
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[3g\x1b[4G\x1bH\x1b[11G\x1bH\r\ta\tb\tc"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fmt.Sprint(t1.TabStops()), "[3 10]"; got != want {
+			t.Fatalf("tab stops = %v, want %v", got, want)
+		}
+		if got, want := strings.TrimRight(t1.Line(0), " "), "   a      b                  c"; got != want {
+			t.Fatalf("line = %q, want %q", got, want)
+		}
+
+		t1.mustHandleCommand(t, "[Z")
+		if x := t1.screen().CursorPos().X; x != 10 {
+			t.Errorf("CBT from end: x = %d, want 10", x)
+		}
+		t1.mustHandleCommand(t, "[5Z")
+		if x := t1.screen().CursorPos().X; x != 0 {
+			t.Errorf("CBT past the first stop: x = %d, want 0", x)
+		}
+		t1.mustHandleCommand(t, "[2I")
+		if x := t1.screen().CursorPos().X; x != 10 {
+			t.Errorf("CHT 2: x = %d, want 10", x)
+		}
+		t1.mustHandleCommand(t, "[g")
+		if got, want := fmt.Sprint(t1.TabStops()), "[3]"; got != want {
+			t.Errorf("after TBC 0 tab stops = %v, want %v", got, want)
+		}
+
+		// New columns get default stops on resize.
+		_ = t1.Resize(40, 4)
+		if got, want := fmt.Sprint(t1.TabStops()), "[3 32]"; got != want {
+			t.Errorf("after resize tab stops = %v, want %v", got, want)
+		}
+
+		t1.mustHandleCommand(t, "c")
+		if got, want := fmt.Sprint(t1.TabStops()), "[8 16 24 32]"; got != want {
+			t.Errorf("after reset tab stops = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestCSI_InsertCharsAndInsertMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		input string
+		want  string
+	}{
+		{"ICH shifts right", 8, "abcdef\x1b[3G\x1b[2@", "ab  cdef"},
+		{"ICH default count", 8, "abcdef\x1b[3G\x1b[@", "ab cdef"},
+		{"ICH drops cells past the edge", 6, "abcdef\x1b[2G\x1b[3@", "a   bc"},
+		{"ICH in right half of wide char", 8, "a🐹bcd\x1b[3G\x1b[@", "a   bcd"},
+		{"ICH cuts wide char at the edge", 6, "abcd🐹\x1b[1G\x1b[@", " abcd"},
+		{"IRM inserts text", 8, "abcdef\x1b[3G\x1b[4hXY", "abXYcdef"},
+		{"IRM reset replaces", 8, "abcdef\x1b[3G\x1b[4hXY\x1b[4lZ", "abXYZdef"},
+		{"IRM with wide char", 8, "abcdef\x1b[3G\x1b[4h🐹", "ab🐹cdef"},
+	}
+
+	for _, tt := range tests {
+		forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+			t1 := makeTerminalWithScreens(newFn)
+			_ = t1.Resize(tt.width, 2)
+			if err := t1.testFeedTerminalInputFromBackend([]byte(tt.input), TextReadModeRune); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimRight(t1.Line(0), " "); got != tt.want {
+				t.Errorf("%s: line = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestESC_SaveRestoreCursorState(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[3;5H\x1b[1;31m\x1b[?7h\x1b(0\x1b7\x1b[H\x1b[0m\x1b[?7l\x1b(B\x1b8q"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := t1.screen().CursorPos(); got != (Pos{X: 5, Y: 2}) {
+		t.Errorf("cursor after DECRC = %v, want {5 2}", got)
+	}
+	if !t1.screen().AutoWrap() {
+		t.Errorf("expected DECRC to restore autowrap")
+	}
+	style := t1.screen().Style()
+	if !style.TestMode(ModeBold) {
+		t.Errorf("expected DECRC to restore bold")
+	}
+	if fg, _, _ := style.GetColor(ComponentFG); fg != 1 {
+		t.Errorf("fg after DECRC = %d, want 1", fg)
+	}
+	if got := strings.TrimRight(t1.Line(2), " "); got != "    ─" {
+		t.Errorf("line = %q, want the charset to be restored", got)
+	}
+}
+
+func TestCSI_AlternateScreenModes(t *testing.T) {
+	t.Run("1049 restores the main screen and cursor", func(t *testing.T) {
+		_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("main\x1b[2;3H\x1b[?1049h"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+		if !t1.onAltScreen {
+			t.Fatalf("expected alt screen")
+		}
+		if got := strings.TrimRight(t1.Line(0), " "); got != "" {
+			t.Errorf("alt screen line 0 = %q, want empty", got)
+		}
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[5;5Halt\x1b[?1049l"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+		if t1.onAltScreen {
+			t.Fatalf("expected main screen")
+		}
+		if got := strings.TrimRight(t1.Line(0), " "); got != "main" {
+			t.Errorf("main screen line 0 = %q, want %q", got, "main")
+		}
+		if got := t1.screen().CursorPos(); got != (Pos{X: 2, Y: 1}) {
+			t.Errorf("cursor = %v, want {2 1}", got)
+		}
+
+		// The alt screen is cleared again on the next entry.
+		t1.mustHandleCommand(t, "[?1049h")
+		if got := strings.TrimRight(t1.Line(4), " "); got != "" {
+			t.Errorf("alt screen line 4 = %q, want empty", got)
+		}
+	})
+
+	t.Run("1049 reset on the main screen only restores the cursor", func(t *testing.T) {
+		_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+		t1.mustHandleCommand(t, "[?1049l")
+		if t1.onAltScreen {
+			t.Fatalf("expected main screen")
+		}
+	})
+
+	t.Run("1047 clears the alt screen when leaving", func(t *testing.T) {
+		_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[?1047halt\x1b[?1047l\x1b[?47h"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimRight(t1.Line(0), " "); got != "" {
+			t.Errorf("alt screen line 0 = %q, want empty", got)
+		}
+	})
+
+	t.Run("47 keeps the alt screen contents", func(t *testing.T) {
+		_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[?47halt\x1b[?47l\x1b[?47h"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimRight(t1.Line(0), " "); got != "alt" {
+			t.Errorf("alt screen line 0 = %q, want %q", got, "alt")
+		}
+	})
+
+	t.Run("1048 saves and restores the cursor", func(t *testing.T) {
+		_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[4;7H\x1b[?1048h\x1b[H\x1b[?1048l"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+		if got := t1.screen().CursorPos(); got != (Pos{X: 6, Y: 3}) {
+			t.Errorf("cursor = %v, want {6 3}", got)
+		}
+	})
+}
+
+// fillRows writes one repeated letter per row, starting with 'a'.
+func fillRows(t *testing.T, t1 *terminal) {
+	t.Helper()
+	w, h := t1.Size()
+	var b strings.Builder
+	for y := 0; y < h; y++ {
+		fmt.Fprintf(&b, "\x1b[%dH%s", y+1, strings.Repeat(string(rune('a'+y)), w))
+	}
+	if err := t1.testFeedTerminalInputFromBackend([]byte(b.String()), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSI_OriginMode(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(10, 6)
+
+		t1.mustHandleCommand(t, "[2;5r")
+		t1.mustHandleCommand(t, "[?6h")
+		if got := t1.screen().CursorPos(); got != (Pos{X: 0, Y: 1}) {
+			t.Errorf("cursor after DECOM = %v, want {0 1}", got)
+		}
+		t1.mustHandleCommand(t, "[2;3H")
+		if got := t1.screen().CursorPos(); got != (Pos{X: 2, Y: 2}) {
+			t.Errorf("CUP in origin mode = %v, want {2 2}", got)
+		}
+		t1.mustHandleCommand(t, "[10d")
+		if got := t1.screen().CursorPos(); got != (Pos{X: 2, Y: 4}) {
+			t.Errorf("VPA past the bottom margin = %v, want {2 4}", got)
+		}
+
+		t1.mustHandleCommand(t, "[?69h")
+		t1.mustHandleCommand(t, "[3;6s")
+		t1.mustHandleCommand(t, "[1;9H")
+		if got := t1.screen().CursorPos(); got != (Pos{X: 5, Y: 1}) {
+			t.Errorf("CUP with left/right margins = %v, want {5 1}", got)
+		}
+
+		t1.mustHandleCommand(t, "[?6l")
+		if got := t1.screen().CursorPos(); got != (Pos{}) {
+			t.Errorf("cursor after resetting DECOM = %v, want {0 0}", got)
+		}
+	})
+}
+
+func TestCSI_OriginModeCursorReport(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	t1.mustHandleCommand(t, "[3;10r")
+	t1.mustHandleCommand(t, "[?6h")
+	t1.mustHandleCommand(t, "[2;4H")
+
+	go func() { _ = t1.testHandleCommand(t, "[6n") }()
+
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if got, want := string(buf[:n]), "\x1b[2;4R"; got != want {
+		t.Errorf("response = %q, want %q", got, want)
+	}
+}
+
+func TestCSI_LeftRightMargins(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "linefeed scrolls only the margin columns",
+			input: "\x1b[4;3H\n",
+			want:  []string{"aabbbbaaaa", "bbccccbbbb", "ccddddcccc", "dd    dddd"},
+		},
+		{
+			name:  "reverse index scrolls down inside the margins",
+			input: "\x1b[1;3H\x1bM",
+			want:  []string{"aa    aaaa", "bbaaaabbbb", "ccbbbbcccc", "ddccccdddd"},
+		},
+		{
+			name:  "autowrap stays inside the margins",
+			input: "\x1b[?7h\x1b[1;3Hxxxxyy",
+			want:  []string{"aaxxxxaaaa", "bbyybbbbbb", "cccccccccc", "dddddddddd"},
+		},
+		{
+			name:  "insert line",
+			input: "\x1b[2;4H\x1b[L",
+			want:  []string{"aaaaaaaaaa", "bb    bbbb", "ccbbbbcccc", "ddccccdddd"},
+		},
+		{
+			name:  "delete line",
+			input: "\x1b[2;4H\x1b[2M",
+			want:  []string{"aaaaaaaaaa", "bbddddbbbb", "cc    cccc", "dd    dddd"},
+		},
+		{
+			name:  "insert line outside the margins is ignored",
+			input: "\x1b[2;8H\x1b[L",
+			want:  []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc", "dddddddddd"},
+		},
+		{
+			name:  "insert and delete characters",
+			input: "\x1b[1;4H\x1b[@\x1b[2;4H\x1b[2P",
+			want:  []string{"aaa aaaaaa", "bbbb  bbbb", "cccccccccc", "dddddddddd"},
+		},
+		{
+			name:  "delete characters outside the margins is ignored",
+			input: "\x1b[1;8H\x1b[P",
+			want:  []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc", "dddddddddd"},
+		},
+		{
+			name:  "erase ignores the margins",
+			input: "\x1b[1;4H\x1b[K",
+			want:  []string{"aaa", "bbbbbbbbbb", "cccccccccc", "dddddddddd"},
+		},
+		{
+			name:  "carriage return goes to the left margin",
+			input: "\x1b[1;5H\rx",
+			want:  []string{"aaxaaaaaaa", "bbbbbbbbbb", "cccccccccc", "dddddddddd"},
+		},
+	}
+
+	for _, tt := range tests {
+		forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+			t1 := makeTerminalWithScreens(newFn)
+			_ = t1.Resize(10, 4)
+			fillRows(t, t1)
+			t1.mustHandleCommand(t, "[?69h")
+			t1.mustHandleCommand(t, "[3;6s")
+
+			if err := t1.testFeedTerminalInputFromBackend([]byte(tt.input), TextReadModeRune); err != nil {
+				t.Fatal(err)
+			}
+			if got := screenText(t1); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+			}
+			if n := t1.ScrollbackLen(); n != 0 {
+				t.Errorf("%s: scrolling inside left/right margins saved %d scrollback lines", tt.name, n)
+			}
+		})
+	}
+}
+
+func TestCSI_ScrollCountPastRegion(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"SU", "\x1b[100S", nil},
+		{"SD", "\x1b[100T", nil},
+		{"IL", "\x1b[2H\x1b[100L", []string{"aaaaaaaaaa"}},
+		{"DL", "\x1b[2H\x1b[100M", []string{"aaaaaaaaaa"}},
+		{"SU in margins", "\x1b[2;4r\x1b[100S", []string{"aaaaaaaaaa", "", "", "", "eeeeeeeeee"}},
+		{"SD in margins", "\x1b[2;4r\x1b[100T", []string{"aaaaaaaaaa", "", "", "", "eeeeeeeeee"}},
+		{"DL in left/right margins", "\x1b[?69h\x1b[3;6s\x1b[4;3H\x1b[100M",
+			[]string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc", "dd    dddd", "ee    eeee"}},
+	}
+	for _, tt := range tests {
+		forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+			t1 := makeTerminalWithScreens(newFn)
+			_ = t1.Resize(10, 5)
+			fillRows(t, t1)
+
+			if err := t1.testFeedTerminalInputFromBackend([]byte(tt.input), TextReadModeRune); err != nil {
+				t.Fatal(err)
+			}
+			if got := screenText(t1); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSI_LeftRightMarginMode(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	t1.mustHandleCommand(t, "[?69h")
+	t1.mustHandleCommand(t, "[5;20s")
+	if l, r := t1.screen().LeftMargin(), t1.screen().RightMargin(); l != 4 || r != 19 {
+		t.Fatalf("margins = %d;%d, want 4;19", l, r)
+	}
+
+	// Without DECLRMM, CSI s saves the cursor again.
+	t1.mustHandleCommand(t, "[?69l")
+	if l, r := t1.screen().LeftMargin(), t1.screen().RightMargin(); l != 0 || r != 79 {
+		t.Fatalf("margins after DECLRMM reset = %d;%d, want 0;79", l, r)
+	}
+	t1.screen().setCursorPos(7, 3)
+	t1.mustHandleCommand(t, "[s")
+	t1.screen().setCursorPos(0, 0)
+	t1.mustHandleCommand(t, "[u")
+	if got := t1.screen().CursorPos(); got != (Pos{X: 7, Y: 3}) {
+		t.Errorf("cursor after CSI s/u = %v, want {7 3}", got)
+	}
+}
+
+func TestCSI_LeftRightMarginModeReset(t *testing.T) {
+	for _, reset := range []string{"c", "[!p"} {
+		forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+			t1 := makeTerminalWithScreens(newFn)
+			_ = t1.Resize(30, 5)
+			t1.mustHandleCommand(t, "[?69h")
+			t1.mustHandleCommand(t, "[5;20s")
+
+			t1.mustHandleCommand(t, reset)
+			if t1.screen().LeftRightMarginMode() {
+				t.Fatalf("%q left DECLRMM on", reset)
+			}
+			if l, r := t1.screen().LeftMargin(), t1.screen().RightMargin(); l != 0 || r != 29 {
+				t.Fatalf("margins after %q = %d;%d, want 0;29", reset, l, r)
+			}
+			t1.screen().setCursorPos(7, 3)
+			t1.mustHandleCommand(t, "[s")
+			t1.screen().setCursorPos(0, 0)
+			t1.mustHandleCommand(t, "[u")
+			if got := t1.screen().CursorPos(); got != (Pos{X: 7, Y: 3}) {
+				t.Errorf("cursor after %q and CSI s/u = %v, want {7 3}", reset, got)
+			}
+		})
+	}
+}
+
+func TestCSI_SGR_SubParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		sgr   string
+		check func(Style) bool
+	}{
+		{"4:3 curly underline", "[4:3m", func(s Style) bool {
+			return s.TestMode(ModeCurlyUnderline) && !s.TestMode(ModeUnderline)
+		}},
+		{"4:0 removes underline", "[4;4:0m", func(s Style) bool { return !s.TestMode(underlineModes) }},
+		{"4 replaces curly", "[4:3;4m", func(s Style) bool {
+			return s.TestMode(ModeUnderline) && !s.TestMode(ModeCurlyUnderline)
+		}},
+		{"24 removes curly", "[4:3;24m", func(s Style) bool { return !s.TestMode(underlineModes) }},
+		{"58:2::r:g:b", "[58:2::1:2:3m", func(s Style) bool {
+			c, rgb, _ := s.GetColor(ComponentUnderline)
+			return rgb && c == 0x010203
+		}},
+		{"58:5:n", "[58:5:42m", func(s Style) bool {
+			c, rgb, _ := s.GetColor(ComponentUnderline)
+			return !rgb && c == 42
+		}},
+		{"59 resets underline color", "[58:5:42;59m", func(s Style) bool {
+			return s.underlineColor&^modeBitsMask == colDefault
+		}},
+		{"38:2:cs:r:g:b", "[38:2:1:10:20:30m", func(s Style) bool {
+			c, rgb, _ := s.GetColor(ComponentFG)
+			return rgb && c == 0x0a141e
+		}},
+		{"38:2:r:g:b without colorspace", "[38:2:10:20:30m", func(s Style) bool {
+			c, rgb, _ := s.GetColor(ComponentFG)
+			return rgb && c == 0x0a141e
+		}},
+		{"sub-parameters do not swallow the next parameter", "[48:5:1;1m", func(s Style) bool {
+			c, _, _ := s.GetColor(ComponentBG)
+			return c == 1 && s.TestMode(ModeBold)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+			t1.mustHandleCommand(t, tt.sgr)
+			if st := t1.screen().Style(); !tt.check(st) {
+				t.Errorf("check failed for SGR %q: %#v modes %v", tt.sgr, st, st.Modes())
+			}
+		})
+	}
+}
+
+func TestReadLoop_ReplyDoesNotDeadlock(t *testing.T) {
+	outR, outW := io.Pipe()
+	_ = NewWithMode(&EmptyFrontend{}, NewNoPTYBackend(bytes.NewReader([]byte("\x1b[5n")), outW), TextReadModeRune)
+
+	reply := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 16)
+		n, _ := outR.Read(buf)
+		reply <- string(buf[:n])
+	}()
+	select {
+	case got := <-reply:
+		if got != "\x1b[0n" {
+			t.Errorf("reply = %q, want %q", got, "\x1b[0n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply from the read loop")
+	}
+}
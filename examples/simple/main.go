@@ -97,7 +97,7 @@ func main() {
 		<-time.After(time.Duration(*delay) * time.Millisecond)
 		_ = cmd.Process.Kill()
 	} else {
-		_ = cmd.Wait()
+		<-t.Done()
 	}
 	// Print the terminal screen to stdout
 	t.PrintTerminal()
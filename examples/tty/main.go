@@ -92,11 +92,6 @@ func main() {
 	signal.Notify(sigCh, syscall.SIGWINCH)
 	defer signal.Stop(sigCh)
 
-	doneCh := make(chan error, 1)
-	go func() {
-		doneCh <- cmd.Wait()
-	}()
-
 	for {
 		select {
 		case b, ok := <-inputCh:
@@ -108,7 +103,7 @@ func main() {
 			}
 		case <-sigCh:
 			resize()
-		case <-doneCh:
+		case <-term.Done():
 			tty.Detach()
 			return
 		}
@@ -0,0 +1,184 @@
+package termemu
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rivo/uniseg"
+)
+
+// ErrStopped is returned by the WaitFor methods when the terminal stops
+// before what they wait for happens.
+var ErrStopped = errors.New("terminal stopped")
+
+// Match is what WaitForText or WaitForRegex found. Matches do not span rows.
+type Match struct {
+	// X and Y are the cell where the match starts, and X2 is the cell after
+	// it ends on the same row.
+	X, Y, X2 int
+	Text     string
+	// Groups are the regex submatches, starting with the whole match. They
+	// are nil for WaitForText.
+	Groups []string
+}
+
+// notifyWaiters wakes up everything waiting in WaitFor or WaitForStable. The
+// caller must hold the lock.
+func (t *terminal) notifyWaiters() {
+	if t.changed != nil {
+		close(t.changed)
+		t.changed = nil
+	}
+}
+
+// changedChan returns a channel that is closed at the next change. The caller
+// must hold the lock.
+func (t *terminal) changedChan() chan struct{} {
+	if t.changed == nil {
+		t.changed = make(chan struct{})
+	}
+	return t.changed
+}
+
+func (t *terminal) WaitFor(ctx context.Context, cond func() bool) error {
+	for {
+		t.Lock()
+		if cond() {
+			t.Unlock()
+			return nil
+		}
+		changed := t.changedChan()
+		t.Unlock()
+
+		select {
+		case <-changed:
+		case <-t.readLoopDone:
+			t.Lock()
+			ok := cond()
+			t.Unlock()
+			if ok {
+				return nil
+			}
+			return ErrStopped
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (t *terminal) WaitForText(ctx context.Context, text string) (Match, error) {
+	return t.WaitForTextIn(ctx, t.screenRegion(), text)
+}
+
+func (t *terminal) WaitForTextIn(ctx context.Context, r Region, text string) (Match, error) {
+	return t.waitForMatch(ctx, r, func(row string) []int {
+		i := strings.Index(row, text)
+		if i < 0 {
+			return nil
+		}
+		return []int{i, i + len(text)}
+	}, false)
+}
+
+func (t *terminal) WaitForRegex(ctx context.Context, re *regexp.Regexp) (Match, error) {
+	return t.WaitForRegexIn(ctx, t.screenRegion(), re)
+}
+
+func (t *terminal) WaitForRegexIn(ctx context.Context, r Region, re *regexp.Regexp) (Match, error) {
+	return t.waitForMatch(ctx, r, re.FindStringSubmatchIndex, true)
+}
+
+func (t *terminal) WaitForCursor(ctx context.Context, x, y int) error {
+	return t.WaitFor(ctx, func() bool {
+		return t.screen().CursorPos() == Pos{X: x, Y: y}
+	})
+}
+
+// WaitForStable returns once d passes without a change, or as soon as the
+// terminal stops.
+func (t *terminal) WaitForStable(ctx context.Context, d time.Duration) error {
+	for {
+		t.Lock()
+		changed := t.changedChan()
+		t.Unlock()
+
+		quiet := make(chan struct{})
+		timer := t.clock.AfterFunc(d, func() { close(quiet) })
+		select {
+		case <-quiet:
+			return nil
+		case <-changed:
+			timer.Stop()
+		case <-t.readLoopDone:
+			timer.Stop()
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// screenRegion returns the whole active screen.
+func (t *terminal) screenRegion() Region {
+	t.Lock()
+	defer t.Unlock()
+	size := t.screen().Size()
+	return Region{X2: size.X, Y2: size.Y}
+}
+
+// waitForMatch waits until find, which returns the byte offsets of a match
+// and its submatches like regexp's Index functions, matches a row of r. The
+// submatches are kept in Match.Groups if groups is set.
+func (t *terminal) waitForMatch(ctx context.Context, r Region, find func(row string) []int, groups bool) (Match, error) {
+	var m Match
+	err := t.WaitFor(ctx, func() bool {
+		size := t.screen().Size()
+		r := r.Intersect(Region{X2: size.X, Y2: size.Y})
+		for y := r.Y; y < r.Y2; y++ {
+			row := t.screen().StyledLine(r.X, r.X2-r.X, y).PlainTextString()
+			loc := find(row)
+			if loc == nil {
+				continue
+			}
+			cols := byteColumns(row)
+			m = Match{X: r.X + cols[loc[0]], Y: y, X2: r.X + cols[loc[1]], Text: row[loc[0]:loc[1]]}
+			if groups {
+				for i := 0; i+1 < len(loc); i += 2 {
+					g := ""
+					if loc[i] >= 0 {
+						g = row[loc[i]:loc[i+1]]
+					}
+					m.Groups = append(m.Groups, g)
+				}
+			}
+			return true
+		}
+		return false
+	})
+	return m, err
+}
+
+// byteColumns maps each byte offset of row, and its length, to the cell
+// column it is drawn in.
+func byteColumns(row string) []int {
+	cols := make([]int, len(row)+1)
+	col, off := 0, 0
+	state := -1
+	rest := row
+	for len(rest) > 0 {
+		var cluster string
+		var width int
+		cluster, rest, width, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		for i := range len(cluster) {
+			cols[off+i] = col
+		}
+		off += len(cluster)
+		col += max(width, 1)
+	}
+	cols[off] = col
+	return cols
+}
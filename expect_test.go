@@ -0,0 +1,128 @@
+package termemu
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// startPipeTerminal returns a running terminal whose output the test writes
+// to w.
+func startPipeTerminal(t *testing.T, opts ...Option) (Terminal, *io.PipeWriter) {
+	t.Helper()
+	r, w := io.Pipe()
+	term := NewTerminal(NewNoPTYBackend(r, io.Discard), append([]Option{WithSize(40, 5)}, opts...)...)
+	t.Cleanup(func() { _ = term.Close() })
+	return term, w
+}
+
+func writeLater(w io.Writer, delay time.Duration, data string) {
+	go func() {
+		time.Sleep(delay)
+		_, _ = w.Write([]byte(data))
+	}()
+}
+
+func TestWaitForText(t *testing.T) {
+	for _, st := range []ScreenType{SpanScreen, GridScreen} {
+		term, w := startPipeTerminal(t, WithScreenType(st))
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		writeLater(w, 10*time.Millisecond, "$ \x1b[3;5H中文 ready\r\n")
+		m, err := term.WaitForText(ctx, "ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (Match{X: 9, Y: 2, X2: 14, Text: "ready"}); m.X != want.X || m.Y != want.Y || m.X2 != want.X2 || m.Text != want.Text {
+			t.Errorf("screen type %v: match = %+v, want %+v", st, m, want)
+		}
+		if err := term.WaitForCursor(ctx, 0, 3); err != nil {
+			t.Errorf("WaitForCursor: %v", err)
+		}
+	}
+}
+
+func TestWaitForTextIn(t *testing.T) {
+	term, w := startPipeTerminal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	writeLater(w, 10*time.Millisecond, "ok left\x1b[2;1Hok")
+	m, err := term.WaitForTextIn(ctx, Region{Y: 1, X2: 40, Y2: 5}, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.X != 0 || m.Y != 1 {
+		t.Errorf("match at %d,%d, want 0,1", m.X, m.Y)
+	}
+}
+
+func TestWaitForRegex(t *testing.T) {
+	term, w := startPipeTerminal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	writeLater(w, 10*time.Millisecond, "build: 12 passed, 0 failed")
+	m, err := term.WaitForRegex(ctx, regexp.MustCompile(`(\d+) passed`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.X != 7 || m.X2 != 16 || len(m.Groups) != 2 || m.Groups[1] != "12" {
+		t.Errorf("match = %+v", m)
+	}
+}
+
+func TestWaitForText_Cancel(t *testing.T) {
+	term, _ := startPipeTerminal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := term.WaitForText(ctx, "never"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestWaitForText_Stopped(t *testing.T) {
+	term, w := startPipeTerminal(t)
+	writeLater(w, 10*time.Millisecond, "bye")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = w.Close()
+	}()
+
+	if _, err := term.WaitForText(context.Background(), "never"); !errors.Is(err, ErrStopped) {
+		t.Errorf("err = %v, want ErrStopped", err)
+	}
+	if _, err := term.WaitForText(context.Background(), "bye"); err != nil {
+		t.Errorf("WaitForText after stopping: %v", err)
+	}
+}
+
+func TestWaitForStable(t *testing.T) {
+	term, w := startPipeTerminal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			_, _ = w.Write([]byte("x"))
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	if _, err := term.WaitForText(ctx, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := term.WaitForStable(ctx, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	term.Lock()
+	line := term.Line(0)
+	term.Unlock()
+	if line[:5] != "xxxxx" {
+		t.Errorf("screen was not stable yet: %q", line)
+	}
+}
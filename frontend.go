@@ -20,6 +20,7 @@ const (
 	VIMouseMode ViewInt = iota
 	VIMouseEncoding
 	VIModifyOtherKeys
+	VICursorShape
 	viewIntCount
 )
 
@@ -49,6 +50,17 @@ const (
 	MESGR
 )
 
+// Cursor shapes for VICursorShape, as numbered by DECSCUSR
+const (
+	CSDefault int = iota
+	CSBlinkingBlock
+	CSSteadyBlock
+	CSBlinkingUnderline
+	CSSteadyUnderline
+	CSBlinkingBar
+	CSSteadyBar
+)
+
 // ChangeReason says what kind of change caused the region to change, for optimization etc.
 type ChangeReason int
 
@@ -80,9 +92,10 @@ type Frontend interface {
 	Bell()
 	RegionChanged(Region, ChangeReason)
 
-	// ScrollLines is called when lines are about to be scrolled off the
-	// top of the main (not alternate) screen. If you want to save to a scrollback
-	// buffer, do it now.
+	// ScrollLines is called when the top y lines are about to be scrolled off
+	// the top of the main (not alternate) screen. The terminal already keeps
+	// them in its own scrollback (see Terminal.ScrollbackLines), but if you want
+	// to save them elsewhere, do it now.
 	ScrollLines(y int)
 	CursorMoved(x, y int)
 	StyleChanged(s Style)
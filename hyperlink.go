@@ -0,0 +1,57 @@
+package termemu
+
+import "strings"
+
+// Hyperlink is an OSC 8 hyperlink attached to the cells written while it was
+// active.
+type Hyperlink struct {
+	URI string
+	// ID is the optional id parameter. Cells with the same URI and ID belong
+	// to one link even when they are not next to each other.
+	ID string
+}
+
+// oscHyperlink handles OSC 8 ; params ; URI. An empty URI ends the link.
+func (t *terminal) oscHyperlink(arg string) bool {
+	params, uri, ok := strings.Cut(arg, ";")
+	if !ok {
+		return false
+	}
+	if uri == "" {
+		t.screen().setHyperlink(nil)
+		return true
+	}
+
+	link := Hyperlink{URI: uri}
+	for _, p := range strings.Split(params, ":") {
+		if k, v, _ := strings.Cut(p, "="); k == "id" {
+			link.ID = v
+		}
+	}
+	// Keep the current link if it is the same, so its cells stay one span.
+	if cur := t.screen().Hyperlink(); cur != nil && *cur == link {
+		return true
+	}
+	t.screen().setHyperlink(&link)
+	return true
+}
+
+// sameHyperlink reports whether a and b are the same link, or both nil.
+func sameHyperlink(a, b *Hyperlink) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// hyperlinkEscape returns the OSC 8 sequence that starts link, or ends the
+// current link if link is nil.
+func hyperlinkEscape(link *Hyperlink) string {
+	if link == nil {
+		return "\x1b]8;;\x1b\\"
+	}
+	if link.ID != "" {
+		return "\x1b]8;id=" + link.ID + ";" + link.URI + "\x1b\\"
+	}
+	return "\x1b]8;;" + link.URI + "\x1b\\"
+}
@@ -0,0 +1,140 @@
+package termemu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// linkCells returns the text of each span in l with the URI of its link, as
+// "text" or "text@uri".
+func linkCells(l Line) []string {
+	var out []string
+	for _, sp := range l.Spans {
+		text := sp.Text
+		if text == "" {
+			text = strings.Repeat(string(sp.Rune), sp.Width)
+		}
+		if sp.Link != nil {
+			text += "@" + sp.Link.URI
+		}
+		out = append(out, text)
+	}
+	return out
+}
+
+func TestHyperlink_SpansSplitAtLinks(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		feed(t, t1, "a\x1b]8;;http://x\x1b\\bc\x1b]8;;\x1b\\d")
+
+		got := linkCells(t1.StyledLine(0, 4, 0))
+		want := []string{"a", "bc@http://x", "d"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("spans = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHyperlink_SameStyleDifferentLinks(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		feed(t, t1, "\x1b]8;;http://a\x1b\\ab\x1b]8;;http://b\x1b\\cd\x1b]8;;\x1b\\")
+
+		got := linkCells(t1.StyledLine(0, 4, 0))
+		want := []string{"ab@http://a", "cd@http://b"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("spans = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHyperlink_Params(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	feed(t, t1, "\x1b]8;foo=bar:id=42;http://x/?a=1;b=2\x1b\\")
+	link := t1.screen().Hyperlink()
+	if link == nil || link.ID != "42" || link.URI != "http://x/?a=1;b=2" {
+		t.Fatalf("link = %+v", link)
+	}
+
+	// The same link again keeps the current one.
+	feed(t, t1, "\x1b]8;id=42;http://x/?a=1;b=2\a")
+	if t1.screen().Hyperlink() != link {
+		t.Errorf("repeating the same link replaced it")
+	}
+
+	// SGR does not end a link; RIS does.
+	feed(t, t1, "\x1b[0m")
+	if t1.screen().Hyperlink() != link {
+		t.Errorf("SGR 0 ended the link")
+	}
+	feed(t, t1, "\x1bc")
+	if t1.screen().Hyperlink() != nil {
+		t.Errorf("RIS kept the link")
+	}
+}
+
+func TestHyperlink_NotAppliedToErasedCells(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		feed(t, t1, "\x1b]8;;http://x\x1b\\ab\x1b[K\x1b[1@\x1b[2;1H\x1b[2X")
+
+		for y := 0; y < 2; y++ {
+			for _, sp := range t1.StyledLine(2, 10, y).Spans {
+				if sp.Link != nil {
+					t.Errorf("row %d: blank span %q has link %v", y, sp.Text, sp.Link)
+				}
+			}
+		}
+		if got := linkCells(t1.StyledLine(0, 3, 0)); fmt.Sprint(got) != fmt.Sprint([]string{"ab@http://x", " "}) {
+			t.Errorf("spans = %q", got)
+		}
+	})
+}
+
+func TestHyperlink_MovesWithText(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(10, 2)
+		feed(t, t1, "xy\x1b]8;;http://x\x1b\\ab\x1b]8;;\x1b\\\x1b[1;1H\x1b[1P")
+		if got := linkCells(t1.StyledLine(0, 3, 0)); fmt.Sprint(got) != fmt.Sprint([]string{"y", "ab@http://x"}) {
+			t.Errorf("after DCH: %q", got)
+		}
+
+		// Scroll the row into the scrollback.
+		feed(t, t1, "\x1b[2;1H\n\n")
+		if t1.ScrollbackLen() == 0 {
+			t.Fatal("nothing scrolled into the scrollback")
+		}
+		found := false
+		for _, sp := range t1.ScrollbackLines(0, 1)[0].Spans {
+			if sp.Link != nil && sp.Link.URI == "http://x" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("scrollback line lost the link")
+		}
+	})
+}
+
+func TestRenderStyledLineANSI_Hyperlinks(t *testing.T) {
+	link := &Hyperlink{URI: "http://x", ID: "7"}
+	line := Line{Spans: []Span{
+		{Style: NewStyle(), Text: "a", Width: 1},
+		{Style: NewStyle(), Text: "bc", Width: 2, Link: link},
+		{Style: NewStyle(), Text: "d", Width: 1, Link: &Hyperlink{URI: "http://x", ID: "7"}},
+		{Style: NewStyle(), Text: "e", Width: 1},
+	}}
+	got := string(renderStyledLineANSI(line))
+	want := "a\x1b]8;id=7;http://x\x1b\\bcd\x1b]8;;\x1b\\e"
+	if strings.ReplaceAll(got, string(NewStyle().ANSIEscape()), "") != want {
+		t.Errorf("render = %q, want %q", got, want)
+	}
+
+	// A link open at the end of the line is closed.
+	got = string(renderStyledLineANSI(Line{Spans: []Span{{Style: NewStyle(), Text: "a", Width: 1, Link: link}}}))
+	if !strings.HasSuffix(got, "\x1b]8;;\x1b\\") {
+		t.Errorf("render = %q, want the link closed", got)
+	}
+}
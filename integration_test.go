@@ -4,6 +4,9 @@
 package termemu
 
 import (
+	"context"
+	"os/exec"
+	"strings"
 	"testing"
 	"time"
 )
@@ -34,11 +37,75 @@ func TestIntegration_RunPrintf(t *testing.T) {
 		t.Fatalf("writing to tty failed: %v", err)
 	}
 
-	// Give ptyReadLoop a moment to process incoming bytes
-	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := tt.WaitForText(ctx, "hello_integration"); err != nil {
+		t.Fatalf("waiting for output: %v", err)
+	}
 
 	mf := tln.frontend.(*MockFrontend)
 	if mf.RegionCount() == 0 {
 		t.Fatalf("expected frontend RegionChanged calls, got none")
 	}
 }
+
+func TestIntegration_ExitCode(t *testing.T) {
+	backend := &PTYBackend{}
+	if err := backend.StartCommand(exec.Command("sh", "-c", "printf done; exit 3")); err != nil {
+		t.Skipf("cannot start a command in a pty: %v", err)
+	}
+	f := &exitFrontend{}
+	term := New(f, backend)
+	waitDone(t, term)
+
+	if err := term.Err(); err != nil {
+		t.Errorf("Err = %v, want nil", err)
+	}
+	if code := backend.ExitCode(); code != 3 {
+		t.Errorf("ExitCode = %d, want 3", code)
+	}
+	if backend.ProcessState() == nil {
+		t.Error("ProcessState is nil after exit")
+	}
+	if f.calls != 1 || f.code != 3 {
+		t.Errorf("Exited called %d times with code %d, want once with 3", f.calls, f.code)
+	}
+	term.Lock()
+	line := term.Line(0)
+	term.Unlock()
+	if !strings.HasPrefix(line, "done") {
+		t.Errorf("line = %q, want done", line)
+	}
+	if err := term.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestIntegration_CloseKillsIgnoringHUP(t *testing.T) {
+	backend := &PTYBackend{}
+	if err := backend.StartCommand(exec.Command("sh", "-c", "trap '' HUP; echo ready; sleep 60")); err != nil {
+		t.Skipf("cannot start a command in a pty: %v", err)
+	}
+	f := &exitFrontend{}
+	term := New(f, backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := term.WaitForText(ctx, "ready"); err != nil {
+		t.Fatalf("waiting for output: %v", err)
+	}
+	if err := term.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	select {
+	case <-term.Done():
+	case <-time.After(closeKillDelay + 2*time.Second):
+		t.Fatal("terminal did not stop after Close")
+	}
+	if state := backend.ProcessState(); state == nil || state.Exited() {
+		t.Errorf("ProcessState = %v, want killed", state)
+	}
+	if f.calls != 1 || f.code != -1 {
+		t.Errorf("Exited called %d times with code %d, want once with -1", f.calls, f.code)
+	}
+}
@@ -0,0 +1,110 @@
+package termemu
+
+import (
+	"errors"
+	"io"
+)
+
+// ExitFrontend is implemented by frontends that want to know when the
+// terminal stops, usually because its process exited.
+type ExitFrontend interface {
+	// Exited is called once when the terminal stops reading output. code is
+	// the process's exit code, or -1 if it is unknown or there is no
+	// process, and err is the same as Terminal.Err.
+	// Unlike other Frontend methods it is called without the terminal
+	// locked.
+	Exited(code int, err error)
+}
+
+// processBackend is implemented by backends that run a process, such as
+// PTYBackend.
+type processBackend interface {
+	Wait() error
+	ExitCode() int
+}
+
+// unwrapBackend finds a backend of type T, looking through wrappers such as
+// TeeBackend and CastRecorder that have an Unwrap method.
+func unwrapBackend[T any](b Backend) (T, bool) {
+	for b != nil {
+		if found, ok := b.(T); ok {
+			return found, true
+		}
+		u, ok := b.(interface{ Unwrap() Backend })
+		if !ok {
+			break
+		}
+		b = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// Close stops the terminal and closes its backend if the backend implements
+// io.Closer. Done is closed once the read loop has stopped.
+func (t *terminal) Close() error {
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		return nil
+	}
+	t.closed = true
+	started := t.readLoopStarted
+	backend := t.backend
+	t.Unlock()
+
+	var err error
+	if c, ok := backend.(io.Closer); ok {
+		err = c.Close()
+	}
+	if !started {
+		t.finish(nil)
+	}
+	return err
+}
+
+// Done returns a channel that is closed when the terminal has stopped, after
+// its process exited or Close was called.
+func (t *terminal) Done() <-chan struct{} {
+	return t.readLoopDone
+}
+
+// Err returns the error that stopped the terminal. It is nil while the
+// terminal is running and when the output ended normally or Close was called.
+func (t *terminal) Err() error {
+	t.Lock()
+	defer t.Unlock()
+	return t.err
+}
+
+// readLoopError decides what a read error that stopped the read loop means
+// for Err.
+func (t *terminal) readLoopError(err error) error {
+	t.Lock()
+	closed := t.closed
+	t.Unlock()
+	if closed || errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// finish records why the terminal stopped, waits for its process if it has
+// one, tells the frontend and closes Done.
+func (t *terminal) finish(err error) {
+	code := -1
+	if p, ok := unwrapBackend[processBackend](t.backend); ok {
+		_ = p.Wait()
+		code = p.ExitCode()
+	}
+
+	t.Lock()
+	t.err = err
+	f := t.frontend
+	t.Unlock()
+
+	if ef, ok := f.(ExitFrontend); ok {
+		ef.Exited(code, err)
+	}
+	close(t.readLoopDone)
+}
@@ -0,0 +1,105 @@
+package termemu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type exitFrontend struct {
+	EmptyFrontend
+	mu    sync.Mutex
+	calls int
+	code  int
+	err   error
+}
+
+func (f *exitFrontend) Exited(code int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	f.code = code
+	f.err = err
+}
+
+func waitDone(t *testing.T, term Terminal) {
+	t.Helper()
+	select {
+	case <-term.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("terminal did not stop")
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestTerminal_DoneAtEOF(t *testing.T) {
+	f := &exitFrontend{}
+	term := New(f, NewNoPTYBackend(bytes.NewReader([]byte("hello")), io.Discard))
+	waitDone(t, term)
+
+	if err := term.Err(); err != nil {
+		t.Errorf("Err = %v, want nil", err)
+	}
+	term.Lock()
+	line := term.Line(0)
+	term.Unlock()
+	if line[:5] != "hello" {
+		t.Errorf("line = %q, want hello", line)
+	}
+	if f.calls != 1 || f.code != -1 || f.err != nil {
+		t.Errorf("Exited called %d times with %d, %v", f.calls, f.code, f.err)
+	}
+}
+
+func TestTerminal_ReadError(t *testing.T) {
+	want := errors.New("boom")
+	f := &exitFrontend{}
+	term := New(f, NewNoPTYBackend(errReader{want}, io.Discard))
+	waitDone(t, term)
+
+	if err := term.Err(); !errors.Is(err, want) {
+		t.Errorf("Err = %v, want %v", err, want)
+	}
+	if !errors.Is(f.err, want) {
+		t.Errorf("Exited got %v, want %v", f.err, want)
+	}
+}
+
+func TestTerminal_Close(t *testing.T) {
+	r, w := io.Pipe()
+	f := &exitFrontend{}
+	term := New(f, NewNoPTYBackend(r, w))
+
+	select {
+	case <-term.Done():
+		t.Fatal("Done closed before Close")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := term.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitDone(t, term)
+	if err := term.Err(); err != nil {
+		t.Errorf("Err after Close = %v, want nil", err)
+	}
+	if err := term.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if f.calls != 1 {
+		t.Errorf("Exited called %d times, want 1", f.calls)
+	}
+}
+
+func TestTerminal_CloseWithoutReadLoop(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	if err := t1.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitDone(t, t1)
+}
@@ -10,12 +10,21 @@ type Span struct {
 	Text  string
 	Rune  rune
 	Width int
+	Link  *Hyperlink // OSC 8 hyperlink, or nil
 }
 
 // Line holds a list of spans
 type Line struct {
 	Spans []Span
 	Width int
+
+	// Wrapped is true when autowrap continued this row onto the next one, so
+	// the two rows form a single logical line.
+	Wrapped bool
+
+	// Marks are the OSC 133 shell integration marks set on this row, oldest
+	// first. Mark.X is relative to the start of the Line.
+	Marks []Mark
 }
 
 func (l Line) PlainTextString() string {
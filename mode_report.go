@@ -0,0 +1,124 @@
+package termemu
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Mode states reported by DECRPM.
+const (
+	modeNotRecognized = iota
+	modeSet
+	modeReset
+	modePermanentlySet
+	modePermanentlyReset
+)
+
+func modeState(set bool) int {
+	if set {
+		return modeSet
+	}
+	return modeReset
+}
+
+// ansiModeState returns the DECRPM state of an ANSI mode.
+func (t *terminal) ansiModeState(mode int) int {
+	switch mode {
+	case 4: // IRM Insert mode
+		return modeState(t.screen().InsertMode())
+	case 20: // LNM Linefeed/new line mode; LF never returns the carriage
+		return modePermanentlyReset
+	}
+	return modeNotRecognized
+}
+
+// privateModeState returns the DECRPM state of a DEC private mode, matching
+// what csiSetPrivateMode sets.
+func (t *terminal) privateModeState(mode int) int {
+	switch mode {
+	case 1:
+		return modeState(t.viewFlags[VFAppCursorKeys])
+	case 6:
+		return modeState(t.screen().OriginMode())
+	case 7:
+		return modeState(t.screen().AutoWrap())
+	case 9:
+		return modeState(t.viewInts[VIMouseMode] == MMPress)
+	case 12:
+		return modeState(t.viewFlags[VFBlinkCursor])
+	case 25:
+		return modeState(t.viewFlags[VFShowCursor])
+	case 69:
+		return modeState(t.screen().LeftRightMarginMode())
+	case 1000:
+		return modeState(t.viewInts[VIMouseMode] == MMPressRelease)
+	case 1002:
+		return modeState(t.viewInts[VIMouseMode] == MMPressReleaseMove)
+	case 1003:
+		return modeState(t.viewInts[VIMouseMode] == MMPressReleaseMoveAll)
+	case 1004:
+		return modeState(t.viewFlags[VFReportFocus])
+	case 1005, 1015:
+		return modeState(t.viewInts[VIMouseEncoding] == MEUTF8)
+	case 1006:
+		return modeState(t.viewInts[VIMouseEncoding] == MESGR)
+	case 47, 1047, 1049:
+		return modeState(t.onAltScreen)
+	case 2004:
+		return modeState(t.viewFlags[VFBracketedPaste])
+	case 2026:
+		return modeState(t.sync != nil)
+	}
+	return modeNotRecognized
+}
+
+// csiRequestMode is DECRQM Request Mode for ANSI modes (CSI Ps $ p) and DEC
+// private modes (CSI ? Ps $ p). The reply is DECRPM.
+func (t *terminal) csiRequestMode(seq *sequence) bool {
+	mode := seq.param(0, 0)
+	if seq.prefix == '?' {
+		t.replyf("\x1b[?%d;%d$y", mode, t.privateModeState(mode))
+	} else {
+		t.replyf("\x1b[%d;%d$y", mode, t.ansiModeState(mode))
+	}
+	return true
+}
+
+// dcsRequestStatusString is DECRQSS Request Status String (DCS $ q Pt ST).
+// The reply is DECRPSS: DCS 1 $ r with the control function that restores the
+// setting, or DCS 0 $ r if Pt is not supported.
+func (t *terminal) dcsRequestStatusString(seq *sequence) bool {
+	s := t.screen()
+	var status string
+	switch string(seq.data) {
+	case "m": // SGR
+		status = s.Style().sgrParams() + "m"
+	case "r": // DECSTBM
+		status = fmt.Sprintf("%d;%dr", s.TopMargin()+1, s.BottomMargin()+1)
+	case "s": // DECSLRM
+		status = fmt.Sprintf("%d;%ds", s.LeftMargin()+1, s.RightMargin()+1)
+	case " q": // DECSCUSR
+		status = fmt.Sprintf("%d q", t.viewInts[VICursorShape])
+	default:
+		debugPrintf(debugTodo, "TODO: Unhandled DECRQSS %q\n", seq.data)
+		t.replyf("\x1bP0$r\x1b\\")
+		return true
+	}
+	t.replyf("\x1bP1$r%s\x1b\\", status)
+	return true
+}
+
+// sgrParams returns the SGR parameters that select this style, starting with
+// a reset, as in "0;1;38;5;208".
+func (s Style) sgrParams() string {
+	params := []string{"0"}
+	for _, p := range bytes.Split(s.ANSIEscape(), []byte{ESC, '['}) {
+		p = bytes.TrimSuffix(p, []byte{'m'})
+		if len(p) == 0 || string(p) == "0" {
+			continue
+		}
+		params = append(params, string(p))
+	}
+	return strings.Join(params, ";")
+}
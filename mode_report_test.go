@@ -0,0 +1,66 @@
+package termemu
+
+import "testing"
+
+func TestDECRQM(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	tests := []struct {
+		setup string
+		query string
+		want  string
+	}{
+		{"", "[?2004$p", "\x1b[?2004;2$y"},
+		{"[?2004h", "[?2004$p", "\x1b[?2004;1$y"},
+		{"", "[?25$p", "\x1b[?25;1$y"},
+		{"[?1002h", "[?1002$p", "\x1b[?1002;1$y"},
+		{"", "[?1000$p", "\x1b[?1000;2$y"},
+		{"[?1006h", "[?1006$p", "\x1b[?1006;1$y"},
+		{"[?1049h", "[?1049$p", "\x1b[?1049;1$y"},
+		{"[?1049l", "[?47$p", "\x1b[?47;2$y"},
+		{"[?7h", "[?7$p", "\x1b[?7;1$y"},
+		{"", "[?9999$p", "\x1b[?9999;0$y"},
+		{"[4h", "[4$p", "\x1b[4;1$y"},
+		{"[4l", "[4$p", "\x1b[4;2$y"},
+		{"", "[20$p", "\x1b[20;4$y"},
+		{"", "[3$p", "\x1b[3;0$y"},
+	}
+	for _, tt := range tests {
+		if tt.setup != "" {
+			t1.mustHandleCommand(t, tt.setup)
+		}
+		t1.mustHandleCommand(t, tt.query)
+		if got := readReply(t, r); got != tt.want {
+			t.Errorf("after %q, %q replied %q, want %q", tt.setup, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDECRQSS(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(80, 24)
+
+	tests := []struct {
+		setup string
+		query string
+		want  string
+	}{
+		{"", "P$qm\x1b\\", "\x1bP1$r0m\x1b\\"},
+		{"[1;4:3;38;5;208m", "P$qm\x1b\\", "\x1bP1$r0;1;4:3;38;5;208m\x1b\\"},
+		{"[0m", "P$qr\x1b\\", "\x1bP1$r1;24r\x1b\\"},
+		{"[2;5r", "P$qr\x1b\\", "\x1bP1$r2;5r\x1b\\"},
+		{"[?69h", "P$qs\x1b\\", "\x1bP1$r1;80s\x1b\\"},
+		{"[10;20s", "P$qs\x1b\\", "\x1bP1$r10;20s\x1b\\"},
+		{"[4 q", "P$q q\x1b\\", "\x1bP1$r4 q\x1b\\"},
+		{"", "P$qx\x1b\\", "\x1bP0$r\x1b\\"},
+	}
+	for _, tt := range tests {
+		if tt.setup != "" {
+			t1.mustHandleCommand(t, tt.setup)
+		}
+		t1.mustHandleCommand(t, tt.query)
+		if got := readReply(t, r); got != tt.want {
+			t.Errorf("after %q, %q replied %q, want %q", tt.setup, tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package termemu
+
+import (
+	"maps"
+	"time"
+)
+
+// ScreenType selects how a terminal stores its screens.
+type ScreenType int
+
+const (
+	// SpanScreen stores each row as runs of equally styled text. It is the
+	// default and uses little memory for mostly plain output.
+	SpanScreen ScreenType = iota
+	// GridScreen stores a cell per column, which is faster for programs
+	// that redraw scattered cells.
+	GridScreen
+)
+
+// Clock is the source of time for the terminal's timeouts. Tests can supply
+// one they control.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call made by Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the call, reporting whether it had not happened yet.
+	Stop() bool
+}
+
+// systemClock is the Clock backed by package time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+const (
+	defaultPrimaryDA   = "?1;2"
+	defaultSecondaryDA = ">1;4402;0"
+)
+
+// config is what the Options given to NewTerminal set.
+type config struct {
+	frontend        Frontend
+	width, height   int
+	screenType      ScreenType
+	mode            TextReadMode
+	scrollbackLines int
+	primaryDA       string
+	secondaryDA     string
+	answerback      string
+	clock           Clock
+	syncTimeout     time.Duration
+	capabilities    map[string]string
+	term            string
+}
+
+func defaultConfig() config {
+	return config{
+		mode:            TextReadModeRune,
+		scrollbackLines: DefaultScrollbackLines,
+		primaryDA:       defaultPrimaryDA,
+		secondaryDA:     defaultSecondaryDA,
+		clock:           systemClock{},
+		syncTimeout:     DefaultSyncTimeout,
+	}
+}
+
+// Option configures a terminal made by NewTerminal.
+type Option func(*config)
+
+// WithFrontend sets the Frontend. The default is an EmptyFrontend.
+func WithFrontend(f Frontend) Option {
+	return func(c *config) { c.frontend = f }
+}
+
+// WithSize sets the initial size in cells, which is also given to the Backend.
+// The default is 80x14.
+func WithSize(w, h int) Option {
+	return func(c *config) { c.width, c.height = w, h }
+}
+
+// WithScreenType selects how the screens are stored. The default is
+// SpanScreen.
+func WithScreenType(st ScreenType) Option {
+	return func(c *config) { c.screenType = st }
+}
+
+// WithTextReadMode sets how output text is split into cells. The default is
+// TextReadModeRune.
+func WithTextReadMode(mode TextReadMode) Option {
+	return func(c *config) { c.mode = mode }
+}
+
+// WithScrollback limits the main screen's history to n lines. n <= 0
+// disables it. The default is DefaultScrollbackLines.
+func WithScrollback(n int) Option {
+	return func(c *config) { c.scrollbackLines = n }
+}
+
+// WithTerm sets the terminal name XTGETTCAP reports as TN and name. If the
+// backend is a PTYBackend, or wraps one, its Term is set too, so a command
+// started afterwards with StartCommand gets name as TERM. A command that is
+// already running keeps its TERM.
+func WithTerm(name string) Option {
+	return func(c *config) {
+		c.term = name
+		WithCapability("TN", name)(c)
+		WithCapability("name", name)(c)
+	}
+}
+
+// WithCapability sets a capability reported by XTGETTCAP, like
+// Terminal.SetCapability.
+func WithCapability(name, value string) Option {
+	return func(c *config) {
+		if c.capabilities == nil {
+			c.capabilities = maps.Clone(defaultCapabilities)
+		}
+		c.capabilities[name] = value
+	}
+}
+
+// WithDeviceAttributes sets the replies to DA1 (CSI c) and DA2 (CSI > c),
+// given as the parameters between CSI and the final c. The defaults are
+// "?1;2" (VT100 with advanced video) and ">1;4402;0". An empty string keeps
+// the default.
+func WithDeviceAttributes(primary, secondary string) Option {
+	return func(c *config) {
+		if primary != "" {
+			c.primaryDA = primary
+		}
+		if secondary != "" {
+			c.secondaryDA = secondary
+		}
+	}
+}
+
+// WithAnswerback sets the reply to ENQ. The default is no reply.
+func WithAnswerback(s string) Option {
+	return func(c *config) { c.answerback = s }
+}
+
+// WithClock sets the source of time for timeouts.
+func WithClock(clock Clock) Option {
+	return func(c *config) { c.clock = clock }
+}
+
+// WithSyncTimeout sets how long synchronized output may hold back
+// notifications. The default is DefaultSyncTimeout.
+func WithSyncTimeout(d time.Duration) Option {
+	return func(c *config) { c.syncTimeout = d }
+}
+
+// NewTerminal makes a new terminal reading from backend and starts reading.
+// It returns nil if backend is nil.
+func NewTerminal(backend Backend, opts ...Option) Terminal {
+	if backend == nil {
+		return nil
+	}
+	c := defaultConfig()
+	for _, opt := range opts {
+		opt(&c)
+	}
+	t := newTerminalWithConfig(backend, &c)
+	t.startReadLoop()
+	return t
+}
+
+// newScreenOfType makes an empty screen of the given type.
+func newScreenOfType(st ScreenType, f Frontend) screen {
+	if st == GridScreen {
+		return newGridScreen(f)
+	}
+	return newScreen(f)
+}
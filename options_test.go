@@ -0,0 +1,138 @@
+package termemu
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// makeTerminalWithOptions is MakeTerminalWithMock for NewTerminal's options:
+// it returns the reply reader and a terminal without a read loop.
+func makeTerminalWithOptions(opts ...Option) (io.ReadCloser, *terminal) {
+	r, w := NewBufPipe(10)
+	c := defaultConfig()
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return r, newTerminalWithConfig(NewNoPTYBackend(bytes.NewReader(nil), w), &c)
+}
+
+type fakeTimer struct {
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	was := !t.stopped
+	t.stopped = true
+	return was
+}
+
+// fakeClock records the calls it is asked to make so tests can run them. If
+// step is set, its time moves forward by step on every Now.
+type fakeClock struct {
+	now    time.Time
+	step   time.Duration
+	timers []*fakeTimer
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func TestNewTerminal_Options(t *testing.T) {
+	mf := NewMockFrontend()
+	term := NewTerminal(NewNoPTYBackend(bytes.NewReader([]byte("hi")), io.Discard),
+		WithFrontend(mf),
+		WithSize(100, 30),
+		WithScreenType(GridScreen),
+		WithScrollback(5),
+		WithTextReadMode(TextReadModeGrapheme),
+	)
+	<-term.Done()
+
+	t1 := term.(*terminal)
+	if w, h := t1.Size(); w != 100 || h != 30 {
+		t.Errorf("size = %dx%d, want 100x30", w, h)
+	}
+	if _, ok := t1.mainScreen.(*gridScreen); !ok {
+		t.Errorf("main screen is %T, want *gridScreen", t1.mainScreen)
+	}
+	if t1.scrollback.maxLines != 5 {
+		t.Errorf("scrollback limit = %d, want 5", t1.scrollback.maxLines)
+	}
+	if t1.textReadMode != TextReadModeGrapheme {
+		t.Errorf("text read mode = %v, want grapheme", t1.textReadMode)
+	}
+	if t1.frontend != mf || mf.RegionCount() == 0 {
+		t.Errorf("frontend was not used")
+	}
+	if NewTerminal(nil) != nil {
+		t.Errorf("NewTerminal(nil) is not nil")
+	}
+}
+
+func TestNewTerminal_Identity(t *testing.T) {
+	r, t1 := makeTerminalWithOptions(
+		WithDeviceAttributes("?62;22", ""),
+		WithAnswerback("termemu"),
+		WithTerm("termemu-256color"),
+		WithCapability("Co", "16"),
+	)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"\x1b[c", "\x1b[?62;22c"},
+		{"\x1b[>c", "\x1b[>1;4402;0c"},
+		{"\x05", "termemu"},
+		{"\x1bP+q" + hexCap("TN") + "\x1b\\", "\x1bP1+r" + hexCap("TN") + "=" + hexCap("termemu-256color") + "\x1b\\"},
+		{"\x1bP+q" + hexCap("Co") + "\x1b\\", "\x1bP1+r" + hexCap("Co") + "=" + hexCap("16") + "\x1b\\"},
+	}
+	for _, tt := range tests {
+		feed(t, t1, tt.query)
+		if got := readReply(t, r); got != tt.want {
+			t.Errorf("%q replied %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// The defaults are not changed by another terminal's options.
+	if v, _ := (&terminal{}).capability("Co"); v != "256" {
+		t.Errorf("default Co = %q, want 256", v)
+	}
+}
+
+func TestNewTerminal_TermSetsPTYBackend(t *testing.T) {
+	p := &PTYBackend{}
+	c := defaultConfig()
+	WithTerm("termemu-256color")(&c)
+	newTerminalWithConfig(NewTeeBackend(p), &c)
+	if p.Term != "termemu-256color" {
+		t.Errorf("PTYBackend.Term = %q, want termemu-256color", p.Term)
+	}
+}
+
+func TestNewTerminal_Clock(t *testing.T) {
+	clock := &fakeClock{}
+	_, t1 := makeTerminalWithOptions(WithClock(clock), WithSyncTimeout(time.Second))
+	mf := NewMockFrontend()
+	t1.SetFrontend(mf)
+
+	feed(t, t1, "\x1b[?2026habc")
+	if len(clock.timers) != 1 || mf.RegionCount() != 0 {
+		t.Fatalf("got %d timers and %d region changes", len(clock.timers), mf.RegionCount())
+	}
+	clock.timers[0].f()
+	if mf.RegionCount() != 1 {
+		t.Errorf("got %d region changes after the timeout, want 1", mf.RegionCount())
+	}
+}
@@ -0,0 +1,276 @@
+package termemu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RGB is a 24-bit color.
+type RGB struct {
+	R, G, B uint8
+}
+
+// Palette indexes past the 256 indexed colors.
+const (
+	// PaletteForeground is the default foreground color (OSC 10).
+	PaletteForeground = 256 + iota
+	// PaletteBackground is the default background color (OSC 11).
+	PaletteBackground
+	// PaletteCursor is the cursor color (OSC 12).
+	PaletteCursor
+	paletteSize
+)
+
+// palette holds the current colors and the base colors that resets return to.
+// Escapes change only the current colors; the API changes both.
+type palette struct {
+	current [paletteSize]RGB
+	base    [paletteSize]RGB
+}
+
+// PaletteFrontend can be implemented by a Frontend to be told when a palette
+// entry changes, by OSC 4, 10-12, 104 or 110-112, by a reset, or through
+// Terminal.SetPaletteColor. index is 0-255, PaletteForeground,
+// PaletteBackground or PaletteCursor. Like other Frontend methods,
+// PaletteChanged is called with the terminal lock held.
+type PaletteFrontend interface {
+	PaletteChanged(index int, c RGB)
+}
+
+// xtermColors are the first 16 colors of xterm's default palette.
+var xtermColors = [16]RGB{
+	{0x00, 0x00, 0x00}, {0xcd, 0x00, 0x00}, {0x00, 0xcd, 0x00}, {0xcd, 0xcd, 0x00},
+	{0x00, 0x00, 0xee}, {0xcd, 0x00, 0xcd}, {0x00, 0xcd, 0xcd}, {0xe5, 0xe5, 0xe5},
+	{0x7f, 0x7f, 0x7f}, {0xff, 0x00, 0x00}, {0x00, 0xff, 0x00}, {0xff, 0xff, 0x00},
+	{0x5c, 0x5c, 0xff}, {0xff, 0x00, 0xff}, {0x00, 0xff, 0xff}, {0xff, 0xff, 0xff},
+}
+
+// defaultPaletteColor returns the xterm default for a palette index.
+func defaultPaletteColor(i int) RGB {
+	switch {
+	case i < 16:
+		return xtermColors[i]
+	case i < 232: // 6x6x6 color cube
+		levels := [6]uint8{0x00, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+		i -= 16
+		return RGB{levels[i/36], levels[i/6%6], levels[i%6]}
+	case i < 256: // grayscale ramp
+		v := uint8(8 + 10*(i-232))
+		return RGB{v, v, v}
+	case i == PaletteBackground:
+		return xtermColors[0]
+	default: // foreground and cursor
+		return xtermColors[7]
+	}
+}
+
+func newPalette() palette {
+	var p palette
+	for i := range p.base {
+		p.base[i] = defaultPaletteColor(i)
+	}
+	p.current = p.base
+	return p
+}
+
+// PaletteColor returns the current color of a palette entry: 0-255 for the
+// indexed colors, or PaletteForeground, PaletteBackground or PaletteCursor.
+// It locks the terminal.
+func (t *terminal) PaletteColor(index int) RGB {
+	if index < 0 || index >= paletteSize {
+		return RGB{}
+	}
+	var c RGB
+	t.WithLock(func() {
+		c = t.palette.current[index]
+	})
+	return c
+}
+
+// SetPaletteColor sets a palette entry. The color also becomes the one that
+// OSC 104 and OSC 110-112 reset the entry to.
+func (t *terminal) SetPaletteColor(index int, c RGB) {
+	t.WithLock(func() {
+		if index < 0 || index >= paletteSize {
+			return
+		}
+		t.palette.base[index] = c
+		t.setPaletteColor(index, c)
+	})
+}
+
+// setPaletteColor changes the current color of an entry and notifies the
+// frontend if it changed.
+func (t *terminal) setPaletteColor(index int, c RGB) {
+	if t.palette.current[index] == c {
+		return
+	}
+	t.palette.current[index] = c
+	if f, ok := t.frontend.(PaletteFrontend); ok {
+		f.PaletteChanged(index, c)
+	}
+}
+
+// resetPaletteColor returns an entry to its base color.
+func (t *terminal) resetPaletteColor(index int) {
+	t.setPaletteColor(index, t.palette.base[index])
+}
+
+// resetPalette returns every entry to its base color.
+func (t *terminal) resetPalette() {
+	for i := range t.palette.current {
+		t.resetPaletteColor(i)
+	}
+}
+
+// oscTerminator returns the terminator of the OSC being handled, so that a
+// reply can use the same one, as xterm does.
+func (t *terminal) oscTerminator() string {
+	if t.seq.final == 7 {
+		return "\a"
+	}
+	return "\x1b\\"
+}
+
+// replyColor answers a color query with the color in xterm's rgb: format.
+// prefix is the start of the reply, such as "4;1" or "11".
+func (t *terminal) replyColor(prefix string, c RGB) {
+	t.replyf("\x1b]%s;rgb:%04x/%04x/%04x%s", prefix, uint16(c.R)*0x101, uint16(c.G)*0x101, uint16(c.B)*0x101, t.oscTerminator())
+}
+
+// oscSetPaletteColor handles OSC 4 ; c ; spec ..., which sets or, if spec is
+// "?", queries indexed colors.
+func (t *terminal) oscSetPaletteColor(arg string) bool {
+	parts := strings.Split(arg, ";")
+	if len(parts)%2 != 0 {
+		return false
+	}
+	ok := true
+	for i := 0; i < len(parts); i += 2 {
+		index, err := strconv.Atoi(parts[i])
+		if err != nil || index < 0 || index > 255 {
+			ok = false
+			continue
+		}
+		if parts[i+1] == "?" {
+			t.replyColor(fmt.Sprintf("4;%d", index), t.palette.current[index])
+			continue
+		}
+		c, valid := parseColorSpec(parts[i+1])
+		if !valid {
+			debugPrintf(debugTodo, "TODO: Unhandled color spec %q\n", parts[i+1])
+			ok = false
+			continue
+		}
+		t.setPaletteColor(index, c)
+	}
+	return ok
+}
+
+// oscResetPaletteColor handles OSC 104 ; c ..., which resets the listed
+// indexed colors, or all of them if there are none.
+func (t *terminal) oscResetPaletteColor(arg string) bool {
+	if arg == "" {
+		for i := 0; i < 256; i++ {
+			t.resetPaletteColor(i)
+		}
+		return true
+	}
+	ok := true
+	for _, p := range strings.Split(arg, ";") {
+		index, err := strconv.Atoi(p)
+		if err != nil || index < 0 || index > 255 {
+			ok = false
+			continue
+		}
+		t.resetPaletteColor(index)
+	}
+	return ok
+}
+
+// oscDynamicColor returns the handler for OSC 10, 11 or 12, which set or
+// query the foreground, background and cursor colors. As in xterm, further
+// specs set the following colors in turn.
+func oscDynamicColor(cmd int) oscHandler {
+	return func(t *terminal, arg string) bool {
+		ok := true
+		for i, spec := range strings.Split(arg, ";") {
+			index := PaletteForeground + cmd - 10 + i
+			if index >= paletteSize {
+				break
+			}
+			if spec == "?" {
+				t.replyColor(strconv.Itoa(cmd+i), t.palette.current[index])
+				continue
+			}
+			c, valid := parseColorSpec(spec)
+			if !valid {
+				debugPrintf(debugTodo, "TODO: Unhandled color spec %q\n", spec)
+				ok = false
+				continue
+			}
+			t.setPaletteColor(index, c)
+		}
+		return ok
+	}
+}
+
+// oscResetDynamicColor returns the handler for OSC 110, 111 or 112, which
+// reset the foreground, background and cursor colors.
+func oscResetDynamicColor(index int) oscHandler {
+	return func(t *terminal, arg string) bool {
+		t.resetPaletteColor(index)
+		return true
+	}
+}
+
+// parseColorSpec parses the color formats xterm accepts from escapes:
+// rgb:r/g/b with 1-4 hex digits per channel, and #rgb with 1-4 hex digits
+// per channel.
+func parseColorSpec(spec string) (RGB, bool) {
+	switch {
+	case strings.HasPrefix(spec, "rgb:"):
+		channels := strings.Split(spec[len("rgb:"):], "/")
+		if len(channels) != 3 {
+			return RGB{}, false
+		}
+		var out [3]uint8
+		for i, ch := range channels {
+			if len(ch) < 1 || len(ch) > 4 {
+				return RGB{}, false
+			}
+			v, err := strconv.ParseUint(ch, 16, 16)
+			if err != nil {
+				return RGB{}, false
+			}
+			// Scale from 4*len(ch) bits to 8 bits.
+			max := uint64(1)<<(4*len(ch)) - 1
+			out[i] = uint8((v*255 + max/2) / max)
+		}
+		return RGB{out[0], out[1], out[2]}, true
+
+	case strings.HasPrefix(spec, "#"):
+		hex := spec[1:]
+		if len(hex) == 0 || len(hex)%3 != 0 || len(hex) > 12 {
+			return RGB{}, false
+		}
+		n := len(hex) / 3
+		var out [3]uint8
+		for i := range out {
+			v, err := strconv.ParseUint(hex[i*n:(i+1)*n], 16, 16)
+			if err != nil {
+				return RGB{}, false
+			}
+			// The digits are the high bits of the channel.
+			bits := 4 * n
+			if bits >= 8 {
+				out[i] = uint8(v >> (bits - 8))
+			} else {
+				out[i] = uint8(v << (8 - bits))
+			}
+		}
+		return RGB{out[0], out[1], out[2]}, true
+	}
+	return RGB{}, false
+}
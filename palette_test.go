@@ -0,0 +1,161 @@
+package termemu
+
+import "testing"
+
+// paletteFrontend records palette changes.
+type paletteFrontend struct {
+	EmptyFrontend
+	colors map[int]RGB
+}
+
+func (f *paletteFrontend) PaletteChanged(index int, c RGB) {
+	f.colors[index] = c
+}
+
+func newPaletteFrontend(t1 *terminal) *paletteFrontend {
+	f := &paletteFrontend{colors: make(map[int]RGB)}
+	t1.SetFrontend(f)
+	return f
+}
+
+func TestPalette_Defaults(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	tests := []struct {
+		index int
+		want  RGB
+	}{
+		{1, RGB{0xcd, 0, 0}},
+		{12, RGB{0x5c, 0x5c, 0xff}},
+		{16, RGB{0, 0, 0}},
+		{196, RGB{0xff, 0, 0}},
+		{231, RGB{0xff, 0xff, 0xff}},
+		{232, RGB{8, 8, 8}},
+		{255, RGB{0xee, 0xee, 0xee}},
+		{PaletteForeground, RGB{0xe5, 0xe5, 0xe5}},
+		{PaletteBackground, RGB{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		if got := t1.PaletteColor(tt.index); got != tt.want {
+			t.Errorf("PaletteColor(%d) = %v, want %v", tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestOSC4_SetQueryReset(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	pf := newPaletteFrontend(t1)
+
+	t1.mustHandleCommand(t, "]4;1;rgb:12/34/56;2;#abcdef\a")
+	if got := t1.PaletteColor(1); got != (RGB{0x12, 0x34, 0x56}) {
+		t.Errorf("color 1 = %v", got)
+	}
+	if got := t1.PaletteColor(2); got != (RGB{0xab, 0xcd, 0xef}) {
+		t.Errorf("color 2 = %v", got)
+	}
+	if got := pf.colors[1]; got != (RGB{0x12, 0x34, 0x56}) {
+		t.Errorf("frontend color 1 = %v", got)
+	}
+
+	// Replies end the way the query did.
+	t1.mustHandleCommand(t, "]4;1;?\a")
+	if got, want := readReply(t, r), "\x1b]4;1;rgb:1212/3434/5656\a"; got != want {
+		t.Errorf("query reply = %q, want %q", got, want)
+	}
+	t1.mustHandleCommand(t, "]4;2;?\x1b\\")
+	if got, want := readReply(t, r), "\x1b]4;2;rgb:abab/cdcd/efef\x1b\\"; got != want {
+		t.Errorf("query reply = %q, want %q", got, want)
+	}
+
+	t1.mustHandleCommand(t, "]104;1\a")
+	if got := t1.PaletteColor(1); got != xtermColors[1] {
+		t.Errorf("color 1 after OSC 104;1 = %v", got)
+	}
+	if got := t1.PaletteColor(2); got != (RGB{0xab, 0xcd, 0xef}) {
+		t.Errorf("color 2 changed by OSC 104;1: %v", got)
+	}
+	t1.mustHandleCommand(t, "]104\a")
+	if got := t1.PaletteColor(2); got != xtermColors[2] {
+		t.Errorf("color 2 after OSC 104 = %v", got)
+	}
+	if got := pf.colors[2]; got != xtermColors[2] {
+		t.Errorf("frontend not told about reset: %v", got)
+	}
+}
+
+func TestOSC10_DynamicColors(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	// Extra specs move on to the next color.
+	t1.mustHandleCommand(t, "]10;#fff;#000080;rgb:f/0/0\a")
+	if got := t1.PaletteColor(PaletteForeground); got != (RGB{0xf0, 0xf0, 0xf0}) {
+		t.Errorf("foreground = %v", got)
+	}
+	if got := t1.PaletteColor(PaletteBackground); got != (RGB{0, 0, 0x80}) {
+		t.Errorf("background = %v", got)
+	}
+	if got := t1.PaletteColor(PaletteCursor); got != (RGB{0xff, 0, 0}) {
+		t.Errorf("cursor = %v", got)
+	}
+
+	t1.mustHandleCommand(t, "]11;?\a")
+	if got, want := readReply(t, r), "\x1b]11;rgb:0000/0000/8080\a"; got != want {
+		t.Errorf("OSC 11 reply = %q, want %q", got, want)
+	}
+
+	t1.mustHandleCommand(t, "]111\a")
+	if got := t1.PaletteColor(PaletteBackground); got != (RGB{}) {
+		t.Errorf("background after OSC 111 = %v", got)
+	}
+	if got := t1.PaletteColor(PaletteForeground); got != (RGB{0xf0, 0xf0, 0xf0}) {
+		t.Errorf("foreground changed by OSC 111: %v", got)
+	}
+}
+
+func TestPalette_APIAndRIS(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	pf := newPaletteFrontend(t1)
+
+	t1.SetPaletteColor(PaletteBackground, RGB{0x10, 0x20, 0x30})
+	if got := pf.colors[PaletteBackground]; got != (RGB{0x10, 0x20, 0x30}) {
+		t.Errorf("frontend background = %v", got)
+	}
+
+	// Escapes change the color, but resets return to the one set by the API.
+	t1.mustHandleCommand(t, "]11;#ffffff\a")
+	t1.mustHandleCommand(t, "]4;5;#123456\a")
+	t1.mustHandleCommand(t, "c")
+	if got := t1.PaletteColor(PaletteBackground); got != (RGB{0x10, 0x20, 0x30}) {
+		t.Errorf("background after RIS = %v", got)
+	}
+	if got := t1.PaletteColor(5); got != xtermColors[5] {
+		t.Errorf("color 5 after RIS = %v", got)
+	}
+}
+
+func TestParseColorSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want RGB
+		ok   bool
+	}{
+		{"rgb:ff/80/00", RGB{0xff, 0x80, 0x00}, true},
+		{"rgb:f/8/0", RGB{0xff, 0x88, 0x00}, true},
+		{"rgb:ffff/8000/0000", RGB{0xff, 0x80, 0x00}, true},
+		{"rgb:fff/000/800", RGB{0xff, 0x00, 0x80}, true},
+		{"#f80", RGB{0xf0, 0x80, 0x00}, true},
+		{"#ff8000", RGB{0xff, 0x80, 0x00}, true},
+		{"#fff000888", RGB{0xff, 0x00, 0x88}, true},
+		{"#ffff00008888", RGB{0xff, 0x00, 0x88}, true},
+		{"rgb:ff/80", RGB{}, false},
+		{"rgb:fffff/0/0", RGB{}, false},
+		{"#ff80", RGB{}, false},
+		{"#gg0000", RGB{}, false},
+		{"red", RGB{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseColorSpec(tt.spec)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseColorSpec(%q) = %v, %v; want %v, %v", tt.spec, got, ok, tt.want, tt.ok)
+		}
+	}
+}
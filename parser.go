@@ -0,0 +1,300 @@
+package termemu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The parser follows the DEC VT500 state machine described at
+// https://vt100.net/emu/dec_ansi_parser. It is pull based: ptyReadOne hands
+// over to handleCommand after an ESC, and the functions here read the rest of
+// the sequence byte by byte. C0 controls inside a control sequence are
+// executed as they arrive, CAN and SUB cancel the sequence, and ESC cancels it
+// and starts a new one.
+
+const (
+	// maxParamValue clamps parameters so that long digit runs cannot overflow.
+	maxParamValue = 65535
+	// maxStringLength is the longest OSC or DCS payload that is dispatched.
+	// Longer strings are read to their end and dropped.
+	maxStringLength = 1 << 20
+)
+
+const (
+	asciiCAN = 0x18
+	asciiSUB = 0x1a
+	asciiESC = 0x1b
+	asciiDEL = 0x7f
+	c1ST     = 0x9c
+)
+
+// sequence is a parsed escape sequence, control sequence (CSI), device
+// control string (DCS) or operating system command (OSC). The terminal reuses
+// one sequence for everything it parses, so handlers must copy anything they
+// keep.
+type sequence struct {
+	// prefix is the private marker (one of "<=>?") or 0.
+	prefix        byte
+	intermediates []byte
+	params        []int
+	// subParams marks parameters that follow a ':' and so belong to the
+	// parameter before them, as in "4:3" or "38:2::r:g:b".
+	subParams []bool
+	final     byte
+	// data is the payload of a DCS or OSC.
+	data []byte
+}
+
+func (s *sequence) reset() {
+	s.prefix = 0
+	s.intermediates = s.intermediates[:0]
+	s.params = s.params[:0]
+	s.subParams = s.subParams[:0]
+	s.final = 0
+	s.data = s.data[:0]
+}
+
+// param returns parameter i, or def if it was not given.
+func (s *sequence) param(i, def int) int {
+	if i < len(s.params) {
+		return s.params[i]
+	}
+	return def
+}
+
+// key returns the dispatch key of the sequence. Sequences with more than one
+// intermediate byte get a key that matches no handler.
+func (s *sequence) key() csiKey {
+	k := csiKey{prefix: s.prefix, final: s.final}
+	switch len(s.intermediates) {
+	case 0:
+	case 1:
+		k.intermediate = s.intermediates[0]
+	default:
+		k.intermediate = 0xff
+	}
+	return k
+}
+
+// String formats the sequence for debug output.
+func (s *sequence) String() string {
+	var sb strings.Builder
+	if s.prefix != 0 {
+		sb.WriteByte(s.prefix)
+	}
+	for i, p := range s.params {
+		if i > 0 {
+			if s.subParams[i] {
+				sb.WriteByte(':')
+			} else {
+				sb.WriteByte(';')
+			}
+		}
+		fmt.Fprint(&sb, p)
+	}
+	sb.Write(s.intermediates)
+	sb.WriteByte(s.final)
+	if len(s.data) > 0 {
+		fmt.Fprintf(&sb, " %q", s.data)
+	}
+	return sb.String()
+}
+
+// csiKey identifies a control sequence or device control string by its
+// private marker, intermediate byte and final byte.
+type csiKey struct {
+	prefix, intermediate, final byte
+}
+
+// csiHandler handles a CSI or DCS sequence and reports whether it understood
+// it.
+type csiHandler func(t *terminal, seq *sequence) bool
+
+// oscHandler handles an OSC command. arg is everything after the first ';'.
+type oscHandler func(t *terminal, arg string) bool
+
+// parseResult says how reading a sequence ended.
+type parseResult int
+
+const (
+	// parseDispatch means the sequence is complete and should be dispatched.
+	parseDispatch parseResult = iota
+	// parseIgnore means the sequence was malformed and has been read to its
+	// end.
+	parseIgnore
+	// parseCancel means the sequence was cancelled by CAN or SUB.
+	parseCancel
+	// parseEscape means an ESC ended the sequence and starts a new one.
+	parseEscape
+	// parseFailed means the input ended or failed.
+	parseFailed
+)
+
+type controlState int
+
+const (
+	stateEntry controlState = iota
+	stateParam
+	stateIntermediate
+	stateIgnore
+)
+
+// readControl reads the parameters, intermediate bytes and final byte of a
+// CSI or DCS into s, starting just after the introducer. C0 controls are
+// executed if execute is set and ignored otherwise.
+func (t *terminal) readControl(r escapeReader, s *sequence, execute bool) parseResult {
+	s.reset()
+	state := stateEntry
+	param := 0
+	isSub := false
+	pending := false
+	endParam := func() {
+		s.params = append(s.params, param)
+		s.subParams = append(s.subParams, isSub)
+		param = 0
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				debugPrintln(debugErrors, "ERR ReadByteControl:", err)
+				return parseFailed
+			}
+			if state == stateIntermediate {
+				// Cut off after the intermediates; treat the last one as the final byte.
+				n := len(s.intermediates) - 1
+				s.final = s.intermediates[n]
+				s.intermediates = s.intermediates[:n]
+				return parseDispatch
+			}
+			return parseFailed
+		}
+
+		switch {
+		case b == asciiCAN || b == asciiSUB:
+			return parseCancel
+
+		case b == asciiESC:
+			return parseEscape
+
+		case b < 0x20:
+			if execute {
+				t.execute(b)
+			}
+
+		case b == asciiDEL:
+			// ignored
+
+		case state == stateIgnore:
+			if b >= 0x40 && b <= 0x7e {
+				return parseIgnore
+			}
+
+		case b >= '0' && b <= '9':
+			if state == stateIntermediate {
+				state = stateIgnore
+				break
+			}
+			param = min(param*10+int(b-'0'), maxParamValue)
+			pending = true
+			state = stateParam
+
+		case b == ';' || b == ':':
+			if state == stateIntermediate {
+				state = stateIgnore
+				break
+			}
+			endParam()
+			isSub = b == ':'
+			pending = true
+			state = stateParam
+
+		case b >= 0x3c && b <= 0x3f: // private marker
+			if state != stateEntry {
+				state = stateIgnore
+				break
+			}
+			s.prefix = b
+			state = stateParam
+
+		case b >= 0x20 && b <= 0x2f:
+			if pending {
+				endParam()
+				pending = false
+			}
+			s.intermediates = append(s.intermediates, b)
+			state = stateIntermediate
+
+		case b >= 0x40 && b <= 0x7e:
+			if pending {
+				endParam()
+			}
+			s.final = b
+			return parseDispatch
+
+		default: // 8-bit bytes have no place in a control sequence
+			state = stateIgnore
+		}
+	}
+}
+
+// readString reads the payload of a control string up to its terminator,
+// appending it to s.data if keep is set. osc selects the OSC rules, where
+// BEL also ends the string (as in xterm) and other C0 controls are ignored;
+// otherwise C0 controls are part of the payload. An OSC ended by BEL sets
+// s.final to BEL so that replies can end the same way. A string ended by ESC
+// returns parseEscape with the payload intact, since the ESC is normally the
+// start of an ST (ESC \). tooLong reports that the payload went over
+// maxStringLength and was cut short.
+func (t *terminal) readString(r escapeReader, s *sequence, keep, osc bool) (res parseResult, tooLong bool) {
+	// continuation counts the UTF-8 continuation bytes still expected, so that
+	// a 0x9c inside a character is not mistaken for ST.
+	continuation := 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				debugPrintln(debugErrors, "ERR ReadByteString:", err)
+			}
+			return parseFailed, tooLong
+		}
+
+		switch {
+		case b == asciiCAN || b == asciiSUB:
+			return parseCancel, tooLong
+		case b == asciiESC:
+			return parseEscape, tooLong
+		case b == 7 && osc:
+			s.final = b
+			return parseDispatch, tooLong
+		case b < 0x20 && osc:
+			continue
+		case b == c1ST && continuation == 0:
+			return parseDispatch, tooLong
+		}
+
+		switch {
+		case b >= 0xf0:
+			continuation = 3
+		case b >= 0xe0:
+			continuation = 2
+		case b >= 0xc0:
+			continuation = 1
+		case b >= 0x80 && continuation > 0:
+			continuation--
+		default:
+			continuation = 0
+		}
+
+		if !keep || tooLong {
+			continue
+		}
+		if len(s.data) >= maxStringLength {
+			tooLong = true
+			continue
+		}
+		s.data = append(s.data, b)
+	}
+}
@@ -0,0 +1,146 @@
+package termemu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func feed(t *testing.T, t1 *terminal, data string) {
+	t.Helper()
+	if err := t1.testFeedTerminalInputFromBackend([]byte(data), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParser_ManyParams(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	// 20 parameters; the last ones used to be dropped.
+	t1.mustHandleCommand(t, "[1;2;3;5;7;8;9;51;52;53;0;0;0;0;0;0;0;0;3;38;2;10;20;30m")
+
+	s := t1.screen().Style()
+	if !s.TestMode(ModeItalic) || s.TestMode(ModeBold) {
+		t.Errorf("modes = %v, want only italic", s.Modes())
+	}
+	if c, rgb, _ := s.GetColor(ComponentFG); !rgb || c != 0x0a141e {
+		t.Errorf("fg = %06x (rgb %v), want 0a141e", c, rgb)
+	}
+}
+
+func TestParser_ParamOverflow(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	t1.mustHandleCommand(t, "[99999999999999999999999999C")
+	if x := t1.screen().CursorPos().X; x != t1.screen().Size().X-1 {
+		t.Errorf("cursor x = %d, want the last column", x)
+	}
+}
+
+func TestParser_ControlsInsideSequences(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"C0 is executed inside CSI", "abcdef\x1b[2\bDX", []string{"abcXef"}},
+		{"CR inside CSI", "abc\x1b[\r2CX", []string{"abX"}},
+		{"ESC restarts the sequence", "abc\x1b[5\x1b[2DX", []string{"aXc"}},
+		{"CAN cancels the sequence", "abc\x1b[2\x18DX", []string{"abcDX"}},
+		{"SUB cancels the sequence", "abc\x1b[2\x1aDX", []string{"abcDX"}},
+		{"DEL is ignored", "abc\x1b[2\x7fDX", []string{"aXc"}},
+		{"private marker after params is ignored", "abc\x1b[1?2DX", []string{"abcX"}},
+		{"param after intermediate is ignored", "abc\x1b[ 2DX", []string{"abcX"}},
+		{"several intermediates are not dispatched", "abc\x1b[1!!pX", []string{"abcX"}},
+		{"ESC intermediates are consumed", "abc\x1b#8X", []string{"abcX"}},
+		{"C0 inside ESC sequence", "abc\x1b\b7X\x1b8Y", []string{"abY"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+			feed(t, t1, tt.input)
+			if got := screenText(t1); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("screen = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParser_ControlStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		title string
+		want  []string
+	}{
+		{"OSC ended by BEL", "\x1b]2;one\aok", "one", []string{"ok"}},
+		{"OSC ended by ESC \\", "\x1b]2;two\x1b\\ok", "two", []string{"ok"}},
+		{"OSC ended by C1 ST", "\x1b]2;three\x9cok", "three", []string{"ok"}},
+		{"OSC with 0x9c inside UTF-8", "\x1b]2;Ŝ\x9cok", "Ŝ", []string{"ok"}},
+		{"C0 ignored inside OSC", "\x1b]2;a\rb\aok", "ab", []string{"ok"}},
+		{"OSC cancelled by CAN", "\x1b]2;no\x18ok", "", []string{"ok"}},
+		{"OSC ended by another sequence", "\x1b]2;four\x1b[2Cok", "four", []string{"  ok"}},
+		{"unknown OSC is consumed", "\x1b]999;x\aok", "", []string{"ok"}},
+		{"non-numeric OSC is consumed", "\x1b]L;x\aok", "", []string{"ok"}},
+		{"DCS is consumed", "\x1bP1$r0m\x1b\\ok", "", []string{"ok"}},
+		{"DCS with C0 in payload", "\x1bPq#0;2;0;0;0\n#0!10~\x1b\\ok", "", []string{"ok"}},
+		{"APC is consumed", "\x1b_Gi=1;AAAA\x1b\\ok", "", []string{"ok"}},
+		{"PM is consumed", "\x1b^private\x1b\\ok", "", []string{"ok"}},
+		{"SOS is consumed", "\x1bXstring\x1b\\ok", "", []string{"ok"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+			feed(t, t1, tt.input)
+			if got := t1.GetViewString(VSWindowTitle); got != tt.title {
+				t.Errorf("title = %q, want %q", got, tt.title)
+			}
+			if got := screenText(t1); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("screen = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParser_StringTooLong(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	long := make([]byte, maxStringLength+10)
+	for i := range long {
+		long[i] = 'x'
+	}
+	feed(t, t1, "\x1b]2;"+string(long)+"\aok")
+	if got := t1.GetViewString(VSWindowTitle); got != "" {
+		t.Errorf("title has %d bytes, want it ignored", len(got))
+	}
+	if got := screenText(t1); fmt.Sprint(got) != "[ok]" {
+		t.Errorf("screen = %q, want [ok]", got)
+	}
+}
+
+func TestSequence_Key(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want csiKey
+	}{
+		{"A", csiKey{final: 'A'}},
+		{"?25h", csiKey{prefix: '?', final: 'h'}},
+		{"2 q", csiKey{intermediate: ' ', final: 'q'}},
+		{"?2026$p", csiKey{prefix: '?', intermediate: '$', final: 'p'}},
+		{"1;2!\"p", csiKey{intermediate: 0xff, final: 'p'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+			r := strings.NewReader(tt.cmd)
+			if res := t1.readControl(r, &t1.seq, true); res != parseDispatch {
+				t.Fatalf("readControl = %v", res)
+			}
+			if got := t1.seq.key(); got != tt.want {
+				t.Errorf("key = %+v, want %+v", got, tt.want)
+			}
+			if got := t1.seq.String(); got != tt.cmd {
+				t.Errorf("String() = %q, want %q", got, tt.cmd)
+			}
+		})
+	}
+}
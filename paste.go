@@ -0,0 +1,56 @@
+package termemu
+
+import "strings"
+
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// Paste sends text as if it was pasted. Line endings are sent as CR, like a
+// typed Enter. If the program enabled bracketed paste (mode 2004) the text is
+// wrapped in paste markers, and markers inside the text are removed so it
+// cannot end the paste early.
+func (t *terminal) Paste(text string) (int, error) {
+	t.Lock()
+	bracketed := t.viewFlags[VFBracketedPaste]
+	t.Unlock()
+
+	text = sanitizePaste(text)
+	if bracketed {
+		text = pasteStart + text + pasteEnd
+	}
+	if text == "" {
+		return 0, nil
+	}
+	return t.Write([]byte(text))
+}
+
+// sanitizePaste normalizes line endings to CR and removes paste markers.
+// Removing a marker can join the pieces of another one, so it repeats until
+// none are left.
+func sanitizePaste(text string) string {
+	for strings.Contains(text, pasteStart) || strings.Contains(text, pasteEnd) {
+		text = strings.ReplaceAll(text, pasteStart, "")
+		text = strings.ReplaceAll(text, pasteEnd, "")
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\r")
+	return strings.ReplaceAll(text, "\n", "\r")
+}
+
+// SendFocus reports that the terminal gained or lost focus, if the program
+// enabled focus events (mode 1004).
+func (t *terminal) SendFocus(focused bool) error {
+	t.Lock()
+	report := t.viewFlags[VFReportFocus]
+	t.Unlock()
+	if !report {
+		return nil
+	}
+	seq := "\x1b[O"
+	if focused {
+		seq = "\x1b[I"
+	}
+	_, err := t.Write([]byte(seq))
+	return err
+}
@@ -0,0 +1,80 @@
+package termemu
+
+import "testing"
+
+func TestPaste(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	if _, err := t1.Paste("a\r\nb\nc"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readReply(t, r), "a\rb\rc"; got != want {
+		t.Errorf("unbracketed paste = %q, want %q", got, want)
+	}
+
+	t1.mustHandleCommand(t, "[?2004h")
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"ls\n", "\x1b[200~ls\r\x1b[201~"},
+		{"x\x1b[201~; rm -rf ~\n", "\x1b[200~x; rm -rf ~\r\x1b[201~"},
+		{"\x1b[20\x1b[201~1~y", "\x1b[200~y\x1b[201~"},
+		{"\x1b[200~z", "\x1b[200~z\x1b[201~"},
+		{"", "\x1b[200~\x1b[201~"},
+	}
+	for _, tt := range tests {
+		if _, err := t1.Paste(tt.text); err != nil {
+			t.Fatal(err)
+		}
+		if got := readReply(t, r); got != tt.want {
+			t.Errorf("Paste(%q) sent %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSendFocus(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	// Nothing is sent until the program asks for focus events.
+	if err := t1.SendFocus(true); err != nil {
+		t.Fatal(err)
+	}
+	t1.mustHandleCommand(t, "[?1004h")
+	if err := t1.SendFocus(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readReply(t, r); got != "\x1b[O" {
+		t.Errorf("focus out = %q, want ESC [O", got)
+	}
+	if err := t1.SendFocus(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readReply(t, r); got != "\x1b[I" {
+		t.Errorf("focus in = %q, want ESC [I", got)
+	}
+}
+
+func TestSendMouse(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	if err := t1.SendMouse(MBtn1, true, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	t1.mustHandleCommand(t, "[?1000h")
+	t1.mustHandleCommand(t, "[?1006h")
+	if err := t1.SendMouse(MBtn1, true, MShift, 4, 9); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readReply(t, r), "\x1b[<4;5;10M"; got != want {
+		t.Errorf("mouse report = %q, want %q", got, want)
+	}
+
+	var term Terminal = t1
+	if err := term.SendMouse(MBtn1, false, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readReply(t, r), "\x1b[<0;1;1m"; got != want {
+		t.Errorf("mouse release = %q, want %q", got, want)
+	}
+}
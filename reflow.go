@@ -0,0 +1,310 @@
+package termemu
+
+import "strings"
+
+// resizeMainScreen resizes the main screen to w x h. Soft-wrapped rows on the
+// screen and in the scrollback are joined into logical lines and wrapped again
+// at the new width, and the cursor stays on the same character. Rows that no
+// longer fit above the cursor move into the scrollback.
+func (t *terminal) resizeMainScreen(w, h int) {
+	s := t.mainScreen
+	size := s.Size()
+	if w <= 0 || h <= 0 || (size.X == w && size.Y == h) {
+		s.setSize(w, h)
+		return
+	}
+
+	var rows []Line
+	if t.scrollback != nil {
+		rows = t.scrollback.Lines(0, t.scrollback.Len())
+	}
+	sbLen := len(rows)
+
+	// Rows below both the cursor and the last non-blank row are not content.
+	cursor := s.CursorPos()
+	last := cursor.Y
+	for y := size.Y - 1; y > last; y-- {
+		if len(trimBlankRight(s.StyledLine(0, size.X, y).Spans)) > 0 {
+			last = y
+			break
+		}
+	}
+	for y := 0; y <= last; y++ {
+		rows = append(rows, s.StyledLine(0, size.X, y))
+	}
+
+	cur := Pos{X: cursor.X, Y: sbLen + cursor.Y}
+	top := Pos{Y: sbLen}
+	out := reflowRows(rows, w, t.textReadMode, &cur, &top)
+
+	first := top.Y
+	if len(out)-first > h {
+		first = len(out) - h
+	}
+	if cur.Y < first {
+		first = cur.Y
+	}
+
+	if t.scrollback != nil {
+		t.scrollback.clear()
+		for _, l := range out[:first] {
+			t.scrollback.push(l)
+		}
+	}
+
+	s.setSize(w, h)
+	blank := Line{Spans: []Span{{Style: NewStyle(), Rune: ' ', Width: w}}, Width: w}
+	for y := 0; y < h; y++ {
+		if first+y < len(out) {
+			s.setLine(y, out[first+y])
+		} else {
+			s.setLine(y, blank)
+		}
+	}
+	s.setCursorPos(cur.X, cur.Y-first)
+}
+
+// reflowRows re-wraps rows to width w. Runs of rows joined by soft wraps are
+// treated as one logical line, trailing blank cells are dropped, and the text
+// is wrapped again without splitting wide or grapheme clusters; a cluster wider
+// than w gets a row of its own, clipped to it. Each tracked position (X is the
+// column, Y the index into rows) and each row mark is moved to the cell that
+// holds the same character in the result.
+func reflowRows(rows []Line, w int, mode TextReadMode, track ...*Pos) []Line {
+	var out []Line
+	offsets := make([]int, len(track))
+	for start := 0; start < len(rows); {
+		end := start
+		for end < len(rows)-1 && rows[end].Wrapped {
+			end++
+		}
+
+		// Join the rows, remembering the logical offset of each tracked position
+		// and mark.
+		var spans []Span
+		var marks []Mark
+		width := 0
+		for i := range offsets {
+			offsets[i] = -1
+		}
+		for y := start; y <= end; y++ {
+			for i, p := range track {
+				if p.Y == y {
+					offsets[i] = width + p.X
+				}
+			}
+			for _, m := range rows[y].Marks {
+				m.X += width
+				marks = append(marks, m)
+			}
+			rowSpans, rowWidth := unclipRow(rows[y], mode)
+			if y < end {
+				rowSpans, rowWidth = trimWrapPadding(rowSpans, rowWidth, rows[y+1].Spans, mode)
+			}
+			spans = append(spans, rowSpans...)
+			width += rowWidth
+		}
+		spans = trimBlankRight(spans)
+
+		first := len(out)
+		out = append(out, Line{})
+		rowStarts := []int{0}
+		off := 0
+		for len(spans) > 0 {
+			sp := spans[0]
+			spans = spans[1:]
+			if sp.Width <= 0 {
+				continue
+			}
+			row := &out[len(out)-1]
+			room := w - row.Width
+			if room == 0 {
+				row.Wrapped = true
+				out = append(out, Line{})
+				rowStarts = append(rowStarts, off)
+				spans = append([]Span{sp}, spans...)
+				continue
+			}
+			if sp.Width <= room {
+				appendSpan(row, sp)
+				off += sp.Width
+				continue
+			}
+			left, right, wide := splitSpan(sp, room, mode)
+			appendSpan(row, left)
+			off += left.Width
+			if wide.Width > 0 && row.Width == 0 {
+				// The cluster is wider than a row. Keep it, clipped to the row as
+				// the screens do when one is printed, and pull the offsets after
+				// it back by the cells it lost.
+				lost := wide.Width - w
+				for i, o := range offsets {
+					if o > off {
+						offsets[i] = max(o-lost, off)
+					}
+				}
+				for i, m := range marks {
+					if m.X > off {
+						marks[i].X = max(m.X-lost, off)
+					}
+				}
+				wide.Width = w
+				appendSpan(row, wide)
+				off += w
+				wide = Span{}
+			} else if wide.Width > 0 {
+				// Leave the rest of this row empty and move the cluster down.
+				row.Wrapped = true
+				out = append(out, Line{})
+				rowStarts = append(rowStarts, off)
+			}
+			spans = append([]Span{wide, right}, spans...)
+		}
+
+		// rowOf returns the result row and column of a logical offset.
+		rowOf := func(off int) (int, int) {
+			r := len(rowStarts) - 1
+			for r > 0 && rowStarts[r] > off {
+				r--
+			}
+			return r, off - rowStarts[r]
+		}
+		for _, m := range marks {
+			r, col := rowOf(m.X)
+			m.X = min(col, w-1)
+			out[first+r].Marks = append(out[first+r].Marks, m)
+		}
+
+		for i, p := range track {
+			if offsets[i] < 0 {
+				continue
+			}
+			r, col := rowOf(offsets[i])
+			// Positions past the end of the content get blank continuation rows.
+			for col >= w {
+				out[first+r].Wrapped = true
+				if first+r+1 == len(out) {
+					out = append(out, Line{})
+				}
+				r++
+				col -= w
+			}
+			p.X = col
+			p.Y = first + r
+		}
+
+		for y := first; y < len(out); y++ {
+			if out[y].Width < w {
+				appendSpan(&out[y], Span{Style: NewStyle(), Rune: ' ', Width: w - out[y].Width})
+			}
+		}
+		start = end + 1
+	}
+	return out
+}
+
+// unclipRow returns the spans of l with any cluster that was clipped to fit a
+// row narrower than it given its full width back, and the row's width with
+// them.
+func unclipRow(l Line, mode TextReadMode) ([]Span, int) {
+	spans := l.Spans
+	width := l.Width
+	for i, sp := range l.Spans {
+		w := firstClusterWidth(sp, mode)
+		if w <= sp.Width {
+			continue
+		}
+		if sp.Text != "" {
+			if _, consumed, _, _, _ := stepTextCluster([]byte(sp.Text), -1, mode); consumed != len(sp.Text) {
+				continue
+			}
+		}
+		if &spans[0] == &l.Spans[0] {
+			spans = append([]Span(nil), l.Spans...)
+		}
+		if sp.Text == "" {
+			// A Rune span repeats its rune Width times, so make it text.
+			spans[i].Text = string(sp.Rune)
+			spans[i].Rune = 0
+		}
+		spans[i].Width = w
+		width += w - sp.Width
+	}
+	return spans, width
+}
+
+// trimWrapPadding drops the blank cells autowrap leaves at the end of a row
+// when the first cluster of the next row does not fit in them.
+func trimWrapPadding(spans []Span, width int, next []Span, mode TextReadMode) ([]Span, int) {
+	if len(next) == 0 {
+		return spans, width
+	}
+	trimmed := trimBlankRight(spans)
+	pad := 0
+	for _, sp := range spans[len(trimmed):] {
+		pad += sp.Width
+	}
+	if len(trimmed) > 0 {
+		// trimBlankRight may have shortened the last span it kept.
+		pad += spans[len(trimmed)-1].Width - trimmed[len(trimmed)-1].Width
+	}
+	if pad == 0 || pad >= firstClusterWidth(next[0], mode) {
+		return spans, width
+	}
+	return trimmed, width - pad
+}
+
+// firstClusterWidth returns the full width of the first cluster of sp.
+func firstClusterWidth(sp Span, mode TextReadMode) int {
+	if sp.Text == "" {
+		return runeCellWidth(sp.Rune)
+	}
+	_, _, w, _, ok := stepTextCluster([]byte(sp.Text), -1, mode)
+	if !ok {
+		return 1
+	}
+	return w
+}
+
+func appendSpan(l *Line, sp Span) {
+	if sp.Width <= 0 {
+		return
+	}
+	l.Spans = append(l.Spans, sp)
+	l.Width += sp.Width
+}
+
+// trimBlankRight drops trailing spaces that have no visible background.
+func trimBlankRight(spans []Span) []Span {
+	for len(spans) > 0 {
+		sp := spans[len(spans)-1]
+		if !blankStyle(sp.Style) || sp.Link != nil {
+			break
+		}
+		if sp.Text == "" {
+			if sp.Rune != ' ' && sp.Rune != 0 {
+				break
+			}
+			spans = spans[:len(spans)-1]
+			continue
+		}
+		text := strings.TrimRight(sp.Text, " ")
+		if text == "" {
+			spans = spans[:len(spans)-1]
+			continue
+		}
+		if len(text) < len(sp.Text) {
+			sp.Width -= len(sp.Text) - len(text)
+			sp.Text = text
+			spans = append(spans[:len(spans)-1:len(spans)-1], sp)
+		}
+		break
+	}
+	return spans
+}
+
+// blankStyle reports whether a space in this style is indistinguishable from
+// an empty cell.
+func blankStyle(st Style) bool {
+	return st.bg&^modeBitsMask == colDefault && !st.TestMode(ModeReverse)
+}
@@ -0,0 +1,167 @@
+package termemu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// makeTerminalWithScreens returns a mock terminal whose screens come from newFn.
+func makeTerminalWithScreens(newFn func(Frontend) screen) *terminal {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+	t1.mainScreen = newFn(mf)
+	t1.altScreen = newFn(mf)
+	t1.mainScreen.setScrollback(t1.scrollback)
+	return t1
+}
+
+func screenText(t *terminal) []string {
+	_, h := t.Size()
+	out := make([]string, h)
+	for y := range out {
+		out[y] = strings.TrimRight(t.Line(y), " ")
+	}
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+func TestReflow_NarrowThenWiden(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(10, 4)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[?7habcdefghijklmno"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = t1.Resize(5, 4)
+		if got, want := screenText(t1), []string{"abcde", "fghij", "klmno"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("after narrowing: %q, want %q", got, want)
+		}
+		if pos := t1.screen().CursorPos(); pos != (Pos{X: 0, Y: 3}) {
+			t.Fatalf("cursor after narrowing = %v, want {0 3}", pos)
+		}
+
+		_ = t1.Resize(20, 4)
+		if got, want := screenText(t1), []string{"abcdefghijklmno"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("after widening: %q, want %q", got, want)
+		}
+		if pos := t1.screen().CursorPos(); pos != (Pos{X: 15, Y: 0}) {
+			t.Fatalf("cursor after widening = %v, want {15 0}", pos)
+		}
+	})
+}
+
+func TestReflow_HardNewlinesNotJoined(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(10, 4)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[?7h$ ls\nfile1\n$ "), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = t1.Resize(20, 4)
+		if got, want := screenText(t1), []string{"$ ls", "file1", "$"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("screen = %q, want %q", got, want)
+		}
+		if pos := t1.screen().CursorPos(); pos != (Pos{X: 2, Y: 2}) {
+			t.Fatalf("cursor = %v, want {2 2}", pos)
+		}
+	})
+}
+
+func TestReflow_WideCharNotSplit(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(10, 4)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("ab🐹cd"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = t1.Resize(3, 4)
+		if got, want := screenText(t1), []string{"ab", "🐹c", "d"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("screen = %q, want %q", got, want)
+		}
+		if pos := t1.screen().CursorPos(); pos != (Pos{X: 1, Y: 2}) {
+			t.Fatalf("cursor = %v, want {1 2}", pos)
+		}
+	})
+}
+
+func TestReflow_WideCharPaddingNotJoined(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(7, 4)
+		// 文 does not fit in the last column, so autowrap leaves it blank.
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[?7hab中文中文xyz"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = t1.Resize(20, 4)
+		if got, want := screenText(t1), []string{"ab中文中文xyz"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("screen = %q, want %q", got, want)
+		}
+		if pos := t1.screen().CursorPos(); pos != (Pos{X: 13, Y: 0}) {
+			t.Fatalf("cursor = %v, want {13 0}", pos)
+		}
+	})
+}
+
+func TestReflow_WideCharWiderThanScreen(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(7, 4)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[?7hab中文中文xyz"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, w := range []int{4, 3, 1} {
+			_ = t1.Resize(w, 4)
+		}
+		// Clusters wider than a row are kept, clipped to it.
+		if got, want := scrollbackText(t1), []string{"a", "b", "中", "文", "中", "文"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("scrollback at width 1 = %q, want %q", got, want)
+		}
+
+		_ = t1.Resize(20, 4)
+		if n := t1.ScrollbackLen(); n != 0 {
+			t.Fatalf("scrollback after widening = %q, want none", scrollbackText(t1))
+		}
+		if got, want := screenText(t1), []string{"ab中文中文xyz"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("screen after widening = %q, want %q", got, want)
+		}
+		if pos := t1.screen().CursorPos(); pos != (Pos{X: 13, Y: 0}) {
+			t.Fatalf("cursor after widening = %v, want {13 0}", pos)
+		}
+	})
+}
+
+func TestReflow_IncludesScrollback(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(4, 2)
+		if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[?7haaaabbbbcc"), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := scrollbackText(t1), []string{"aaaa"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("scrollback before resize = %q, want %q", got, want)
+		}
+
+		_ = t1.Resize(10, 2)
+		if n := t1.ScrollbackLen(); n != 0 {
+			t.Fatalf("expected scrollback to be pulled into the screen, got %q", scrollbackText(t1))
+		}
+		if got, want := screenText(t1), []string{"aaaabbbbcc"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("screen = %q, want %q", got, want)
+		}
+
+		_ = t1.Resize(3, 2)
+		if got, want := scrollbackText(t1), []string{"aaa", "abb"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("scrollback after narrowing = %q, want %q", got, want)
+		}
+		if got, want := screenText(t1), []string{"bbc", "c"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("screen after narrowing = %q, want %q", got, want)
+		}
+	})
+}
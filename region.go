@@ -31,6 +31,23 @@ func (r Region) Intersect(o Region) Region {
 	return r
 }
 
+// Union returns the smallest region that contains both regions. An empty
+// region adds nothing.
+func (r Region) Union(o Region) Region {
+	if r.Empty() {
+		return o
+	}
+	if o.Empty() {
+		return r
+	}
+	return Region{
+		X:  min(r.X, o.X),
+		Y:  min(r.Y, o.Y),
+		X2: max(r.X2, o.X2),
+		Y2: max(r.Y2, o.Y2),
+	}
+}
+
 // Empty reports whether the region has no area.
 func (r Region) Empty() bool {
 	return r.X >= r.X2 || r.Y >= r.Y2
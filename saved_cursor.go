@@ -0,0 +1,59 @@
+package termemu
+
+// savedCursor is the state saved by DECSC (ESC 7) and restored by DECRC (ESC 8).
+type savedCursor struct {
+	pos        Pos
+	style      Style
+	autoWrap   bool
+	originMode bool
+	charsets   charsetState
+}
+
+// newSavedCursor returns the state DECRC restores when nothing was saved.
+func newSavedCursor() savedCursor {
+	return savedCursor{style: NewStyle()}
+}
+
+func (t *terminal) savedCursor() *savedCursor {
+	if t.onAltScreen {
+		return &t.savedCursorAlt
+	}
+	return &t.savedCursorMain
+}
+
+// saveCursor saves the cursor state of the active screen (DECSC).
+func (t *terminal) saveCursor() {
+	s := t.screen()
+	*t.savedCursor() = savedCursor{
+		pos:        s.CursorPos(),
+		style:      s.Style(),
+		autoWrap:   s.AutoWrap(),
+		originMode: s.OriginMode(),
+		charsets:   *t.charsets(),
+	}
+}
+
+// restoreCursor restores the cursor state of the active screen (DECRC).
+func (t *terminal) restoreCursor() {
+	sc := t.savedCursor()
+	s := t.screen()
+	s.setStyle(sc.style)
+	s.SetAutoWrap(sc.autoWrap)
+	s.SetOriginMode(sc.originMode)
+	*t.charsets() = sc.charsets
+	s.setCursorPos(sc.pos.X, sc.pos.Y)
+}
+
+// setAltScreen switches to the alternate or the main screen, if not already
+// there.
+func (t *terminal) setAltScreen(alt bool) {
+	if t.onAltScreen != alt {
+		t.switchScreen()
+	}
+}
+
+// eraseScreen erases the whole active screen.
+func (t *terminal) eraseScreen() {
+	size := t.screen().Size()
+	t.screen().eraseRegion(Region{X: 0, Y: 0, X2: size.X, Y2: size.Y}, CRClear)
+}
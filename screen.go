@@ -13,29 +13,45 @@ type screen interface {
 	Style() Style
 	AutoWrap() bool
 	SetAutoWrap(value bool)
+	InsertMode() bool
+	SetInsertMode(value bool)
+	OriginMode() bool
+	SetOriginMode(value bool)
+	LeftRightMarginMode() bool
+	SetLeftRightMarginMode(value bool)
 	TopMargin() int
 	BottomMargin() int
+	LeftMargin() int
+	RightMargin() int
 	SetFrontend(f Frontend)
+	setScrollback(sb *scrollback)
+	saveScrollback(n int)
 
 	Line(y int) string
 	StyledLine(x, w, y int) Line
 	StyledLines(r Region) []Line
 	renderLineANSI(y int) string
+	setLine(y int, l Line)
+	addMark(y int, m Mark)
 	setStyle(style Style)
+	Hyperlink() *Hyperlink
+	setHyperlink(link *Hyperlink)
 	setSize(w, h int)
 	eraseRegion(r Region, cr ChangeReason)
 	writeRunes(b []rune)
 	writeTokens(tokens []GraphemeToken)
-	insertRunes(b []rune)
+	insertChars(x int, y int, n int, cr ChangeReason)
 	rawWriteRunes(x int, y int, b []rune, cr ChangeReason)
 	rawWriteRune(x int, y int, r rune, width int, cr ChangeReason)
 	deleteChars(x int, y int, n int, cr ChangeReason)
 	setScrollMarginTopBottom(top, bottom int)
+	setScrollMarginLeftRight(left, right int)
 	scroll(y1 int, y2 int, dy int)
 	setCursorPos(x, y int)
 	moveCursor(dx, dy int, wrap bool, scroll bool)
-	saveCursorPos()
-	restoreCursorPos()
+	reset()
+	softReset()
+	tabStops() tabStops
 	printScreen()
 }
 
@@ -50,15 +66,29 @@ type spanScreen struct {
 	frontend Frontend
 
 	style Style
+	// link is the hyperlink given to written text, or nil.
+	link *Hyperlink
 
 	size Pos
 
-	cursorPos      Pos
-	savedCursorPos Pos
+	cursorPos Pos
 
 	topMargin, bottomMargin int
+	leftMargin, rightMargin int
+
+	// originMode (DECOM) makes cursor addressing relative to the margins.
+	originMode bool
+	// lrMarginMode (DECLRMM) enables the left and right margins.
+	lrMarginMode bool
 
 	autoWrap bool
+	// insertMode (IRM) makes written text shift the rest of the line right.
+	insertMode bool
+
+	tabs tabStops
+
+	// scrollback receives lines scrolled off the top; nil on the alt screen.
+	scrollback *scrollback
 
 	textMode TextReadMode
 
@@ -69,6 +99,11 @@ type spanScreen struct {
 type spanLine struct {
 	spans []Span
 	width int
+
+	// wrapped is set when autowrap carried text from this row onto the next.
+	wrapped bool
+	// marks are the OSC 133 marks set on this row, oldest first.
+	marks []Mark
 }
 
 func newScreen(f Frontend) screen {
@@ -107,6 +142,44 @@ func (s *spanScreen) SetAutoWrap(value bool) {
 	s.autoWrap = value
 }
 
+func (s *spanScreen) InsertMode() bool {
+	return s.insertMode
+}
+
+func (s *spanScreen) SetInsertMode(value bool) {
+	s.insertMode = value
+}
+
+func (s *spanScreen) OriginMode() bool {
+	return s.originMode
+}
+
+func (s *spanScreen) SetOriginMode(value bool) {
+	s.originMode = value
+}
+
+func (s *spanScreen) LeftRightMarginMode() bool {
+	return s.lrMarginMode
+}
+
+// SetLeftRightMarginMode enables or disables the left and right margins.
+// Disabling them resets the margins to the full width.
+func (s *spanScreen) SetLeftRightMarginMode(value bool) {
+	s.lrMarginMode = value
+	if !value {
+		s.leftMargin = 0
+		s.rightMargin = s.size.X - 1
+	}
+}
+
+func (s *spanScreen) LeftMargin() int {
+	return s.leftMargin
+}
+
+func (s *spanScreen) RightMargin() int {
+	return s.rightMargin
+}
+
 func (s *spanScreen) TopMargin() int {
 	return s.topMargin
 }
@@ -119,6 +192,24 @@ func (s *spanScreen) SetFrontend(f Frontend) {
 	s.frontend = f
 }
 
+func (s *spanScreen) setScrollback(sb *scrollback) {
+	s.scrollback = sb
+}
+
+// saveScrollback copies the top n lines into the scrollback before they are
+// scrolled away. Lines only go to history when the scroll region starts at the
+// top of the screen.
+func (s *spanScreen) saveScrollback(n int) {
+	if s.scrollback == nil || s.topMargin != 0 || s.hasLeftRightMargins() || n <= 0 {
+		return
+	}
+	n = min(n, s.bottomMargin+1)
+	s.frontend.ScrollLines(n)
+	for y := 0; y < n; y++ {
+		s.scrollback.push(s.StyledLine(0, s.size.X, y))
+	}
+}
+
 func (s *spanScreen) Line(y int) string {
 	line := strings.Builder{}
 	pos := 0
@@ -166,12 +257,21 @@ func (s *spanScreen) StyledLine(x, w, y int) Line {
 		w = 0
 	}
 
-	// We can construct the line by taking a slice of the spans and clipping edges
-	originalSpans := s.lines[y].spans
+	return Line{
+		Spans:   sliceSpans(s.lines[y].spans, x, w, s.textMode),
+		Width:   w,
+		Wrapped: s.lines[y].wrapped && x+w == s.size.X,
+		Marks:   sliceMarks(s.lines[y].marks, x, w, s.size.X),
+	}
+}
+
+// sliceSpans returns the spans covering cells [x, x+w) of a row, splitting
+// the spans at the edges.
+func sliceSpans(line []Span, x, w int, mode TextReadMode) []Span {
 	var spans []Span
 
 	pos := 0
-	for _, sp := range originalSpans {
+	for _, sp := range line {
 		endPos := pos + sp.Width
 
 		// If span is completely before region
@@ -200,10 +300,10 @@ func (s *spanScreen) StyledLine(x, w, y int) Line {
 				spans = append(spans, sp)
 			} else {
 				// We need a sub-span
-				_, sub, _ := splitSpan(sp, offset, s.textMode) // skip left part
+				_, sub, _ := splitSpan(sp, offset, mode) // skip left part
 				// now sub is from offset to end. we might need to truncate it if it's too long
 				if sub.Width > width {
-					keep, _, _ := splitSpan(sub, width, s.textMode)
+					keep, _, _ := splitSpan(sub, width, mode)
 					spans = append(spans, keep)
 				} else {
 					spans = append(spans, sub)
@@ -214,10 +314,7 @@ func (s *spanScreen) StyledLine(x, w, y int) Line {
 		pos = endPos
 	}
 
-	return Line{
-		Spans: spans,
-		Width: w,
-	}
+	return spans
 }
 
 func (s *spanScreen) StyledLines(r Region) []Line {
@@ -249,15 +346,40 @@ func (s *spanScreen) renderLineANSI(y int) string {
 	return buf.String()
 }
 
+// setLine replaces row y with l, padding or truncating it to the screen width.
+func (s *spanScreen) setLine(y int, l Line) {
+	line := spanLine{spans: append([]Span(nil), l.Spans...), wrapped: l.Wrapped, marks: append([]Mark(nil), l.Marks...)}
+	line.width = lineCellWidth(&line)
+	resizeLine(&line, s.size.X, s.style, s.textMode)
+	s.lines[y] = line
+	s.frontend.RegionChanged(Region{Y: y, Y2: y + 1, X: 0, X2: s.size.X}, CRRedraw)
+}
+
+func (s *spanScreen) addMark(y int, m Mark) {
+	s.lines[y].marks = appendMark(s.lines[y].marks, m)
+}
+
 func (s *spanScreen) setStyle(style Style) {
 	s.style = style
 	s.frontend.StyleChanged(style)
 }
 
+// Hyperlink returns the hyperlink given to written text, or nil.
+func (s *spanScreen) Hyperlink() *Hyperlink {
+	return s.link
+}
+
+func (s *spanScreen) setHyperlink(link *Hyperlink) {
+	s.link = link
+}
+
 func (s *spanScreen) setSize(w, h int) {
 	if w <= 0 || h <= 0 {
 		panic("Size must be > 0")
 	}
+	s.tabs.resize(w)
+	s.leftMargin = 0
+	s.rightMargin = w - 1
 
 	prevH := s.size.Y
 	newLines := make([]spanLine, h)
@@ -300,6 +422,12 @@ func (s *spanScreen) eraseRegion(r Region, cr ChangeReason) {
 	for i := r.Y; i < r.Y2; i++ {
 		debugPrintln(debugErase, "erase: ", r.X, i, emptySpan.Width)
 		s.rawWriteSpan(r.X, i, emptySpan, cr)
+		if r.X2 == s.size.X {
+			s.lines[i].wrapped = false
+			if r.X == 0 {
+				s.lines[i].marks = nil
+			}
+		}
 	}
 }
 
@@ -326,33 +454,85 @@ func (s *spanScreen) writeString(text string, width int, merge bool, mode TextRe
 	if width > s.size.X {
 		width = s.size.X
 	}
-	if s.cursorPos.X+width > s.size.X {
+	if edge := s.rightEdge(); s.cursorPos.X+width > edge {
 		if s.autoWrap {
-			s.moveCursor(-s.cursorPos.X, 1, false, true)
+			s.wrapToNextLine()
 		} else {
-			s.cursorPos.X = s.size.X - width
+			s.cursorPos.X = max(edge-width, 0)
 		}
 	}
-	sp := Span{Style: s.style, Text: text, Width: width}
+	if s.insertMode {
+		s.insertChars(s.cursorPos.X, s.cursorPos.Y, width, CRText)
+	}
+	sp := Span{Style: s.style, Text: text, Width: width, Link: s.link}
 	s.rawWriteSpan(s.cursorPos.X, s.cursorPos.Y, sp, CRText)
-	s.moveCursor(width, 0, true, true)
+	s.advanceCursor(width)
 }
 
-func (s *spanScreen) insertRunes(b []rune) {
-	y := s.cursorPos.Y
-	n := len(b)
-	if n <= 0 {
+// wrapToNextLine marks the cursor row as soft-wrapped and moves the cursor to
+// the start of the next row, scrolling if needed.
+func (s *spanScreen) wrapToNextLine() {
+	if !s.hasLeftRightMargins() {
+		s.lines[s.cursorPos.Y].wrapped = true
+	}
+	s.moveCursor(s.lineStart()-s.cursorPos.X, 1, false, true)
+}
+
+// advanceCursor moves the cursor past a cell of the given width that was just
+// written, wrapping if autowrap carries the cursor over the right edge.
+func (s *spanScreen) advanceCursor(width int) {
+	x := s.cursorPos.X + width
+	if edge := s.rightEdge(); x >= edge {
+		if s.autoWrap {
+			s.wrapToNextLine()
+			return
+		}
+		x = edge - 1
+	}
+	s.setCursorPos(x, s.cursorPos.Y)
+}
+
+// insertChars inserts n blank cells at (x,y), shifting the rest of the line
+// right. Cells pushed past the right edge are lost. A wide cluster cut in half
+// by x or by the right edge is replaced with blanks.
+func (s *spanScreen) insertChars(x int, y int, n int, cr ChangeReason) {
+	if y < 0 || y >= s.size.Y || x < 0 || x >= s.size.X || n <= 0 {
 		return
 	}
-	if n > s.size.X {
-		n = s.size.X
+	end := s.size.X
+	if s.hasLeftRightMargins() {
+		if x < s.leftMargin || x > s.rightMargin {
+			return
+		}
+		end = s.rightMargin + 1
+	}
+	if x+n > end {
+		n = end - x
 	}
 
 	line := &s.lines[y]
-	truncateLine(line, s.size.X-n, s.textMode)
-	insertSpan(line, s.cursorPos.X, Span{Style: s.style, Rune: ' ', Width: n}, s.textMode)
+	blankSplitCluster(line, x, s.textMode)
+	blankSplitCluster(line, end-n, s.textMode)
+	blankSplitCluster(line, end, s.textMode)
+	replaceRange(line, end-n, n, Span{}, s.textMode)
+	insertSpan(line, x, Span{Style: s.style, Rune: ' ', Width: n}, s.textMode)
+	s.frontend.RegionChanged(Region{Y: y, Y2: y + 1, X: x, X2: end}, cr)
+}
 
-	s.rawWriteRunes(s.cursorPos.X, s.cursorPos.Y, b[:n], CRText)
+// blankSplitCluster replaces the wide cluster covering cell x with blanks in
+// the cluster's style, if x is not the cluster's first cell.
+func blankSplitCluster(line *spanLine, x int, mode TextReadMode) {
+	idx, offset := findSpanAtX(line, x)
+	if offset == 0 || idx >= len(line.spans) {
+		return
+	}
+	sp := line.spans[idx]
+	left, _, wide := splitSpan(sp, offset, mode)
+	if wide.Width == 0 {
+		return
+	}
+	start := x - offset + left.Width
+	replaceRange(line, start, wide.Width, Span{Style: sp.Style, Rune: ' ', Width: wide.Width}, mode)
 }
 
 func (s *spanScreen) rawWriteRunes(x int, y int, b []rune, cr ChangeReason) {
@@ -380,9 +560,9 @@ func (s *spanScreen) rawWriteRune(x int, y int, r rune, width int, cr ChangeReas
 
 	var sp Span
 	if width == 1 && r == ' ' {
-		sp = Span{Style: s.style, Rune: ' ', Width: 1}
+		sp = Span{Style: s.style, Rune: ' ', Width: 1, Link: s.link}
 	} else {
-		sp = Span{Style: s.style, Text: string(r), Width: width}
+		sp = Span{Style: s.style, Text: string(r), Width: width, Link: s.link}
 	}
 
 	replaceRange(&s.lines[y], x, width, sp, s.textMode)
@@ -400,11 +580,28 @@ func (s *spanScreen) deleteChars(x int, y int, n int, cr ChangeReason) {
 	if x >= s.size.X || n <= 0 {
 		return
 	}
-	if x+n > s.size.X {
-		n = s.size.X - x
+	end := s.size.X
+	if s.hasLeftRightMargins() {
+		if x < s.leftMargin || x > s.rightMargin {
+			return
+		}
+		end = s.rightMargin + 1
+	}
+	if x+n > end {
+		n = end - x
 	}
 
 	line := &s.lines[y]
+	if end < s.size.X {
+		// Only the cells up to the right margin move.
+		blankSplitCluster(line, x, s.textMode)
+		blankSplitCluster(line, x+n, s.textMode)
+		blankSplitCluster(line, end, s.textMode)
+		replaceRange(line, x, n, Span{}, s.textMode)
+		insertSpan(line, end-n, Span{Style: s.style, Rune: ' ', Width: n}, s.textMode)
+		s.frontend.RegionChanged(Region{Y: y, Y2: y + 1, X: x, X2: end}, cr)
+		return
+	}
 	// Delete characters from x to x+n, shift remaining chars left, and append spaces at the end
 	replaceRange(line, x, n, Span{}, s.textMode)
 	// Now append spaces to fill the end to width s.size.X
@@ -430,6 +627,35 @@ func (s *spanScreen) setScrollMarginTopBottom(top, bottom int) {
 	s.bottomMargin = clamp(bottom, 0, s.size.Y-1)
 }
 
+func (s *spanScreen) setScrollMarginLeftRight(left, right int) {
+	debugPrintln(debugScroll, "scroll margins left/right:", left, right)
+	s.leftMargin = clamp(left, 0, s.size.X-1)
+	s.rightMargin = clamp(right, 0, s.size.X-1)
+}
+
+// hasLeftRightMargins reports whether the left/right margins are narrower
+// than the screen.
+func (s *spanScreen) hasLeftRightMargins() bool {
+	return s.leftMargin != 0 || s.rightMargin != s.size.X-1
+}
+
+// rightEdge returns the column after the last one text at the cursor may
+// use: past the right margin if the cursor is inside it, else the screen edge.
+func (s *spanScreen) rightEdge() int {
+	if s.cursorPos.X <= s.rightMargin {
+		return s.rightMargin + 1
+	}
+	return s.size.X
+}
+
+// lineStart returns the column a wrap moves the cursor to.
+func (s *spanScreen) lineStart() int {
+	if s.cursorPos.X >= s.leftMargin {
+		return s.leftMargin
+	}
+	return 0
+}
+
 func (s *spanScreen) scroll(y1 int, y2 int, dy int) {
 	debugPrintln(debugScroll, "scroll:", y1, y2, dy)
 	y1 = clamp(y1, 0, s.size.Y-1)
@@ -437,6 +663,12 @@ func (s *spanScreen) scroll(y1 int, y2 int, dy int) {
 	if y1 > y2 {
 		fmt.Fprintln(os.Stderr, "scroll ys out of order", y1, y2, dy)
 	}
+	// Scrolling by more than the region's height just clears it.
+	dy = clamp(dy, -(y2 - y1 + 1), y2-y1+1)
+	if s.hasLeftRightMargins() {
+		s.scrollColumns(y1, y2, dy, s.leftMargin, s.rightMargin+1)
+		return
+	}
 
 	if dy > 0 {
 		for y := y2; y >= y1+dy; y-- {
@@ -445,7 +677,7 @@ func (s *spanScreen) scroll(y1 int, y2 int, dy int) {
 		for y := y1; y < y1+dy; y++ {
 			s.lines[y] = blankSpanLine(s.size.X, s.style)
 		}
-		s.frontend.RegionChanged(Region{Y: y1 + dy, Y2: y2 + 1, X: 0, X2: s.size.X}, CRScroll)
+		regionScrolled(s.frontend, Region{Y: y1 + dy, Y2: y2 + 1, X: 0, X2: s.size.X}, dy)
 		debugPrintln(debugScroll, "scroll changed region:", Region{Y: y1, Y2: y1 + dy, X: 0, X2: s.size.X})
 		s.frontend.RegionChanged(Region{Y: y1, Y2: y1 + dy, X: 0, X2: s.size.X}, CRScroll)
 	} else {
@@ -455,11 +687,48 @@ func (s *spanScreen) scroll(y1 int, y2 int, dy int) {
 		for y := y2 + dy + 1; y <= y2; y++ {
 			s.lines[y] = blankSpanLine(s.size.X, s.style)
 		}
-		s.frontend.RegionChanged(Region{Y: y1, Y2: y2 + dy + 1, X: 0, X2: s.size.X}, CRScroll)
+		regionScrolled(s.frontend, Region{Y: y1, Y2: y2 + dy + 1, X: 0, X2: s.size.X}, dy)
 		s.frontend.RegionChanged(Region{Y: y2 + dy + 1, Y2: y2 + 1, X: 0, X2: s.size.X}, CRScroll)
 	}
 }
 
+// scrollColumns is scroll limited to the cells in columns [x1, x2), used when
+// left and right margins are set. Wide clusters cut by the margins become blanks.
+func (s *spanScreen) scrollColumns(y1 int, y2 int, dy int, x1 int, x2 int) {
+	w := x2 - x1
+	for y := y1; y <= y2; y++ {
+		blankSplitCluster(&s.lines[y], x1, s.textMode)
+		blankSplitCluster(&s.lines[y], x2, s.textMode)
+	}
+	copyRow := func(dst, src int) {
+		spans := s.StyledLine(x1, w, src).Spans
+		line := &s.lines[dst]
+		replaceRange(line, x1, w, Span{}, s.textMode)
+		x := x1
+		for _, sp := range spans {
+			insertSpan(line, x, sp, s.textMode)
+			x += sp.Width
+		}
+	}
+	blank := Span{Style: s.style, Rune: ' ', Width: w}
+	if dy > 0 {
+		for y := y2; y >= y1+dy; y-- {
+			copyRow(y, y-dy)
+		}
+		for y := y1; y < y1+dy && y <= y2; y++ {
+			replaceRange(&s.lines[y], x1, w, blank, s.textMode)
+		}
+	} else {
+		for y := y1; y <= y2+dy; y++ {
+			copyRow(y, y-dy)
+		}
+		for y := max(y2+dy+1, y1); y <= y2; y++ {
+			replaceRange(&s.lines[y], x1, w, blank, s.textMode)
+		}
+	}
+	s.frontend.RegionChanged(Region{Y: y1, Y2: y2 + 1, X: x1, X2: x2}, CRScroll)
+}
+
 func (s *spanScreen) clampRegion(r Region) Region {
 	return r.Clamp(Region{0, 0, s.size.X, s.size.Y})
 }
@@ -487,6 +756,7 @@ func (s *spanScreen) moveCursor(dx, dy int, wrap bool, scroll bool) {
 			s.cursorPos.Y = s.topMargin
 		}
 		if s.cursorPos.Y > s.bottomMargin {
+			s.saveScrollback(s.cursorPos.Y - s.bottomMargin)
 			s.scroll(s.topMargin, s.bottomMargin, s.bottomMargin-s.cursorPos.Y)
 			s.cursorPos.Y = s.bottomMargin
 		}
@@ -499,13 +769,31 @@ func (s *spanScreen) moveCursor(dx, dy int, wrap bool, scroll bool) {
 	s.frontend.CursorMoved(s.cursorPos.X, s.cursorPos.Y)
 }
 
-func (s *spanScreen) saveCursorPos() {
-	s.savedCursorPos = s.cursorPos
+// reset restores the screen to its initial state, keeping its size.
+func (s *spanScreen) reset() {
+	s.softReset()
+	s.eraseRegion(Region{X: 0, Y: 0, X2: s.size.X, Y2: s.size.Y}, CRClear)
+	s.tabs.reset()
+	s.setCursorPos(0, 0)
 }
 
-func (s *spanScreen) restoreCursorPos() {
-	s.cursorPos = s.savedCursorPos
-	s.frontend.CursorMoved(s.cursorPos.X, s.cursorPos.Y)
+// softReset resets modes, margins and attributes without touching the screen
+// contents (DECSTR). Like xterm, it also turns off DECLRMM.
+func (s *spanScreen) softReset() {
+	s.autoWrap = false
+	s.insertMode = false
+	s.originMode = false
+	s.lrMarginMode = false
+	s.topMargin = 0
+	s.bottomMargin = s.size.Y - 1
+	s.leftMargin = 0
+	s.rightMargin = s.size.X - 1
+	s.link = nil
+	s.setStyle(NewStyle())
+}
+
+func (s *spanScreen) tabStops() tabStops {
+	return s.tabs
 }
 
 func (s *spanScreen) printScreen() {
@@ -647,9 +935,9 @@ func splitSpan(sp Span, cellOffset int, mode TextReadMode) (left Span, right Spa
 		}
 
 		clusterEnd := cellPos + width
-		if cellOffset >= cellPos && cellOffset < clusterEnd {
-			// Split point is within this cluster
-			if width > 1 {
+		if cellOffset < clusterEnd {
+			// Split point is at the start of or within this cluster
+			if cellOffset > cellPos && width > 1 {
 				// We're breaking a wide cluster - return the wide char we're splitting
 				leftText := sp.Text[:idx]
 				rightText := sp.Text[idx+consumed:]
@@ -870,12 +1158,7 @@ func replaceRange(line *spanLine, x int, n int, insert Span, mode TextReadMode)
 			line.width = totalWidth - n + insert.Width
 			return
 		}
-		// Compare styles
-		spFG, _, _ := sp.Style.GetColor(ComponentFG)
-		insertFG, _, _ := insert.Style.GetColor(ComponentFG)
-		spBG, _, _ := sp.Style.GetColor(ComponentBG)
-		insertBG, _, _ := insert.Style.GetColor(ComponentBG)
-		if insert.Width == n && spFG == insertFG && spBG == insertBG {
+		if insert.Width == n && sp.Style == insert.Style && sp.Link == insert.Link {
 			if sp.Text == "" && insert.Text == "" && sp.Rune == insert.Rune {
 				return
 			}
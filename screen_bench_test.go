@@ -77,6 +77,8 @@ func newBenchTerminal(frontend Frontend, backend Backend, mode TextReadMode, new
 		viewInts:     make([]int, viewIntCount),
 		viewStrings:  make([]string, viewStringCount),
 		textReadMode: mode,
+		clock:        systemClock{},
+		syncTimeout:  DefaultSyncTimeout,
 	}
 	term.startReadLoop()
 	return term
@@ -224,3 +226,58 @@ func BenchmarkTerminalRandomCellUpdates(b *testing.B) {
 		})
 	}
 }
+
+// runRenderBench feeds frame to a terminal drawn by an attached TTYFrontend
+// b.N times, either pushing every change to it or, in pull mode, rendering
+// the damage once after each frame.
+func runRenderBench(b *testing.B, frame []byte, autoWrap, pull bool, newFn func(Frontend) screen) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+
+	tln := makeTerminalWithScreens(newFn)
+	tty := NewTTYFrontend(tln, io.Discard)
+	tln.SetFrontend(tty)
+	if err := tln.Resize(benchWidth, benchHeight); err != nil {
+		b.Fatalf("Resize failed: %v", err)
+	}
+	tln.screen().SetAutoWrap(autoWrap)
+	tty.Attach(Region{X2: benchWidth, Y2: benchHeight})
+	tty.SetPull(pull)
+	tty.Render()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := tln.testFeedTerminalInputFromBackend(frame, TextReadModeRune); err != nil {
+			b.Fatal(err)
+		}
+		if pull {
+			tty.Render()
+		}
+	}
+}
+
+func benchRenderModes(b *testing.B, frame []byte, autoWrap bool) {
+	for _, factory := range screenFactories() {
+		for _, mode := range []struct {
+			name string
+			pull bool
+		}{{"push", false}, {"pull", true}} {
+			factory, mode := factory, mode
+			b.Run(factory.name+"/"+mode.name, func(b *testing.B) {
+				runRenderBench(b, frame, autoWrap, mode.pull, factory.new)
+			})
+		}
+	}
+}
+
+func BenchmarkTTYRenderWrapScroll(b *testing.B) {
+	benchRenderModes(b, buildWrapPayload(benchHeight), true)
+}
+
+func BenchmarkTTYRenderStyled(b *testing.B) {
+	benchRenderModes(b, buildStyledPayload(benchHeight, true), false)
+}
+
+func BenchmarkTTYRenderRandomCellUpdates(b *testing.B) {
+	benchRenderModes(b, buildRandomUpdatesPayload(benchWidth), false)
+}
@@ -16,18 +16,35 @@ type gridScreen struct {
 	cellWidth  [][]uint8
 	cellCont   [][]bool
 	cellStyles [][]Style
+	cellLinks  [][]*Hyperlink
+	wrapped    []bool   // rows that autowrap continued onto the next row
+	marks      [][]Mark // OSC 133 marks on each row, oldest first
 	frontend   Frontend
 
 	style Style
+	// link is the hyperlink given to written text, or nil.
+	link *Hyperlink
 
 	size Pos
 
-	cursorPos      Pos
-	savedCursorPos Pos
+	cursorPos Pos
 
 	topMargin, bottomMargin int
+	leftMargin, rightMargin int
+
+	// originMode (DECOM) makes cursor addressing relative to the margins.
+	originMode bool
+	// lrMarginMode (DECLRMM) enables the left and right margins.
+	lrMarginMode bool
 
 	autoWrap bool
+	// insertMode (IRM) makes written text shift the rest of the line right.
+	insertMode bool
+
+	tabs tabStops
+
+	// scrollback receives lines scrolled off the top; nil on the alt screen.
+	scrollback *scrollback
 }
 
 func newGridScreen(f Frontend) *gridScreen {
@@ -61,6 +78,44 @@ func (s *gridScreen) SetAutoWrap(value bool) {
 	s.autoWrap = value
 }
 
+func (s *gridScreen) InsertMode() bool {
+	return s.insertMode
+}
+
+func (s *gridScreen) SetInsertMode(value bool) {
+	s.insertMode = value
+}
+
+func (s *gridScreen) OriginMode() bool {
+	return s.originMode
+}
+
+func (s *gridScreen) SetOriginMode(value bool) {
+	s.originMode = value
+}
+
+func (s *gridScreen) LeftRightMarginMode() bool {
+	return s.lrMarginMode
+}
+
+// SetLeftRightMarginMode enables or disables the left and right margins.
+// Disabling them resets the margins to the full width.
+func (s *gridScreen) SetLeftRightMarginMode(value bool) {
+	s.lrMarginMode = value
+	if !value {
+		s.leftMargin = 0
+		s.rightMargin = s.size.X - 1
+	}
+}
+
+func (s *gridScreen) LeftMargin() int {
+	return s.leftMargin
+}
+
+func (s *gridScreen) RightMargin() int {
+	return s.rightMargin
+}
+
 func (s *gridScreen) TopMargin() int {
 	return s.topMargin
 }
@@ -73,6 +128,24 @@ func (s *gridScreen) SetFrontend(f Frontend) {
 	s.frontend = f
 }
 
+func (s *gridScreen) setScrollback(sb *scrollback) {
+	s.scrollback = sb
+}
+
+// saveScrollback copies the top n lines into the scrollback before they are
+// scrolled away. Lines only go to history when the scroll region starts at the
+// top of the screen.
+func (s *gridScreen) saveScrollback(n int) {
+	if s.scrollback == nil || s.topMargin != 0 || s.hasLeftRightMargins() || n <= 0 {
+		return
+	}
+	n = min(n, s.bottomMargin+1)
+	s.frontend.ScrollLines(n)
+	for y := 0; y < n; y++ {
+		s.scrollback.push(s.StyledLine(0, s.size.X, y))
+	}
+}
+
 func (s *gridScreen) getLine(y int) []rune {
 	return s.chars[y]
 }
@@ -94,6 +167,7 @@ func (s *gridScreen) Line(y int) string {
 func (s *gridScreen) StyledLine(x, w, y int) Line {
 	text := s.getLine(y)
 	styles := s.cellStyles[y]
+	links := s.cellLinks[y]
 	cellText := s.cellText[y]
 
 	var spans []Span
@@ -104,11 +178,12 @@ func (s *gridScreen) StyledLine(x, w, y int) Line {
 
 	for i := x; i < x+w; {
 		style := styles[i]
+		link := links[i]
 		width := 0
 		start := i
 
-		// Find run of identical styles
-		for i < x+w && styles[i] == style {
+		// Find run of identical styles and links
+		for i < x+w && styles[i] == style && links[i] == link {
 			width++
 			i++
 		}
@@ -128,7 +203,7 @@ func (s *gridScreen) StyledLine(x, w, y int) Line {
 		}
 
 		if isRepeat {
-			spans = append(spans, Span{Style: style, Rune: firstRune, Width: width})
+			spans = append(spans, Span{Style: style, Rune: firstRune, Width: width, Link: link})
 		} else {
 			// Construct text for span
 			var sb strings.Builder
@@ -146,12 +221,14 @@ func (s *gridScreen) StyledLine(x, w, y int) Line {
 				spanWidth++
 			}
 
-			spans = append(spans, Span{Style: style, Text: sb.String(), Width: spanWidth})
+			spans = append(spans, Span{Style: style, Text: sb.String(), Width: spanWidth, Link: link})
 		}
 	}
 	return Line{
-		Spans: spans,
-		Width: w,
+		Spans:   spans,
+		Width:   w,
+		Wrapped: s.wrapped[y] && x+w == s.size.X,
+		Marks:   sliceMarks(s.marks[y], x, w, s.size.X),
 	}
 }
 
@@ -182,15 +259,93 @@ func (s *gridScreen) renderLineANSI(y int) string {
 	return buf.String()
 }
 
+// setLine replaces row y with l, padding or truncating it to the screen width.
+func (s *gridScreen) setLine(y int, l Line) {
+	x := 0
+	for _, sp := range l.Spans {
+		if sp.Text == "" {
+			for i := 0; i < sp.Width && x < s.size.X; i++ {
+				s.setCell(x, y, sp.Rune, string(sp.Rune), 1, sp.Style, sp.Link)
+				x++
+			}
+			continue
+		}
+		end := min(x+sp.Width, s.size.X)
+		text := []byte(sp.Text)
+		state := -1
+		for len(text) > 0 && x < end {
+			cluster, consumed, width, newState, ok := stepTextCluster(text, state, TextReadModeGrapheme)
+			if !ok || consumed <= 0 {
+				break
+			}
+			text = text[consumed:]
+			state = newState
+			if width < 1 {
+				width = 1
+			}
+			if x+width > end {
+				break
+			}
+			r, _ := utf8.DecodeRune(cluster)
+			s.setCell(x, y, r, string(cluster), width, sp.Style, sp.Link)
+			x += width
+		}
+		for ; x < end; x++ {
+			s.setCell(x, y, ' ', " ", 1, sp.Style, sp.Link)
+		}
+	}
+	for ; x < s.size.X; x++ {
+		s.setCell(x, y, ' ', " ", 1, s.style, nil)
+	}
+	s.wrapped[y] = l.Wrapped
+	s.marks[y] = append([]Mark(nil), l.Marks...)
+	s.frontend.RegionChanged(Region{Y: y, Y2: y + 1, X: 0, X2: s.size.X}, CRRedraw)
+}
+
+// setCell stores a cluster of the given width at (x, y), marking the cells it
+// covers as continuations. The caller must ensure it fits on the row.
+func (s *gridScreen) setCell(x, y int, r rune, text string, width int, style Style, link *Hyperlink) {
+	s.chars[y][x] = r
+	s.cellText[y][x] = text
+	s.cellWidth[y][x] = uint8(width)
+	s.cellCont[y][x] = false
+	s.cellStyles[y][x] = style
+	s.cellLinks[y][x] = link
+	for i := 1; i < width; i++ {
+		s.chars[y][x+i] = 0
+		s.cellText[y][x+i] = ""
+		s.cellWidth[y][x+i] = 0
+		s.cellCont[y][x+i] = true
+		s.cellStyles[y][x+i] = style
+		s.cellLinks[y][x+i] = link
+	}
+}
+
+func (s *gridScreen) addMark(y int, m Mark) {
+	s.marks[y] = appendMark(s.marks[y], m)
+}
+
 func (s *gridScreen) setStyle(style Style) {
 	s.style = style
 	s.frontend.StyleChanged(style)
 }
 
+// Hyperlink returns the hyperlink given to written text, or nil.
+func (s *gridScreen) Hyperlink() *Hyperlink {
+	return s.link
+}
+
+func (s *gridScreen) setHyperlink(link *Hyperlink) {
+	s.link = link
+}
+
 func (s *gridScreen) setSize(w, h int) {
 	if w <= 0 || h <= 0 {
 		panic("Size must be > 0")
 	}
+	s.tabs.resize(w)
+	s.leftMargin = 0
+	s.rightMargin = w - 1
 
 	// resize screen. copy current screen to upper-left corner of new screen
 
@@ -267,6 +422,24 @@ func (s *gridScreen) setSize(w, h int) {
 	}
 	s.cellStyles = styleRect
 
+	linkRect := make([][]*Hyperlink, h)
+	linkRaw := make([]*Hyperlink, w*h)
+	for i := range linkRect {
+		linkRect[i], linkRaw = linkRaw[:w], linkRaw[w:]
+		if i < prevH {
+			copy(linkRect[i][:minW], s.cellLinks[i][:minW])
+		}
+	}
+	s.cellLinks = linkRect
+
+	wrapped := make([]bool, h)
+	copy(wrapped, s.wrapped)
+	s.wrapped = wrapped
+
+	marks := make([][]Mark, h)
+	copy(marks, s.marks)
+	s.marks = marks
+
 	s.bottomMargin = h - (s.size.Y - s.bottomMargin)
 
 	s.size = Pos{X: w, Y: h}
@@ -292,9 +465,38 @@ func (s *gridScreen) eraseRegion(r Region, cr ChangeReason) {
 	for i := r.Y; i < r.Y2; i++ {
 		debugPrintln(debugErase, "erase: ", r.X, i, len(bytes))
 		s.rawWriteRunes(r.X, i, bytes, cr)
+		if r.X2 == s.size.X {
+			s.wrapped[i] = false
+			if r.X == 0 {
+				s.marks[i] = nil
+			}
+		}
 	}
 }
 
+// wrapToNextLine marks the cursor row as soft-wrapped and moves the cursor to
+// the start of the next row, scrolling if needed.
+func (s *gridScreen) wrapToNextLine() {
+	if !s.hasLeftRightMargins() {
+		s.wrapped[s.cursorPos.Y] = true
+	}
+	s.moveCursor(s.lineStart()-s.cursorPos.X, 1, false, true)
+}
+
+// advanceCursor moves the cursor past a cell of the given width that was just
+// written, wrapping if autowrap carries the cursor over the right edge.
+func (s *gridScreen) advanceCursor(width int) {
+	x := s.cursorPos.X + width
+	if edge := s.rightEdge(); x >= edge {
+		if s.autoWrap {
+			s.wrapToNextLine()
+			return
+		}
+		x = edge - 1
+	}
+	s.setCursorPos(x, s.cursorPos.Y)
+}
+
 // This is a very raw write function. It wraps as necessary, but assumes all
 // the bytes are printable bytes
 func (s *gridScreen) writeRunes(b []rune) {
@@ -303,15 +505,18 @@ func (s *gridScreen) writeRunes(b []rune) {
 		if width > s.size.X {
 			width = s.size.X
 		}
-		if s.cursorPos.X+width > s.size.X {
+		if edge := s.rightEdge(); s.cursorPos.X+width > edge {
 			if s.autoWrap {
-				s.moveCursor(-s.cursorPos.X, 1, false, true)
+				s.wrapToNextLine()
 			} else {
-				s.cursorPos.X = s.size.X - width
+				s.cursorPos.X = max(edge-width, 0)
 			}
 		}
+		if s.insertMode {
+			s.insertChars(s.cursorPos.X, s.cursorPos.Y, width, CRText)
+		}
 		s.rawWriteRune(s.cursorPos.X, s.cursorPos.Y, r, width, CRText)
-		s.moveCursor(width, 0, true, true)
+		s.advanceCursor(width)
 	}
 }
 
@@ -334,15 +539,18 @@ func (s *gridScreen) writeTokens(tokens []GraphemeToken) {
 			if width > s.size.X {
 				width = s.size.X
 			}
-			if s.cursorPos.X+width > s.size.X {
+			if edge := s.rightEdge(); s.cursorPos.X+width > edge {
 				if s.autoWrap {
-					s.moveCursor(-s.cursorPos.X, 1, false, true)
+					s.wrapToNextLine()
 				} else {
-					s.cursorPos.X = s.size.X - width
+					s.cursorPos.X = max(edge-width, 0)
 				}
 			}
+			if s.insertMode {
+				s.insertChars(s.cursorPos.X, s.cursorPos.Y, width, CRText)
+			}
 			s.rawWriteRune(s.cursorPos.X, s.cursorPos.Y, r, width, CRText)
-			s.moveCursor(width, 0, true, true)
+			s.advanceCursor(width)
 			continue
 		}
 		for len(text) > 0 {
@@ -355,15 +563,18 @@ func (s *gridScreen) writeTokens(tokens []GraphemeToken) {
 			if width > s.size.X {
 				width = s.size.X
 			}
-			if s.cursorPos.X+width > s.size.X {
+			if edge := s.rightEdge(); s.cursorPos.X+width > edge {
 				if s.autoWrap {
-					s.moveCursor(-s.cursorPos.X, 1, false, true)
+					s.wrapToNextLine()
 				} else {
-					s.cursorPos.X = s.size.X - width
+					s.cursorPos.X = max(edge-width, 0)
 				}
 			}
+			if s.insertMode {
+				s.insertChars(s.cursorPos.X, s.cursorPos.Y, width, CRText)
+			}
 			s.rawWriteRune(s.cursorPos.X, s.cursorPos.Y, r, width, CRText)
-			s.moveCursor(width, 0, true, true)
+			s.advanceCursor(width)
 		}
 	}
 }
@@ -380,23 +591,49 @@ func (s *gridScreen) mergeIntoPreviousCell(text string) {
 	s.cellText[y][x] += text
 }
 
-// This is like writeRunes, but it moves existing runes to the right
-func (s *gridScreen) insertRunes(b []rune) {
-	y := s.cursorPos.Y
-	fsx := s.cursorPos.X
-	fex := fsx + len(b)
-	tsx := s.size.X - len(b)
-	tex := s.size.X
+// insertChars inserts n blank cells at (x,y), shifting the rest of the line
+// right. Cells pushed past the right edge are lost. A wide cluster cut in half
+// by x or by the right edge is replaced with blanks.
+func (s *gridScreen) insertChars(x int, y int, n int, cr ChangeReason) {
+	if y < 0 || y >= s.size.Y || x < 0 || x >= s.size.X || n <= 0 {
+		return
+	}
+	end := s.size.X
+	if s.hasLeftRightMargins() {
+		if x < s.leftMargin || x > s.rightMargin {
+			return
+		}
+		end = s.rightMargin + 1
+	}
+	if x+n > end {
+		n = end - x
+	}
 
-	// first: move everything over
-	copy(s.chars[y][tsx:tex], s.chars[y][fsx:fex])
-	copy(s.cellText[y][tsx:tex], s.cellText[y][fsx:fex])
-	copy(s.cellWidth[y][tsx:tex], s.cellWidth[y][fsx:fex])
-	copy(s.cellCont[y][tsx:tex], s.cellCont[y][fsx:fex])
-	copy(s.cellStyles[y][tsx:tex], s.cellStyles[y][fsx:fex])
-	// TODO! RegionChanged!
+	if s.cellCont[y][x] {
+		s.clearWideAt(y, x)
+	}
+	if s.cellCont[y][end-n] {
+		s.clearWideAt(y, end-n)
+	}
+	if end < s.size.X && s.cellCont[y][end] {
+		s.clearWideAt(y, end)
+	}
+
+	copy(s.chars[y][x+n:end], s.chars[y][x:])
+	copy(s.cellText[y][x+n:end], s.cellText[y][x:])
+	copy(s.cellWidth[y][x+n:end], s.cellWidth[y][x:])
+	copy(s.cellCont[y][x+n:end], s.cellCont[y][x:])
+	copy(s.cellStyles[y][x+n:end], s.cellStyles[y][x:])
+	copy(s.cellLinks[y][x+n:end], s.cellLinks[y][x:])
+	for i := x; i < x+n; i++ {
+		s.chars[y][i] = ' '
+		s.cellText[y][i] = " "
+		s.cellWidth[y][i] = 1
+		s.cellCont[y][i] = false
+	}
+	s.rawWriteStyles(y, x, x+n)
 
-	s.rawWriteRunes(s.cursorPos.X, s.cursorPos.Y, b, CRText)
+	s.frontend.RegionChanged(Region{Y: y, Y2: y + 1, X: x, X2: end}, cr)
 }
 
 // This is a very raw write function. It assumes all the bytes are printable bytes
@@ -467,6 +704,9 @@ func (s *gridScreen) rawWriteRune(x int, y int, r rune, width int, cr ChangeReas
 		end = s.size.X
 	}
 	s.rawWriteStyles(y, x, end)
+	for i := x; i < x+width; i++ {
+		s.cellLinks[y][i] = s.link
+	}
 	s.frontend.RegionChanged(Region{Y: y, Y2: y + 1, X: x, X2: end}, cr)
 }
 
@@ -501,10 +741,12 @@ func runeCellWidth(r rune) int {
 	return width
 }
 
-// rawWriteStyles copies the current style to the screen, from x1 to x2
+// rawWriteStyles copies the current style to the screen, from x1 to x2, and
+// removes any hyperlink from those cells.
 func (s *gridScreen) rawWriteStyles(y int, x1 int, x2 int) {
 	for i := x1; i < x2; i++ {
 		s.cellStyles[y][i] = s.style
+		s.cellLinks[y][i] = nil
 	}
 }
 
@@ -521,19 +763,32 @@ func (s *gridScreen) deleteChars(x int, y int, n int, cr ChangeReason) {
 	if x >= s.size.X || n <= 0 {
 		return
 	}
-	if x+n > s.size.X {
-		n = s.size.X - x
+	end := s.size.X
+	if s.hasLeftRightMargins() {
+		if x < s.leftMargin || x > s.rightMargin {
+			return
+		}
+		end = s.rightMargin + 1
+	}
+	if x+n > end {
+		n = end - x
+	}
+
+	for _, edge := range [...]int{x, x + n, end} {
+		if edge < s.size.X && s.cellCont[y][edge] {
+			s.clearWideAt(y, edge)
+		}
 	}
 
 	line := s.chars[y]
-	copy(line[x:], line[x+n:])
+	copy(line[x:end], line[x+n:end])
 	textLine := s.cellText[y]
-	copy(textLine[x:], textLine[x+n:])
+	copy(textLine[x:end], textLine[x+n:end])
 	widthLine := s.cellWidth[y]
-	copy(widthLine[x:], widthLine[x+n:])
+	copy(widthLine[x:end], widthLine[x+n:end])
 	contLine := s.cellCont[y]
-	copy(contLine[x:], contLine[x+n:])
-	for i := s.size.X - n; i < s.size.X; i++ {
+	copy(contLine[x:end], contLine[x+n:end])
+	for i := end - n; i < end; i++ {
 		line[i] = ' '
 		textLine[i] = " "
 		widthLine[i] = 1
@@ -541,10 +796,12 @@ func (s *gridScreen) deleteChars(x int, y int, n int, cr ChangeReason) {
 	}
 
 	styleLine := s.cellStyles[y]
-	copy(styleLine[x:], styleLine[x+n:])
-	s.rawWriteStyles(y, s.size.X-n, s.size.X)
+	copy(styleLine[x:end], styleLine[x+n:end])
+	linkLine := s.cellLinks[y]
+	copy(linkLine[x:end], linkLine[x+n:end])
+	s.rawWriteStyles(y, end-n, end)
 
-	s.frontend.RegionChanged(Region{Y: y, Y2: y + 1, X: x, X2: s.size.X}, cr)
+	s.frontend.RegionChanged(Region{Y: y, Y2: y + 1, X: x, X2: end}, cr)
 }
 
 // func (s *gridScreen) advanceLine(auto bool) {
@@ -571,6 +828,35 @@ func (s *gridScreen) setScrollMarginTopBottom(top, bottom int) {
 	s.bottomMargin = clamp(bottom, 0, s.size.Y-1)
 }
 
+func (s *gridScreen) setScrollMarginLeftRight(left, right int) {
+	debugPrintln(debugScroll, "scroll margins left/right:", left, right)
+	s.leftMargin = clamp(left, 0, s.size.X-1)
+	s.rightMargin = clamp(right, 0, s.size.X-1)
+}
+
+// hasLeftRightMargins reports whether the left/right margins are narrower
+// than the screen.
+func (s *gridScreen) hasLeftRightMargins() bool {
+	return s.leftMargin != 0 || s.rightMargin != s.size.X-1
+}
+
+// rightEdge returns the column after the last one text at the cursor may
+// use: past the right margin if the cursor is inside it, else the screen edge.
+func (s *gridScreen) rightEdge() int {
+	if s.cursorPos.X <= s.rightMargin {
+		return s.rightMargin + 1
+	}
+	return s.size.X
+}
+
+// lineStart returns the column a wrap moves the cursor to.
+func (s *gridScreen) lineStart() int {
+	if s.cursorPos.X >= s.leftMargin {
+		return s.leftMargin
+	}
+	return 0
+}
+
 func (s *gridScreen) scroll(y1 int, y2 int, dy int) {
 	debugPrintln(debugScroll, "scroll:", y1, y2, dy)
 	y1 = clamp(y1, 0, s.size.Y-1)
@@ -581,32 +867,50 @@ func (s *gridScreen) scroll(y1 int, y2 int, dy int) {
 	if y1 > y2 {
 		fmt.Fprintln(os.Stderr, "scroll ys out of order", y1, y2, dy)
 	}
+	// Scrolling by more than the region's height just clears it.
+	dy = clamp(dy, -(y2 - y1 + 1), y2-y1+1)
+
+	// With left/right margins only the cells between them move.
+	x1, x2 := s.leftMargin, s.rightMargin+1
+	full := !s.hasLeftRightMargins()
+	if !full {
+		for y := y1; y <= y2; y++ {
+			if s.cellCont[y][x1] {
+				s.clearWideAt(y, x1)
+			}
+			if x2 < s.size.X && s.cellCont[y][x2] {
+				s.clearWideAt(y, x2)
+			}
+		}
+	}
+	moveRow := func(dst, src int) {
+		copy(s.chars[dst][x1:x2], s.chars[src][x1:x2])
+		copy(s.cellText[dst][x1:x2], s.cellText[src][x1:x2])
+		copy(s.cellWidth[dst][x1:x2], s.cellWidth[src][x1:x2])
+		copy(s.cellCont[dst][x1:x2], s.cellCont[src][x1:x2])
+		copy(s.cellStyles[dst][x1:x2], s.cellStyles[src][x1:x2])
+		copy(s.cellLinks[dst][x1:x2], s.cellLinks[src][x1:x2])
+		if full {
+			s.wrapped[dst] = s.wrapped[src]
+			s.marks[dst] = s.marks[src]
+		}
+	}
 
 	if dy > 0 {
 		for y := y2; y >= y1+dy; y-- {
-			// fmt.Println("   2: ", y, y2, y1+dy)
-			copy(s.chars[y], s.chars[y-dy])
-			copy(s.cellText[y], s.cellText[y-dy])
-			copy(s.cellWidth[y], s.cellWidth[y-dy])
-			copy(s.cellCont[y], s.cellCont[y-dy])
-			copy(s.cellStyles[y], s.cellStyles[y-dy])
+			moveRow(y, y-dy)
 		}
 		// these are non-inclusive, so need +1
-		s.frontend.RegionChanged(Region{Y: y1 + dy, Y2: y2 + 1, X: 0, X2: s.size.X}, CRScroll)
-		debugPrintln(debugScroll, "scroll changed region:", Region{Y: y1, Y2: y1 + dy, X: 0, X2: s.size.X})
-		s.eraseRegion(Region{Y: y1, Y2: y1 + dy, X: 0, X2: s.size.X}, CRScroll)
+		regionScrolled(s.frontend, Region{Y: y1 + dy, Y2: y2 + 1, X: x1, X2: x2}, dy)
+		debugPrintln(debugScroll, "scroll changed region:", Region{Y: y1, Y2: y1 + dy, X: x1, X2: x2})
+		s.eraseRegion(Region{Y: y1, Y2: y1 + dy, X: x1, X2: x2}, CRScroll)
 	} else {
 		for y := y1; y <= y2+dy; y++ {
-			// fmt.Println("   3: ", y, y1, y2+dy)
-			copy(s.chars[y], s.chars[y-dy])
-			copy(s.cellText[y], s.cellText[y-dy])
-			copy(s.cellWidth[y], s.cellWidth[y-dy])
-			copy(s.cellCont[y], s.cellCont[y-dy])
-			copy(s.cellStyles[y], s.cellStyles[y-dy])
+			moveRow(y, y-dy)
 		}
 		// these are non-inclusive, so need +1
-		s.frontend.RegionChanged(Region{Y: y1, Y2: y2 + dy + 1, X: 0, X2: s.size.X}, CRScroll)
-		s.eraseRegion(Region{Y: y2 + dy + 1, Y2: y2 + 1, X: 0, X2: s.size.X}, CRScroll)
+		regionScrolled(s.frontend, Region{Y: y1, Y2: y2 + dy + 1, X: x1, X2: x2}, dy)
+		s.eraseRegion(Region{Y: y2 + dy + 1, Y2: y2 + 1, X: x1, X2: x2}, CRScroll)
 	}
 }
 
@@ -651,6 +955,7 @@ func (s *gridScreen) moveCursor(dx, dy int, wrap bool, scroll bool) {
 			s.cursorPos.Y = s.topMargin
 		}
 		if s.cursorPos.Y > s.bottomMargin {
+			s.saveScrollback(s.cursorPos.Y - s.bottomMargin)
 			s.scroll(s.topMargin, s.bottomMargin, s.bottomMargin-s.cursorPos.Y)
 			s.cursorPos.Y = s.bottomMargin
 		}
@@ -670,13 +975,31 @@ func (s *gridScreen) moveCursor(dx, dy int, wrap bool, scroll bool) {
 	//debugPrintf(debugCursor, "cursor move: %v, %v  %v, %v: %v %v\n", s.cursorPos.X, s.cursorPos.Y, dx, dy, wrap, scroll)
 }
 
-func (s *gridScreen) saveCursorPos() {
-	s.savedCursorPos = s.cursorPos
+// reset restores the screen to its initial state, keeping its size.
+func (s *gridScreen) reset() {
+	s.softReset()
+	s.eraseRegion(Region{X: 0, Y: 0, X2: s.size.X, Y2: s.size.Y}, CRClear)
+	s.tabs.reset()
+	s.setCursorPos(0, 0)
 }
 
-func (s *gridScreen) restoreCursorPos() {
-	s.cursorPos = s.savedCursorPos
-	s.frontend.CursorMoved(s.cursorPos.X, s.cursorPos.Y)
+// softReset resets modes, margins and attributes without touching the screen
+// contents (DECSTR). Like xterm, it also turns off DECLRMM.
+func (s *gridScreen) softReset() {
+	s.autoWrap = false
+	s.insertMode = false
+	s.originMode = false
+	s.lrMarginMode = false
+	s.topMargin = 0
+	s.bottomMargin = s.size.Y - 1
+	s.leftMargin = 0
+	s.rightMargin = s.size.X - 1
+	s.link = nil
+	s.setStyle(NewStyle())
+}
+
+func (s *gridScreen) tabStops() tabStops {
+	return s.tabs
 }
 
 func (s *gridScreen) printScreen() {
@@ -385,6 +385,23 @@ func TestSplitSpan_SplitInWideCharacter(t *testing.T) {
 	}
 }
 
+func TestSplitSpan_AtWideCharacterStart(t *testing.T) {
+	// Split point is exactly at the start of a wide emoji
+	sp := Span{Text: "a🎉b", Width: 4, Style: NewStyle()}
+
+	left, right, brokeWide := splitSpan(sp, 1, TextReadModeGrapheme)
+
+	if brokeWide.Width != 0 {
+		t.Errorf("Expected no broken wide char when splitting at its start, got %q", brokeWide.Text)
+	}
+	if left.Text != "a" || left.Width != 1 {
+		t.Errorf("Expected left='a' width 1, got %q width %d", left.Text, left.Width)
+	}
+	if right.Text != "🎉b" || right.Width != 3 {
+		t.Errorf("Expected right='🎉b' width 3, got %q width %d", right.Text, right.Width)
+	}
+}
+
 func TestSplitSpan_WideCharAtEndOfSpan(t *testing.T) {
 	// Wide character is at the end of the span
 	sp := Span{Text: "hello🎉", Width: 7, Style: NewStyle()} // hello=5 cells, emoji=2 cells
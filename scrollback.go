@@ -0,0 +1,96 @@
+package termemu
+
+// DefaultScrollbackLines is the number of lines of history a new terminal keeps.
+const DefaultScrollbackLines = 1000
+
+// scrollback is a bounded history of lines that have scrolled off the top of
+// the main screen. Index 0 is the oldest line.
+type scrollback struct {
+	lines []Line
+	head  int // index of the oldest retained line in lines
+	bytes int // approximate memory used by retained lines
+
+	maxLines int // <= 0 means no history is kept
+	maxBytes int // <= 0 means unlimited
+}
+
+func newScrollback(maxLines int) *scrollback {
+	return &scrollback{maxLines: maxLines}
+}
+
+// Len returns the number of lines in the scrollback.
+func (sb *scrollback) Len() int {
+	return len(sb.lines) - sb.head
+}
+
+// Line returns line i, where 0 is the oldest line.
+func (sb *scrollback) Line(i int) Line {
+	return sb.lines[sb.head+i]
+}
+
+// Lines returns a copy of lines [start, end), clamped to the available range.
+func (sb *scrollback) Lines(start, end int) []Line {
+	n := sb.Len()
+	start = clamp(start, 0, n)
+	end = clamp(end, start, n)
+	out := make([]Line, end-start)
+	copy(out, sb.lines[sb.head+start:sb.head+end])
+	return out
+}
+
+func (sb *scrollback) push(l Line) {
+	if sb.maxLines <= 0 {
+		return
+	}
+	sb.lines = append(sb.lines, l)
+	sb.bytes += lineBytes(l)
+	sb.trim()
+}
+
+func (sb *scrollback) clear() {
+	sb.lines = nil
+	sb.head = 0
+	sb.bytes = 0
+}
+
+func (sb *scrollback) setMaxLines(n int) {
+	sb.maxLines = n
+	sb.trim()
+}
+
+func (sb *scrollback) setMaxBytes(n int) {
+	sb.maxBytes = n
+	sb.trim()
+}
+
+// trim drops the oldest lines until both limits are satisfied.
+func (sb *scrollback) trim() {
+	if sb.maxLines <= 0 {
+		sb.clear()
+		return
+	}
+	for sb.Len() > sb.maxLines || (sb.maxBytes > 0 && sb.bytes > sb.maxBytes && sb.Len() > 0) {
+		sb.bytes -= lineBytes(sb.lines[sb.head])
+		sb.lines[sb.head] = Line{}
+		sb.head++
+	}
+	// Compact once the dropped prefix dominates so memory stays bounded.
+	if sb.head > 0 && sb.head >= len(sb.lines)/2 {
+		n := copy(sb.lines, sb.lines[sb.head:])
+		clear(sb.lines[n:])
+		sb.lines = sb.lines[:n]
+		sb.head = 0
+	}
+}
+
+// spanOverhead approximates the fixed cost of one Span in memory.
+const spanOverhead = 40
+
+// lineBytes approximates the memory held by a line.
+func lineBytes(l Line) int {
+	n := 0
+	for _, sp := range l.Spans {
+		n += spanOverhead + len(sp.Text)
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+package termemu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func scrollbackText(t *terminal) []string {
+	var out []string
+	for _, l := range t.ScrollbackLines(0, t.ScrollbackLen()) {
+		out = append(out, strings.TrimRight(l.PlainTextString(), " "))
+	}
+	return out
+}
+
+func TestScrollback_LinefeedPushesLines(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 3)
+
+	if err := t1.testFeedTerminalInputFromBackend([]byte("one\ntwo\nthree\nfour\nfive"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+
+	got := scrollbackText(t1)
+	want := []string{"one", "two"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("scrollback = %q, want %q", got, want)
+	}
+	if line := strings.TrimRight(t1.Line(0), " "); line != "three" {
+		t.Fatalf("screen line 0 = %q, want %q", line, "three")
+	}
+}
+
+func TestScrollback_AltScreenDoesNotPush(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 3)
+
+	if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[?1049ha\nb\nc\nd\ne"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+	if n := t1.ScrollbackLen(); n != 0 {
+		t.Fatalf("expected no scrollback from alt screen, got %d lines", n)
+	}
+}
+
+func TestScrollback_MarginsDoNotPush(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 5)
+
+	if err := t1.testFeedTerminalInputFromBackend([]byte("\x1b[2;4r\x1b[4;1Ha\nb\nc"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+	if n := t1.ScrollbackLen(); n != 0 {
+		t.Fatalf("expected no scrollback with top margin set, got %d lines", n)
+	}
+}
+
+func TestScrollback_ScrollUpPushes(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 3)
+
+	if err := t1.testFeedTerminalInputFromBackend([]byte("a\nb\nc\x1b[2S"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+	got := scrollbackText(t1)
+	want := []string{"a", "b"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("scrollback = %q, want %q", got, want)
+	}
+}
+
+func TestScrollback_EraseSavedLines(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 2)
+
+	if err := t1.testFeedTerminalInputFromBackend([]byte("a\nb\nc\x1b[3J"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+	if n := t1.ScrollbackLen(); n != 0 {
+		t.Fatalf("expected CSI 3 J to clear scrollback, got %d lines", n)
+	}
+	if line := strings.TrimRight(t1.Line(1), " "); line != "c" {
+		t.Fatalf("CSI 3 J should not touch the screen, line 1 = %q", line)
+	}
+}
+
+func TestScrollback_Limits(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 1)
+	t1.SetScrollbackMaxLines(3)
+
+	for i := 0; i < 10; i++ {
+		if err := t1.testFeedTerminalInputFromBackend([]byte(fmt.Sprintf("%d\n", i)), TextReadModeRune); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := scrollbackText(t1)
+	want := []string{"7", "8", "9"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("scrollback = %q, want %q", got, want)
+	}
+
+	t1.SetScrollbackMaxBytes(2 * lineBytes(t1.ScrollbackLines(0, 1)[0]))
+	if n := t1.ScrollbackLen(); n != 2 {
+		t.Fatalf("expected byte limit to keep 2 lines, got %d", n)
+	}
+
+	t1.SetScrollbackMaxLines(0)
+	if n := t1.ScrollbackLen(); n != 0 {
+		t.Fatalf("expected disabled scrollback to be empty, got %d", n)
+	}
+}
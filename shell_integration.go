@@ -0,0 +1,255 @@
+package termemu
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MarkKind is the kind of an OSC 133 (FinalTerm) shell integration mark.
+type MarkKind uint8
+
+const (
+	// MarkPromptStart (OSC 133;A) is the start of a prompt.
+	MarkPromptStart MarkKind = iota + 1
+	// MarkCommandStart (OSC 133;B) is the end of the prompt, where the user
+	// starts typing the command.
+	MarkCommandStart
+	// MarkOutputStart (OSC 133;C) is the end of the command line, where the
+	// command's output starts.
+	MarkOutputStart
+	// MarkCommandEnd (OSC 133;D) is the end of the command's output.
+	MarkCommandEnd
+)
+
+func (k MarkKind) String() string {
+	switch k {
+	case MarkPromptStart:
+		return "A"
+	case MarkCommandStart:
+		return "B"
+	case MarkOutputStart:
+		return "C"
+	case MarkCommandEnd:
+		return "D"
+	}
+	return "MarkKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Mark is an OSC 133 mark, kept on the row the cursor was on when it arrived
+// and moved with that row as it scrolls into the scrollback or is reflowed.
+type Mark struct {
+	Kind MarkKind
+	// X is the cursor column when the mark arrived.
+	X int
+	// ExitCode is the status given with MarkCommandEnd, or -1.
+	ExitCode int
+}
+
+// Command is a command run by a shell that reports OSC 133 marks.
+type Command struct {
+	// Prompt is the text from MarkPromptStart to MarkCommandStart.
+	Prompt string
+	// Command is the command line, from MarkCommandStart to MarkOutputStart.
+	Command string
+	// Output holds the rows from MarkOutputStart to the end of the command.
+	Output []Line
+	// ExitCode is the status reported with OSC 133;D, or -1 if none was.
+	ExitCode int
+}
+
+// OutputText returns Output as text, with rows that were joined by soft wraps
+// kept on one line.
+func (c Command) OutputText() string {
+	return linesText(c.Output)
+}
+
+// CommandFrontend can be implemented by a Frontend to be told when a command
+// finishes. Like other Frontend methods, CommandFinished is called with the
+// terminal lock held.
+type CommandFrontend interface {
+	CommandFinished(c Command)
+}
+
+// maxCommands is the number of finished commands the terminal remembers.
+const maxCommands = 1000
+
+// Commands returns the finished commands, oldest first. A command is only
+// recorded if its OSC 133;C mark is still on the screen or in the scrollback
+// when it finishes. It locks the terminal.
+func (t *terminal) Commands() []Command {
+	var cmds []Command
+	t.WithLock(func() {
+		cmds = append([]Command(nil), t.commands...)
+	})
+	return cmds
+}
+
+// ClearCommands forgets the finished commands.
+func (t *terminal) ClearCommands() {
+	t.WithLock(func() {
+		t.commands = nil
+	})
+}
+
+// oscShellIntegration handles OSC 133 ; kind [; params].
+func (t *terminal) oscShellIntegration(arg string) bool {
+	kind, params, _ := strings.Cut(arg, ";")
+	m := Mark{X: t.screen().CursorPos().X, ExitCode: -1}
+	switch kind {
+	case "A":
+		m.Kind = MarkPromptStart
+		// A shell that does not send D finishes the command with the next prompt.
+		if t.commandRunning {
+			t.finishCommand(-1)
+		}
+	case "B":
+		m.Kind = MarkCommandStart
+	case "C":
+		m.Kind = MarkOutputStart
+		t.commandRunning = true
+	case "D":
+		m.Kind = MarkCommandEnd
+		code, _, _ := strings.Cut(params, ";")
+		if n, err := strconv.Atoi(code); err == nil {
+			m.ExitCode = n
+		}
+		if t.commandRunning {
+			t.finishCommand(m.ExitCode)
+		}
+	default:
+		debugPrintf(debugTodo, "TODO: Unhandled OSC 133 mark %q\n", arg)
+		return true
+	}
+	t.screen().addMark(t.screen().CursorPos().Y, m)
+	return true
+}
+
+// finishCommand records the command whose output ends at the cursor, found
+// from the marks before it, and tells the frontend.
+func (t *terminal) finishCommand(exitCode int) {
+	t.commandRunning = false
+
+	rows, row := t.markRows()
+	end := Pos{X: t.screen().CursorPos().X, Y: rows - 1}
+
+	// Walk back from the cursor to the C mark, then the B and A before it.
+	var found [MarkCommandEnd + 1]*Pos
+	want := MarkOutputStart
+search:
+	for y := rows - 1; y >= 0; y-- {
+		marks := row(y).Marks
+		for i := len(marks) - 1; i >= 0; i-- {
+			m := marks[i]
+			if m.Kind != want {
+				break search
+			}
+			found[want] = &Pos{X: m.X, Y: y}
+			if want == MarkPromptStart {
+				break search
+			}
+			want--
+		}
+	}
+	if found[MarkOutputStart] == nil {
+		return
+	}
+
+	c := Command{ExitCode: exitCode}
+	if a, b := found[MarkPromptStart], found[MarkCommandStart]; a != nil && b != nil {
+		c.Prompt = linesText(markRange(row, *a, *b, t.textReadMode))
+	}
+	if b := found[MarkCommandStart]; b != nil {
+		c.Command = linesText(markRange(row, *b, *found[MarkOutputStart], t.textReadMode))
+	}
+	c.Output = markRange(row, *found[MarkOutputStart], end, t.textReadMode)
+
+	t.commands = append(t.commands, c)
+	if len(t.commands) > maxCommands {
+		t.commands = append(t.commands[:0:0], t.commands[len(t.commands)-maxCommands:]...)
+	}
+	if f, ok := t.frontend.(CommandFrontend); ok {
+		f.CommandFinished(c)
+	}
+}
+
+// markRows returns the rows that may hold marks for the active screen: the
+// scrollback (on the main screen) followed by the screen rows down to the
+// cursor. row(i) returns row i of them.
+func (t *terminal) markRows() (n int, row func(i int) Line) {
+	s := t.screen()
+	sbLen := 0
+	if !t.onAltScreen && t.scrollback != nil {
+		sbLen = t.scrollback.Len()
+	}
+	w := s.Size().X
+	return sbLen + s.CursorPos().Y + 1, func(i int) Line {
+		if i < sbLen {
+			return t.scrollback.Line(i)
+		}
+		return s.StyledLine(0, w, i-sbLen)
+	}
+}
+
+// markRange returns the cells from start up to end, where Y indexes row. The
+// last row is left out if it would be empty.
+func markRange(row func(i int) Line, start, end Pos, mode TextReadMode) []Line {
+	var out []Line
+	for y := start.Y; y <= end.Y; y++ {
+		l := row(y)
+		x1, x2 := 0, l.Width
+		if y == start.Y {
+			x1 = min(start.X, l.Width)
+		}
+		if y == end.Y {
+			x2 = min(end.X, l.Width)
+			if x2 <= x1 {
+				break
+			}
+		}
+		x2 = max(x1, x2)
+		out = append(out, Line{
+			Spans:   sliceSpans(l.Spans, x1, x2-x1, mode),
+			Width:   x2 - x1,
+			Wrapped: l.Wrapped && x2 == l.Width,
+		})
+	}
+	return out
+}
+
+// linesText joins lines into text. Rows joined by soft wraps stay on one line
+// and trailing blanks are dropped from the others.
+func linesText(lines []Line) string {
+	var sb strings.Builder
+	for i, l := range lines {
+		text := l.PlainTextString()
+		if l.Wrapped {
+			sb.WriteString(text)
+			continue
+		}
+		sb.WriteString(strings.TrimRight(text, " "))
+		if i < len(lines)-1 {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// appendMark adds m to a row's marks without writing into an array that
+// another row may share after a scroll.
+func appendMark(marks []Mark, m Mark) []Mark {
+	return append(marks[:len(marks):len(marks)], m)
+}
+
+// sliceMarks returns the marks in cells [x, x+w) of a row of width rowWidth,
+// with X made relative to x. Marks past the end of the row belong to its last
+// cells.
+func sliceMarks(marks []Mark, x, w, rowWidth int) []Mark {
+	var out []Mark
+	for _, m := range marks {
+		if m.X >= x && (m.X < x+w || x+w == rowWidth) {
+			m.X -= x
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package termemu
+
+import (
+	"reflect"
+	"testing"
+)
+
+// commandFrontend records finished commands.
+type commandFrontend struct {
+	EmptyFrontend
+	finished []Command
+}
+
+func (f *commandFrontend) CommandFinished(c Command) {
+	f.finished = append(f.finished, c)
+}
+
+const (
+	markA = "\x1b]133;A\a"
+	markB = "\x1b]133;B\a"
+	markC = "\x1b]133;C\a"
+)
+
+func rowMarks(t1 *terminal, y int) []Mark {
+	w, _ := t1.Size()
+	return t1.screen().StyledLine(0, w, y).Marks
+}
+
+func TestOSC133_Command(t *testing.T) {
+	forEachScreen(t, func(t *testing.T, newFn func(Frontend) screen) {
+		t1 := makeTerminalWithScreens(newFn)
+		_ = t1.Resize(20, 6)
+
+		feed(t, t1, markA+"$ "+markB+"echo hi\r\n"+markC+"hi\r\nthere\r\n\x1b]133;D;2\a"+markA+"$ "+markB)
+
+		cmds := t1.Commands()
+		if len(cmds) != 1 {
+			t.Fatalf("got %d commands, want 1", len(cmds))
+		}
+		c := cmds[0]
+		if c.Prompt != "$" || c.Command != "echo hi" || c.ExitCode != 2 {
+			t.Errorf("command = %q %q exit %d", c.Prompt, c.Command, c.ExitCode)
+		}
+		if got := c.OutputText(); got != "hi\nthere" {
+			t.Errorf("output = %q", got)
+		}
+
+		wantMarks := [][]Mark{
+			{{Kind: MarkPromptStart, X: 0, ExitCode: -1}, {Kind: MarkCommandStart, X: 2, ExitCode: -1}},
+			{{Kind: MarkOutputStart, X: 0, ExitCode: -1}},
+			nil,
+			{{Kind: MarkCommandEnd, X: 0, ExitCode: 2}, {Kind: MarkPromptStart, X: 0, ExitCode: -1}, {Kind: MarkCommandStart, X: 2, ExitCode: -1}},
+		}
+		for y, want := range wantMarks {
+			if got := rowMarks(t1, y); !reflect.DeepEqual(got, want) {
+				t.Errorf("row %d marks = %v, want %v", y, got, want)
+			}
+		}
+	})
+}
+
+func TestOSC133_OutputInScrollback(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 3)
+
+	feed(t, t1, markA+"> "+markB+"seq\r\n"+markC+"1\r\n2\r\n3\r\n4\r\n5\r\n\x1b]133;D;0\a")
+
+	sb := t1.ScrollbackLines(0, t1.ScrollbackLen())
+	if len(sb) < 2 || len(sb[0].Marks) != 2 || len(sb[1].Marks) != 1 || sb[1].Marks[0].Kind != MarkOutputStart {
+		t.Fatalf("marks did not move into the scrollback: %+v", sb)
+	}
+	cmds := t1.Commands()
+	if len(cmds) != 1 {
+		t.Fatalf("got %d commands, want 1", len(cmds))
+	}
+	if cmds[0].Command != "seq" || cmds[0].OutputText() != "1\n2\n3\n4\n5" {
+		t.Errorf("command = %q, output %q", cmds[0].Command, cmds[0].OutputText())
+	}
+}
+
+func TestOSC133_FrontendAndMissingD(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	f := &commandFrontend{}
+	t1.SetFrontend(f)
+
+	// Without a D, the next prompt finishes the command.
+	feed(t, t1, markA+"$ "+markB+"false\r\n"+markC+markA+"$ ")
+	if len(f.finished) != 1 {
+		t.Fatalf("frontend saw %d commands, want 1", len(f.finished))
+	}
+	if c := f.finished[0]; c.Command != "false" || c.ExitCode != -1 || len(c.Output) != 0 {
+		t.Errorf("command = %+v", c)
+	}
+
+	// A D with no command running, as after an empty command line, records
+	// nothing.
+	feed(t, t1, markB+"\r\n\x1b]133;D\a")
+	if n := len(t1.Commands()); n != 1 {
+		t.Errorf("got %d commands, want 1", n)
+	}
+
+	t1.ClearCommands()
+	if n := len(t1.Commands()); n != 0 {
+		t.Errorf("got %d commands after ClearCommands", n)
+	}
+}
+
+func TestOSC133_MarksReflow(t *testing.T) {
+	_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(10, 4)
+	t1.screen().SetAutoWrap(true)
+
+	feed(t, t1, markA+"$ "+markB+"abcdefghij"+markC)
+	if got := rowMarks(t1, 1); len(got) != 1 || got[0] != (Mark{Kind: MarkOutputStart, X: 2, ExitCode: -1}) {
+		t.Fatalf("row 1 marks = %v", got)
+	}
+
+	_ = t1.Resize(20, 4)
+	want := []Mark{
+		{Kind: MarkPromptStart, X: 0, ExitCode: -1},
+		{Kind: MarkCommandStart, X: 2, ExitCode: -1},
+		{Kind: MarkOutputStart, X: 12, ExitCode: -1},
+	}
+	if got := rowMarks(t1, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("marks after widening = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,392 @@
+package termemu
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SnapshotVersion is the version of the Snapshot format written by this
+// package. Snapshots with a newer version are rejected.
+const SnapshotVersion = 1
+
+// snapshotMagic starts the binary encoding of a Snapshot.
+const snapshotMagic = "termemu-snapshot\n"
+
+// plainSnapshot has Snapshot's fields without its methods, so encoding it does
+// not recurse into MarshalBinary or UnmarshalJSON.
+type plainSnapshot Snapshot
+
+// Snapshot is the state of a terminal, as returned by Terminal.Snapshot and
+// restored by RestoreTerminal. It can be encoded as JSON or, more compactly,
+// with MarshalBinary.
+type Snapshot struct {
+	Version int
+
+	Width, Height int
+	// AltScreen is set if the alternate screen was active.
+	AltScreen bool
+	Main, Alt ScreenSnapshot
+	// Scrollback is the main screen's history, oldest first.
+	Scrollback []Line
+
+	// ViewFlags, ViewInts and ViewStrings are indexed by the ViewFlag,
+	// ViewInt and ViewString constants.
+	ViewFlags   []bool
+	ViewInts    []int
+	ViewStrings []string
+
+	// Palette holds the current palette colors and PaletteBase the ones
+	// they reset to, indexed like Terminal.PaletteColor.
+	Palette     []RGB
+	PaletteBase []RGB
+}
+
+// ScreenSnapshot is the state of the main or the alternate screen.
+type ScreenSnapshot struct {
+	Lines  []Line
+	Cursor Pos
+	Style  Style
+	Link   *Hyperlink `json:",omitempty"`
+
+	TopMargin, BottomMargin int
+	LeftMargin, RightMargin int
+
+	AutoWrap            bool
+	InsertMode          bool
+	OriginMode          bool
+	LeftRightMarginMode bool
+
+	// TabStops are the columns with a tab stop.
+	TabStops []int
+
+	SavedCursor SavedCursorSnapshot
+	Charsets    CharsetSnapshot
+	// KeyboardFlags and KeyboardStack are the kitty keyboard protocol flags
+	// and the flags pushed before them.
+	KeyboardFlags int
+	KeyboardStack []int `json:",omitempty"`
+}
+
+// SavedCursorSnapshot is the state saved by DECSC.
+type SavedCursorSnapshot struct {
+	Cursor     Pos
+	Style      Style
+	AutoWrap   bool
+	OriginMode bool
+	Charsets   CharsetSnapshot
+}
+
+// CharsetSnapshot is the G0-G3 character set designations and shifts.
+type CharsetSnapshot struct {
+	G      [4]int
+	GL     int
+	Single int
+}
+
+// Snapshot returns the terminal's current state. It locks the terminal.
+func (t *terminal) Snapshot() *Snapshot {
+	var snap *Snapshot
+	t.WithLock(func() {
+		size := t.mainScreen.Size()
+		snap = &Snapshot{
+			Version:     SnapshotVersion,
+			Width:       size.X,
+			Height:      size.Y,
+			AltScreen:   t.onAltScreen,
+			Main:        snapshotScreen(t.mainScreen, t.savedCursorMain, t.charsetMain, t.keyboardMain),
+			Alt:         snapshotScreen(t.altScreen, t.savedCursorAlt, t.charsetAlt, t.keyboardAlt),
+			ViewFlags:   append([]bool(nil), t.viewFlags...),
+			ViewInts:    append([]int(nil), t.viewInts...),
+			ViewStrings: append([]string(nil), t.viewStrings...),
+			Palette:     append([]RGB(nil), t.palette.current[:]...),
+			PaletteBase: append([]RGB(nil), t.palette.base[:]...),
+			Scrollback:  t.scrollback.Lines(0, t.scrollback.Len()),
+		}
+	})
+	return snap
+}
+
+func snapshotScreen(s screen, sc savedCursor, cs charsetState, km keyboardMode) ScreenSnapshot {
+	size := s.Size()
+	ss := ScreenSnapshot{
+		Lines:               s.StyledLines(Region{X2: size.X, Y2: size.Y}),
+		Cursor:              s.CursorPos(),
+		Style:               s.Style(),
+		Link:                s.Hyperlink(),
+		TopMargin:           s.TopMargin(),
+		BottomMargin:        s.BottomMargin(),
+		LeftMargin:          s.LeftMargin(),
+		RightMargin:         s.RightMargin(),
+		AutoWrap:            s.AutoWrap(),
+		InsertMode:          s.InsertMode(),
+		OriginMode:          s.OriginMode(),
+		LeftRightMarginMode: s.LeftRightMarginMode(),
+		SavedCursor: SavedCursorSnapshot{
+			Cursor:     sc.pos,
+			Style:      sc.style,
+			AutoWrap:   sc.autoWrap,
+			OriginMode: sc.originMode,
+			Charsets:   snapshotCharsets(sc.charsets),
+		},
+		Charsets:      snapshotCharsets(cs),
+		KeyboardFlags: km.flags,
+		KeyboardStack: append([]int(nil), km.stack...),
+	}
+	for x, set := range s.tabStops() {
+		if set {
+			ss.TabStops = append(ss.TabStops, x)
+		}
+	}
+	return ss
+}
+
+func snapshotCharsets(cs charsetState) CharsetSnapshot {
+	snap := CharsetSnapshot{GL: cs.gl, Single: cs.single}
+	for i, g := range cs.g {
+		snap.G[i] = int(g)
+	}
+	return snap
+}
+
+func (cs CharsetSnapshot) state() charsetState {
+	state := charsetState{gl: cs.GL, single: cs.Single}
+	for i, g := range cs.G {
+		state.g[i] = charset(g)
+	}
+	return state
+}
+
+// RestoreTerminal makes a new terminal in the state of snap and starts
+// reading from backend, like NewTerminal. The snapshot's size replaces any
+// WithSize option. backend may be nil to only look at the restored state.
+func RestoreTerminal(backend Backend, snap *Snapshot, opts ...Option) (Terminal, error) {
+	if err := snap.check(); err != nil {
+		return nil, err
+	}
+	c := defaultConfig()
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.width, c.height = snap.Width, snap.Height
+	t := newTerminalWithConfig(backend, &c)
+	t.WithLock(func() {
+		t.restore(snap)
+	})
+	t.startReadLoop()
+	return t, nil
+}
+
+// check reports whether snap can be restored.
+func (snap *Snapshot) check() error {
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return fmt.Errorf("snapshot: unsupported version %d", snap.Version)
+	}
+	if snap.Width <= 0 || snap.Height <= 0 {
+		return fmt.Errorf("snapshot: bad size %dx%d", snap.Width, snap.Height)
+	}
+	if err := snap.Main.check(snap.Width, snap.Height); err != nil {
+		return fmt.Errorf("snapshot: main screen: %w", err)
+	}
+	if err := snap.Alt.check(snap.Width, snap.Height); err != nil {
+		return fmt.Errorf("snapshot: alternate screen: %w", err)
+	}
+	for i, l := range snap.Scrollback {
+		if err := checkLine(l); err != nil {
+			return fmt.Errorf("snapshot: scrollback line %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// check reports whether ss fits a w x h screen.
+func (ss *ScreenSnapshot) check(w, h int) error {
+	if len(ss.Lines) > h {
+		return fmt.Errorf("%d lines on a screen %d high", len(ss.Lines), h)
+	}
+	for y, l := range ss.Lines {
+		if l.Width > w {
+			return fmt.Errorf("line %d is %d wide on a screen %d wide", y, l.Width, w)
+		}
+		if err := checkLine(l); err != nil {
+			return fmt.Errorf("line %d: %w", y, err)
+		}
+	}
+	if !ss.Cursor.inside(w, h) {
+		return fmt.Errorf("cursor %v outside %dx%d", ss.Cursor, w, h)
+	}
+	if !ss.SavedCursor.Cursor.inside(w, h) {
+		return fmt.Errorf("saved cursor %v outside %dx%d", ss.SavedCursor.Cursor, w, h)
+	}
+	if ss.TopMargin < 0 || ss.TopMargin > ss.BottomMargin || ss.BottomMargin >= h {
+		return fmt.Errorf("bad top/bottom margins %d, %d", ss.TopMargin, ss.BottomMargin)
+	}
+	if ss.LeftMargin < 0 || ss.LeftMargin > ss.RightMargin || ss.RightMargin >= w {
+		return fmt.Errorf("bad left/right margins %d, %d", ss.LeftMargin, ss.RightMargin)
+	}
+	for _, x := range ss.TabStops {
+		if x < 0 || x >= w {
+			return fmt.Errorf("tab stop %d outside width %d", x, w)
+		}
+	}
+	if err := ss.Charsets.check(); err != nil {
+		return err
+	}
+	if err := ss.SavedCursor.Charsets.check(); err != nil {
+		return fmt.Errorf("saved cursor: %w", err)
+	}
+	return nil
+}
+
+// checkLine reports whether the spans of l add up to its width.
+func checkLine(l Line) error {
+	width := 0
+	for _, sp := range l.Spans {
+		if sp.Width < 0 {
+			return fmt.Errorf("span width %d", sp.Width)
+		}
+		width += sp.Width
+	}
+	if width != l.Width {
+		return fmt.Errorf("spans are %d wide, line is %d", width, l.Width)
+	}
+	return nil
+}
+
+func (p Pos) inside(w, h int) bool {
+	return p.X >= 0 && p.X < w && p.Y >= 0 && p.Y < h
+}
+
+// check reports whether cs names known charsets and G sets.
+func (cs CharsetSnapshot) check() error {
+	for i, g := range cs.G {
+		if g < int(charsetASCII) || g > int(charsetUK) {
+			return fmt.Errorf("unknown charset %d in G%d", g, i)
+		}
+	}
+	if cs.GL < 0 || cs.GL >= len(cs.G) {
+		return fmt.Errorf("bad locking shift G%d", cs.GL)
+	}
+	if cs.Single < 0 || cs.Single >= len(cs.G) {
+		return fmt.Errorf("bad single shift G%d", cs.Single)
+	}
+	return nil
+}
+
+// restore applies snap to a new terminal of the same size. The caller must
+// hold the lock.
+func (t *terminal) restore(snap *Snapshot) {
+	for _, l := range snap.Scrollback {
+		t.scrollback.push(l)
+	}
+	restoreScreen(t.mainScreen, &snap.Main)
+	restoreScreen(t.altScreen, &snap.Alt)
+	t.savedCursorMain, t.charsetMain, t.keyboardMain = restoreTerminalState(&snap.Main)
+	t.savedCursorAlt, t.charsetAlt, t.keyboardAlt = restoreTerminalState(&snap.Alt)
+	t.onAltScreen = snap.AltScreen
+
+	for i, v := range snap.ViewFlags {
+		if i < len(t.viewFlags) {
+			t.setViewFlag(ViewFlag(i), v)
+		}
+	}
+	for i, v := range snap.ViewInts {
+		if i < len(t.viewInts) {
+			t.setViewInt(ViewInt(i), v)
+		}
+	}
+	for i, v := range snap.ViewStrings {
+		if i < len(t.viewStrings) {
+			t.setViewString(ViewString(i), v)
+		}
+	}
+	copy(t.palette.current[:], snap.Palette)
+	copy(t.palette.base[:], snap.PaletteBase)
+	if f, ok := t.frontend.(PaletteFrontend); ok {
+		for i, c := range t.palette.current {
+			if c != defaultPaletteColor(i) {
+				f.PaletteChanged(i, c)
+			}
+		}
+	}
+
+	t.regionChanged(Region{X2: snap.Width, Y2: snap.Height}, CRRedraw)
+	pos := t.screen().CursorPos()
+	t.frontend.CursorMoved(pos.X, pos.Y)
+}
+
+func restoreScreen(s screen, ss *ScreenSnapshot) {
+	for y, l := range ss.Lines {
+		if y < s.Size().Y {
+			s.setLine(y, l)
+		}
+	}
+	s.setStyle(ss.Style)
+	s.setHyperlink(ss.Link)
+	s.setScrollMarginTopBottom(ss.TopMargin, ss.BottomMargin)
+	s.setScrollMarginLeftRight(ss.LeftMargin, ss.RightMargin)
+	s.SetAutoWrap(ss.AutoWrap)
+	s.SetInsertMode(ss.InsertMode)
+	s.SetOriginMode(ss.OriginMode)
+	s.SetLeftRightMarginMode(ss.LeftRightMarginMode)
+	tabs := s.tabStops()
+	tabs.clearAll()
+	for _, x := range ss.TabStops {
+		tabs.set(x)
+	}
+	s.setCursorPos(ss.Cursor.X, ss.Cursor.Y)
+}
+
+func restoreTerminalState(ss *ScreenSnapshot) (savedCursor, charsetState, keyboardMode) {
+	sc := savedCursor{
+		pos:        ss.SavedCursor.Cursor,
+		style:      ss.SavedCursor.Style,
+		autoWrap:   ss.SavedCursor.AutoWrap,
+		originMode: ss.SavedCursor.OriginMode,
+		charsets:   ss.SavedCursor.Charsets.state(),
+	}
+	km := keyboardMode{flags: ss.KeyboardFlags, stack: append([]int(nil), ss.KeyboardStack...)}
+	return sc, ss.Charsets.state(), km
+}
+
+// MarshalBinary encodes the snapshot in a compact binary form.
+func (snap *Snapshot) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(snapshotMagic)
+	if err := gob.NewEncoder(&buf).Encode((*plainSnapshot)(snap)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a snapshot encoded by MarshalBinary.
+func (snap *Snapshot) UnmarshalBinary(data []byte) error {
+	rest, ok := bytes.CutPrefix(data, []byte(snapshotMagic))
+	if !ok {
+		return errors.New("snapshot: not a binary snapshot")
+	}
+	var s plainSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(rest)).Decode(&s); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	if err := (*Snapshot)(&s).check(); err != nil {
+		return err
+	}
+	*snap = Snapshot(s)
+	return nil
+}
+
+// UnmarshalJSON decodes a snapshot, rejecting versions it cannot restore.
+func (snap *Snapshot) UnmarshalJSON(data []byte) error {
+	var s plainSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if err := (*Snapshot)(&s).check(); err != nil {
+		return err
+	}
+	*snap = Snapshot(s)
+	return nil
+}
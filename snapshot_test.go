@@ -0,0 +1,145 @@
+package termemu
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// snapshotInput sets up styled text, a hyperlink, scrollback, margins, tabs,
+// saved cursors, charsets and keyboard flags on both screens, and leaves the
+// alternate screen active.
+const snapshotInput = "one\r\ntwo\r\nthree\r\n\x1b[1;31mred\x1b[0m \x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\ 中\r\n" +
+	"\x1b[3g\x1b[5G\x1bH\x1b[>1u\x1b[>5u\x1b)0\x1b[2;3r\x1b[2;2H\x1b7" +
+	"\x1b]0;title\x07\x1b[?2004h\x1b[?1000h\x1b]4;1;rgb:12/34/56\x07" +
+	"\x1b[?1049halt\x1b[4h\x1b[>2u\x1b[?6h\x1b[38;5;200m"
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	encodings := []struct {
+		name   string
+		encode func(*Snapshot) ([]byte, error)
+		decode func([]byte, *Snapshot) error
+	}{
+		{"binary", (*Snapshot).MarshalBinary, func(b []byte, s *Snapshot) error { return s.UnmarshalBinary(b) }},
+		{"json", func(s *Snapshot) ([]byte, error) { return json.Marshal(s) }, func(b []byte, s *Snapshot) error { return json.Unmarshal(b, s) }},
+	}
+	for _, st := range []ScreenType{SpanScreen, GridScreen} {
+		for _, enc := range encodings {
+			_, t1 := makeTerminalWithOptions(WithScreenType(st), WithSize(12, 4))
+			feed(t, t1, snapshotInput)
+			snap := t1.Snapshot()
+
+			data, err := enc.encode(snap)
+			if err != nil {
+				t.Fatalf("%v/%s: encode: %v", st, enc.name, err)
+			}
+			var decoded Snapshot
+			if err := enc.decode(data, &decoded); err != nil {
+				t.Fatalf("%v/%s: decode: %v", st, enc.name, err)
+			}
+			restored, err := RestoreTerminal(nil, &decoded, WithScreenType(st))
+			if err != nil {
+				t.Fatalf("%v/%s: restore: %v", st, enc.name, err)
+			}
+			t2 := restored.(*terminal)
+
+			if got := t2.Snapshot(); !reflect.DeepEqual(got, snap) {
+				t.Errorf("%v/%s: restored snapshot differs\n got %+v\nwant %+v", st, enc.name, got, snap)
+			}
+
+			// Both terminals should also behave the same from here on.
+			more := "X\x1b[?1049l\x1b8Y\tZ\x1b[<u\x1b[<u"
+			feed(t, t1, more)
+			feed(t, t2, more)
+			for y := 0; y < 4; y++ {
+				if got, want := t2.ANSILine(y), t1.ANSILine(y); got != want {
+					t.Errorf("%v/%s: line %d = %q, want %q", st, enc.name, y, got, want)
+				}
+			}
+			if got, want := t2.keyboardMain, t1.keyboardMain; !reflect.DeepEqual(got, want) {
+				t.Errorf("%v/%s: keyboard = %+v, want %+v", st, enc.name, got, want)
+			}
+		}
+	}
+}
+
+func TestSnapshotScrollback(t *testing.T) {
+	_, t1 := makeTerminalWithOptions(WithSize(10, 2))
+	feed(t, t1, "a\r\nb\r\n\x1b[1mc\x1b[0m\r\nd")
+	snap := t1.Snapshot()
+	if len(snap.Scrollback) != 2 {
+		t.Fatalf("scrollback has %d lines, want 2", len(snap.Scrollback))
+	}
+
+	restored, err := RestoreTerminal(nil, snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.Lock()
+	defer restored.Unlock()
+	lines := restored.ScrollbackLines(0, restored.ScrollbackLen())
+	if len(lines) != 2 || strings.TrimRight(lines[0].PlainTextString(), " ") != "a" || strings.TrimRight(lines[1].PlainTextString(), " ") != "b" {
+		t.Errorf("scrollback = %+v", lines)
+	}
+}
+
+func TestSnapshotBadInput(t *testing.T) {
+	var snap Snapshot
+	if err := snap.UnmarshalBinary([]byte("not a snapshot")); err == nil {
+		t.Error("UnmarshalBinary accepted a bad magic")
+	}
+	future := &Snapshot{Version: SnapshotVersion + 1, Width: 10, Height: 2}
+	data, err := future.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.UnmarshalBinary(data); err == nil {
+		t.Error("UnmarshalBinary accepted a newer version")
+	}
+	if err := json.Unmarshal([]byte(`{"Version": 99, "Width": 10, "Height": 2}`), &snap); err == nil {
+		t.Error("UnmarshalJSON accepted a newer version")
+	}
+	if _, err := RestoreTerminal(nil, &Snapshot{Version: SnapshotVersion}); err == nil {
+		t.Error("RestoreTerminal accepted a snapshot without a size")
+	}
+}
+
+func TestSnapshotMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(*Snapshot)
+	}{
+		{"cursor", func(s *Snapshot) { s.Main.Cursor = Pos{X: 100, Y: 100} }},
+		{"saved cursor", func(s *Snapshot) { s.Alt.SavedCursor.Cursor = Pos{X: -1} }},
+		{"margins", func(s *Snapshot) { s.Main.TopMargin, s.Main.BottomMargin = 5, 50 }},
+		{"reversed margins", func(s *Snapshot) { s.Main.TopMargin, s.Main.BottomMargin = 2, 1 }},
+		{"left/right margins", func(s *Snapshot) { s.Alt.RightMargin = 10 }},
+		{"tab stop", func(s *Snapshot) { s.Main.TabStops = append(s.Main.TabStops, 10) }},
+		{"charset", func(s *Snapshot) { s.Main.Charsets.G[0] = 99 }},
+		{"single shift", func(s *Snapshot) { s.Main.Charsets.Single = 9 }},
+		{"locking shift", func(s *Snapshot) { s.Alt.SavedCursor.Charsets.GL = -1 }},
+		{"line width", func(s *Snapshot) { s.Main.Lines[0].Width = 20; s.Main.Lines[0].Spans[0].Width += 10 }},
+		{"span widths", func(s *Snapshot) { s.Main.Lines[1].Spans[0].Width++ }},
+		{"too many lines", func(s *Snapshot) { s.Alt.Lines = append(s.Alt.Lines, s.Alt.Lines[0]) }},
+		{"scrollback", func(s *Snapshot) { s.Scrollback = []Line{{Spans: []Span{{Rune: 'x', Width: 2}}, Width: 1}} }},
+	}
+	for _, tt := range tests {
+		_, t1 := makeTerminalWithOptions(WithSize(10, 3))
+		feed(t, t1, "ab\r\ncd")
+		snap := t1.Snapshot()
+		tt.corrupt(snap)
+
+		if _, err := RestoreTerminal(nil, snap); err == nil {
+			t.Errorf("%s: RestoreTerminal accepted the snapshot", tt.name)
+		}
+		data, err := json.Marshal(snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Snapshot
+		if err := json.Unmarshal(data, &decoded); err == nil {
+			t.Errorf("%s: UnmarshalJSON accepted the snapshot", tt.name)
+		}
+	}
+}
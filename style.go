@@ -1,6 +1,8 @@
 package termemu
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"strconv"
 )
@@ -22,8 +24,24 @@ const (
 	ModeFramed
 	ModeEncircled
 	ModeRapidBlink
+	ModeCurlyUnderline
+	ModeDottedUnderline
+	ModeDashedUnderline
 )
 
+// underlineModes are the underline styles; at most one of them is set.
+const underlineModes = ModeUnderline | ModeDoubleUnderline | ModeCurlyUnderline | ModeDottedUnderline | ModeDashedUnderline
+
+// underlineStyles maps the SGR 4:n sub-parameter to its underline mode.
+var underlineStyles = [...]Mode{
+	0: 0,
+	1: ModeUnderline,
+	2: ModeDoubleUnderline,
+	3: ModeCurlyUnderline,
+	4: ModeDottedUnderline,
+	5: ModeDashedUnderline,
+}
+
 // ColorComponent specifies which color component of a Style to modify
 type ColorComponent uint8
 
@@ -59,24 +77,28 @@ const (
 )
 
 // Mode to SGR code mappings for output generation
-var modeToSGRCode = map[Mode]int{
-	ModeBold:            1,
-	ModeDim:             2,
-	ModeItalic:          3,
-	ModeUnderline:       4,
-	ModeBlink:           5,
-	ModeRapidBlink:      6,
-	ModeReverse:         7,
-	ModeInvisible:       8,
-	ModeStrike:          9,
-	ModeDoubleUnderline: 21,
-	ModeFramed:          51,
-	ModeEncircled:       52,
-	ModeOverline:        53,
+var modeToSGRCode = map[Mode]string{
+	ModeBold:            "1",
+	ModeDim:             "2",
+	ModeItalic:          "3",
+	ModeUnderline:       "4",
+	ModeBlink:           "5",
+	ModeRapidBlink:      "6",
+	ModeReverse:         "7",
+	ModeInvisible:       "8",
+	ModeStrike:          "9",
+	ModeDoubleUnderline: "21",
+	ModeFramed:          "51",
+	ModeEncircled:       "52",
+	ModeOverline:        "53",
+	ModeCurlyUnderline:  "4:3",
+	ModeDottedUnderline: "4:4",
+	ModeDashedUnderline: "4:5",
 }
 
 // Style represents complete text styling including colors and text modes.
-// Modes are distributed across the high bytes (bits 24-30) of fg, bg, and underlineColor.
+// Modes are distributed across the high bytes (bits 24-30) of fg, bg, and underlineColor:
+// fg holds modes 0-6, bg modes 7-12 and underlineColor modes 13-15.
 // Color data uses bits 0-23, and bit 31 is the color type flag (256-color vs RGB).
 type Style struct {
 	fg             uint32 // Foreground color + mode bits
@@ -101,6 +123,7 @@ func (s *Style) modeBits() Mode {
 	// Extract bits 24-30 from each color value
 	fgModes := (s.fg >> modeBitsShift) & 0x7F
 	bgModes := (s.bg >> modeBitsShift) & 0x7F
+	ulModes := (s.underlineColor >> modeBitsShift) & 0x7F
 
 	// Combine into a single Mode value
 	// FG stores modes 0-6, BG stores modes 7-12 (but shifted down)
@@ -120,6 +143,13 @@ func (s *Style) modeBits() Mode {
 		}
 	}
 
+	// Map underline color modes (bits 13-15 in Mode enum)
+	for i := uint(0); i < 3; i++ {
+		if (ulModes & (1 << i)) != 0 {
+			combined |= Mode(1) << (i + 13)
+		}
+	}
+
 	return combined
 }
 
@@ -227,36 +257,45 @@ func (s *Style) GetColor(component ColorComponent) (int, bool, error) {
 	return value, isRGB, nil
 }
 
+// modeStorage splits modes into the mode bits stored in fg, bg and
+// underlineColor.
+func modeStorage(m Mode) (fg, bg, ul uint32) {
+	// Modes 0-6 go in FG, 7-12 in BG and 13-15 in the underline color,
+	// each shifted down to fit in bits 24-30.
+	fg = uint32(m&0x7F) << modeBitsShift
+	bg = uint32((m>>7)&0x3F) << modeBitsShift
+	ul = uint32((m>>13)&0x7) << modeBitsShift
+	return fg, bg, ul
+}
+
 // SetMode sets one or more text modes
 func (s *Style) SetMode(modes ...Mode) {
 	for _, m := range modes {
-		// Determine which color value should store this mode
-		if m < (1 << 7) {
-			// Modes 0-6 go in FG (bits 24-30)
-			s.fg |= uint32(m) << modeBitsShift
-		} else if m < (1 << 13) {
-			// Modes 7-12 go in BG (bits 24-29)
-			// Shift down by 7 to fit in the BG mode space
-			bgMode := m >> 7
-			s.bg |= uint32(bgMode) << modeBitsShift
-		}
+		fg, bg, ul := modeStorage(m)
+		s.fg |= fg
+		s.bg |= bg
+		s.underlineColor |= ul
 	}
 }
 
 // ResetMode clears one or more text modes
 func (s *Style) ResetMode(modes ...Mode) {
 	for _, m := range modes {
-		if m < (1 << 7) {
-			// Modes 0-6 in FG
-			s.fg &= ^(uint32(m) << modeBitsShift)
-		} else if m < (1 << 13) {
-			// Modes 7-12 in BG
-			bgMode := m >> 7
-			s.bg &= ^(uint32(bgMode) << modeBitsShift)
-		}
+		fg, bg, ul := modeStorage(m)
+		s.fg &^= fg
+		s.bg &^= bg
+		s.underlineColor &^= ul
 	}
 }
 
+// SetUnderlineMode replaces the underline style with mode, which is one of
+// ModeUnderline, ModeDoubleUnderline, ModeCurlyUnderline, ModeDottedUnderline,
+// ModeDashedUnderline, or 0 for no underline.
+func (s *Style) SetUnderlineMode(mode Mode) {
+	s.ResetMode(underlineModes)
+	s.SetMode(mode & underlineModes)
+}
+
 // TestMode checks if a mode is set
 func (s *Style) TestMode(mode Mode) bool {
 	return s.modeBits()&mode != 0
@@ -336,6 +375,23 @@ func ansiEscapeColor(c uint32, param byte) []byte {
 	return seq
 }
 
+// ansiEscapeUnderlineColor generates the SGR 58/59 sequence for an underline
+// color, using the colon-separated ISO 8613-6 form.
+func ansiEscapeUnderlineColor(c uint32) []byte {
+	c &= ^modeBitsMask
+	switch {
+	case c&colorTypeMask == colorTypeMask:
+		rgb := c & maskRGBcolor
+		return fmt.Appendf(nil, "\x1b[58:2::%d:%d:%dm", (rgb>>16)&0xff, (rgb>>8)&0xff, rgb&0xff)
+	case c&colBright == colBright:
+		return fmt.Appendf(nil, "\x1b[58:5:%dm", 8+int(c&maskBrightIdx))
+	case c == colDefault:
+		return []byte("\x1b[59m")
+	default:
+		return fmt.Appendf(nil, "\x1b[58:5:%dm", int(c&mask256color))
+	}
+}
+
 // ANSIEscape generates a complete ANSI escape sequence for this style
 func (s Style) ANSIEscape() []byte {
 	var seq []byte
@@ -357,8 +413,9 @@ func (s Style) ANSIEscapeFrom(prev Style) []byte {
 	modesChanged := s.modeBits() != prev.modeBits()
 	fgChanged := (s.fg &^ modeBitsMask) != (prev.fg &^ modeBitsMask)
 	bgChanged := (s.bg &^ modeBitsMask) != (prev.bg &^ modeBitsMask)
+	ulChanged := (s.underlineColor &^ modeBitsMask) != (prev.underlineColor &^ modeBitsMask)
 
-	if !modesChanged && !fgChanged && !bgChanged {
+	if !modesChanged && !fgChanged && !bgChanged && !ulChanged {
 		return nil
 	}
 
@@ -369,13 +426,14 @@ func (s Style) ANSIEscapeFrom(prev Style) []byte {
 		for _, mode := range s.Modes() {
 			if code, ok := modeToSGRCode[mode]; ok {
 				seq = append(seq, ESC, '[')
-				seq = append(seq, []byte(strconv.Itoa(code))...)
+				seq = append(seq, code...)
 				seq = append(seq, 'm')
 			}
 		}
 		// If modes changed, we also need to re-emit colors since we reset
 		fgChanged = true
 		bgChanged = true
+		ulChanged = s.underlineColor&^modeBitsMask != colDefault
 	}
 
 	// Only emit color changes if they changed
@@ -385,6 +443,44 @@ func (s Style) ANSIEscapeFrom(prev Style) []byte {
 	if bgChanged {
 		seq = append(seq, ansiEscapeColor(s.bg, '4')...)
 	}
+	if ulChanged {
+		seq = append(seq, ansiEscapeUnderlineColor(s.underlineColor)...)
+	}
 
 	return seq
 }
+
+// MarshalJSON encodes the style as its three packed color and mode values,
+// for snapshots.
+func (s Style) MarshalJSON() ([]byte, error) {
+	return json.Marshal([3]uint32{s.fg, s.bg, s.underlineColor})
+}
+
+func (s *Style) UnmarshalJSON(data []byte) error {
+	var v [3]uint32
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	s.fg, s.bg, s.underlineColor = v[0], v[1], v[2]
+	return nil
+}
+
+// MarshalBinary encodes the style like MarshalJSON, as 12 little-endian
+// bytes.
+func (s Style) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 12)
+	b = binary.LittleEndian.AppendUint32(b, s.fg)
+	b = binary.LittleEndian.AppendUint32(b, s.bg)
+	b = binary.LittleEndian.AppendUint32(b, s.underlineColor)
+	return b, nil
+}
+
+func (s *Style) UnmarshalBinary(data []byte) error {
+	if len(data) != 12 {
+		return fmt.Errorf("style: want 12 bytes, got %d", len(data))
+	}
+	s.fg = binary.LittleEndian.Uint32(data)
+	s.bg = binary.LittleEndian.Uint32(data[4:])
+	s.underlineColor = binary.LittleEndian.Uint32(data[8:])
+	return nil
+}
@@ -0,0 +1,76 @@
+package termemu
+
+import "testing"
+
+func TestStyle_ModesAcrossStorage(t *testing.T) {
+	s := NewStyle()
+	s.SetMode(ModeBold, ModeFramed, ModeCurlyUnderline, ModeDashedUnderline)
+	for _, m := range []Mode{ModeBold, ModeFramed, ModeCurlyUnderline, ModeDashedUnderline} {
+		if !s.TestMode(m) {
+			t.Errorf("mode %v not set", m)
+		}
+	}
+
+	s.ResetMode(ModeBold | ModeCurlyUnderline)
+	if s.TestMode(ModeBold) || s.TestMode(ModeCurlyUnderline) {
+		t.Errorf("combined ResetMode left modes set: %v", s.Modes())
+	}
+	if !s.TestMode(ModeFramed) || !s.TestMode(ModeDashedUnderline) {
+		t.Errorf("ResetMode cleared too much: %v", s.Modes())
+	}
+
+	_ = s.SetColorRGB(ComponentUnderline, 1, 2, 3)
+	if !s.TestMode(ModeDashedUnderline) {
+		t.Errorf("setting the underline color cleared the underline mode")
+	}
+}
+
+func TestStyle_SetUnderlineMode(t *testing.T) {
+	s := NewStyle()
+	s.SetMode(ModeItalic)
+	s.SetUnderlineMode(ModeDoubleUnderline)
+	s.SetUnderlineMode(ModeDottedUnderline)
+	if got := s.Modes(); len(got) != 2 || !s.TestMode(ModeItalic) || !s.TestMode(ModeDottedUnderline) {
+		t.Errorf("modes = %v, want italic and dotted underline", got)
+	}
+	s.SetUnderlineMode(0)
+	if s.TestMode(underlineModes) {
+		t.Errorf("expected no underline, got %v", s.Modes())
+	}
+}
+
+func TestStyle_ANSIEscapeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		sgr  string
+	}{
+		{"curly underline", "[4:3m"},
+		{"dotted underline", "[4:4m"},
+		{"dashed underline", "[4:5m"},
+		{"double underline", "[4:2m"},
+		{"underline RGB color", "[4;58:2::10:20:30m"},
+		{"underline 256 color", "[4:3;58:5:200m"},
+		{"underline color semicolons", "[58;2;1;2;3m"},
+		{"fg RGB with colorspace", "[38:2:0:255:128:0m"},
+		{"bg 256 colon", "[1;48:5:33m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+			t1.mustHandleCommand(t, tt.sgr)
+			want := t1.screen().Style()
+			if want == NewStyle() {
+				t.Fatalf("SGR %q did not change the style", tt.sgr)
+			}
+
+			_, t2, _ := MakeTerminalWithMock(TextReadModeRune)
+			if err := t2.testFeedTerminalInputFromBackend(want.ANSIEscape(), TextReadModeRune); err != nil {
+				t.Fatal(err)
+			}
+			if got := t2.screen().Style(); got != want {
+				t.Errorf("round trip of %q via %q = %#v, want %#v", tt.sgr, want.ANSIEscape(), got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package termemu
+
+import "time"
+
+// DefaultSyncTimeout is how long synchronized output (mode 2026) may hold back
+// notifications before they are delivered anyway.
+const DefaultSyncTimeout = 150 * time.Millisecond
+
+// syncFrontend stands in for the frontend on both screens while synchronized
+// output is on. It merges region changes and keeps the last cursor position
+// until flush; other notifications pass straight through.
+type syncFrontend struct {
+	Frontend
+
+	region Region
+	reason ChangeReason
+	cursor Pos
+	moved  bool
+
+	timer Timer
+}
+
+func (s *syncFrontend) RegionChanged(r Region, cr ChangeReason) {
+	if r.Empty() {
+		return
+	}
+	if s.region.Empty() {
+		s.reason = cr
+	} else if s.reason != cr {
+		s.reason = CRRedraw
+	}
+	s.region = s.region.Union(r)
+}
+
+func (s *syncFrontend) CursorMoved(x, y int) {
+	s.cursor = Pos{X: x, Y: y}
+	s.moved = true
+}
+
+// flush delivers the held notifications as one region change and one cursor
+// move.
+func (s *syncFrontend) flush() {
+	if !s.region.Empty() {
+		s.Frontend.RegionChanged(s.region, s.reason)
+	}
+	if s.moved {
+		s.Frontend.CursorMoved(s.cursor.X, s.cursor.Y)
+	}
+	s.region = Region{}
+	s.moved = false
+}
+
+// setSyncOutput turns synchronized output on or off. While it is on, region
+// and cursor notifications are held until it is turned off or the timeout
+// expires.
+func (t *terminal) setSyncOutput(on bool) {
+	if !on {
+		if t.sync != nil {
+			t.endSync()
+		}
+		return
+	}
+	if t.sync != nil {
+		return
+	}
+	s := &syncFrontend{Frontend: t.notifyTarget()}
+	s.timer = t.clock.AfterFunc(t.syncTimeout, func() {
+		t.WithLock(func() {
+			if t.sync == s {
+				debugPrintln(debugErrors, "synchronized output timed out")
+				t.endSync()
+			}
+		})
+	})
+	t.sync = s
+	t.attachScreens()
+}
+
+// endSync delivers the notifications held by synchronized output and
+// reattaches the screens.
+func (t *terminal) endSync() {
+	s := t.sync
+	t.sync = nil
+	s.timer.Stop()
+	t.attachScreens()
+	s.flush()
+}
+
+// regionChanged reports a change to the whole terminal, such as a screen
+// switch, holding it back like the screens' changes during synchronized
+// output.
+func (t *terminal) regionChanged(r Region, cr ChangeReason) {
+	if t.sync != nil {
+		t.sync.RegionChanged(r, cr)
+		return
+	}
+	t.notifyTarget().RegionChanged(r, cr)
+}
@@ -0,0 +1,82 @@
+package termemu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncOutput_BatchesNotifications(t *testing.T) {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+	_ = t1.Resize(20, 5)
+
+	t1.mustHandleCommand(t, "[?2026h")
+	mf.Regions = nil
+	moves := mf.CursorMovedCount
+
+	feed(t, t1, "\x1b[2;3Habc\x1b[4;10Hxy")
+	if n := len(mf.Regions); n != 0 {
+		t.Fatalf("got %d region changes during synchronized output", n)
+	}
+	if mf.CursorMovedCount != moves {
+		t.Fatalf("cursor moves were not held back")
+	}
+
+	t1.mustHandleCommand(t, "[?2026l")
+	if len(mf.Regions) != 1 {
+		t.Fatalf("got %d region changes after the frame, want 1: %v", len(mf.Regions), mf.Regions)
+	}
+	if got, want := mf.Regions[0].R, (Region{X: 2, Y: 1, X2: 11, Y2: 4}); got != want {
+		t.Errorf("batched region = %v, want %v", got, want)
+	}
+	if mf.CursorMovedCount != moves+1 || mf.CursorX != 11 || mf.CursorY != 3 {
+		t.Errorf("cursor = %d,%d after %d moves", mf.CursorX, mf.CursorY, mf.CursorMovedCount-moves)
+	}
+
+	// Nothing is held back once the mode is off.
+	feed(t, t1, "z")
+	if len(mf.Regions) != 2 {
+		t.Errorf("got %d region changes, want 2", len(mf.Regions))
+	}
+}
+
+func TestSyncOutput_DECRQM(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	t1.mustHandleCommand(t, "[?2026$p")
+	if got, want := readReply(t, r), "\x1b[?2026;2$y"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	t1.mustHandleCommand(t, "[?2026h")
+	t1.mustHandleCommand(t, "[?2026$p")
+	if got, want := readReply(t, r), "\x1b[?2026;1$y"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	t1.mustHandleCommand(t, "c")
+	t1.mustHandleCommand(t, "[?2026$p")
+	if got, want := readReply(t, r), "\x1b[?2026;2$y"; got != want {
+		t.Errorf("reply after RIS = %q, want %q", got, want)
+	}
+}
+
+func TestSyncOutput_Timeout(t *testing.T) {
+	_, t1, mf := MakeTerminalWithMock(TextReadModeRune)
+	t1.syncTimeout = 10 * time.Millisecond
+
+	t1.mustHandleCommand(t, "[?2026h")
+	mf.mu.Lock()
+	mf.Regions = nil
+	mf.mu.Unlock()
+	feed(t, t1, "abc")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for mf.RegionCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("held notifications were not delivered after the timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t1.Lock()
+	defer t1.Unlock()
+	if t1.sync != nil {
+		t.Errorf("synchronized output still on after the timeout")
+	}
+}
@@ -0,0 +1,78 @@
+package termemu
+
+// defaultTabWidth is the spacing of the tab stops set on a fresh screen.
+const defaultTabWidth = 8
+
+// tabStops is the tab stop table of a screen, one entry per column.
+type tabStops []bool
+
+// resize grows or shrinks the table to w columns. Existing stops are kept and
+// new columns get the default stops.
+func (ts *tabStops) resize(w int) {
+	old := *ts
+	n := make(tabStops, w)
+	copy(n, old)
+	for x := len(old); x < w; x++ {
+		n[x] = x > 0 && x%defaultTabWidth == 0
+	}
+	*ts = n
+}
+
+// reset restores the default stops.
+func (ts tabStops) reset() {
+	for x := range ts {
+		ts[x] = x > 0 && x%defaultTabWidth == 0
+	}
+}
+
+func (ts tabStops) set(x int) {
+	if x >= 0 && x < len(ts) {
+		ts[x] = true
+	}
+}
+
+func (ts tabStops) clear(x int) {
+	if x >= 0 && x < len(ts) {
+		ts[x] = false
+	}
+}
+
+func (ts tabStops) clearAll() {
+	for x := range ts {
+		ts[x] = false
+	}
+}
+
+// next returns the column of the n-th tab stop after x, or the last column
+// if there are not that many.
+func (ts tabStops) next(x, n int) int {
+	for ; n > 0 && x < len(ts)-1; n-- {
+		for x++; x < len(ts)-1 && !ts[x]; x++ {
+		}
+	}
+	return x
+}
+
+// prev returns the column of the n-th tab stop before x, or column 0 if there
+// are not that many.
+func (ts tabStops) prev(x, n int) int {
+	if x >= len(ts) {
+		x = len(ts) - 1
+	}
+	for ; n > 0 && x > 0; n-- {
+		for x--; x > 0 && !ts[x]; x-- {
+		}
+	}
+	return x
+}
+
+// columns returns the columns that have a tab stop.
+func (ts tabStops) columns() []int {
+	var cols []int
+	for x, ok := range ts {
+		if ok {
+			cols = append(cols, x)
+		}
+	}
+	return cols
+}
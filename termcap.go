@@ -0,0 +1,95 @@
+package termemu
+
+import (
+	"encoding/hex"
+	"maps"
+	"strings"
+)
+
+// termName is the terminfo name termemu emulates. PTYBackend sets TERM to it.
+const termName = "xterm-256color"
+
+// defaultCapabilities are the terminfo capabilities reported by XTGETTCAP.
+// They describe what termemu emulates beyond plain xterm-256color. Boolean
+// capabilities have an empty value.
+var defaultCapabilities = map[string]string{
+	"TN":     termName,
+	"name":   termName,
+	"Co":     "256",
+	"colors": "256",
+	"RGB":    "",
+	"Tc":     "",
+	// Styled and colored underlines (SGR 4:n and 58).
+	"Smulx":  "\x1b[4:%p1%dm",
+	"Setulc": "\x1b[58:2::%p1%{65536}%/%d:%p1%{256}%/%{255}%&%d:%p1%{255}%&%d%;m",
+	// OSC 52 clipboard.
+	"Ms": "\x1b]52;%p1%s;%p2%s\a",
+	// DECSCUSR cursor shape.
+	"Ss": "\x1b[%p1%d q",
+	"Se": "\x1b[0 q",
+	// Synchronized output (mode 2026).
+	"Sync": "\x1b[?2026%?%p1%{1}%-%tl%eh%;",
+	// Truecolor SGR.
+	"setrgbf": "\x1b[38;2;%p1%d;%p2%d;%p3%dm",
+	"setrgbb": "\x1b[48;2;%p1%d;%p2%d;%p3%dm",
+}
+
+// SetCapability sets the value XTGETTCAP reports for a terminfo capability,
+// replacing the built-in one. Use an empty value for a boolean capability.
+func (t *terminal) SetCapability(name, value string) {
+	t.WithLock(func() {
+		t.ownCapabilities()[name] = value
+	})
+}
+
+// RemoveCapability makes XTGETTCAP report a capability as unknown.
+func (t *terminal) RemoveCapability(name string) {
+	t.WithLock(func() {
+		delete(t.ownCapabilities(), name)
+	})
+}
+
+// ownCapabilities returns the terminal's capability table, copying the
+// defaults the first time it is changed.
+func (t *terminal) ownCapabilities() map[string]string {
+	if t.capabilities == nil {
+		t.capabilities = maps.Clone(defaultCapabilities)
+	}
+	return t.capabilities
+}
+
+// capability looks up a capability for XTGETTCAP.
+func (t *terminal) capability(name string) (string, bool) {
+	caps := t.capabilities
+	if caps == nil {
+		caps = defaultCapabilities
+	}
+	v, ok := caps[name]
+	return v, ok
+}
+
+// dcsRequestTermcap is XTGETTCAP (DCS + q Pt ST), where Pt is a list of
+// hex-encoded capability names separated by ';'. Each name gets its own reply:
+// DCS 1 + r name=value ST, hex-encoded, or DCS 0 + r ST if it is unknown.
+func (t *terminal) dcsRequestTermcap(seq *sequence) bool {
+	for _, hexName := range strings.Split(string(seq.data), ";") {
+		name, err := hex.DecodeString(hexName)
+		if err != nil {
+			debugPrintf(debugErrors, "XTGETTCAP name is not hex: %q\n", hexName)
+			t.replyf("\x1bP0+r\x1b\\")
+			continue
+		}
+		value, ok := t.capability(string(name))
+		if !ok {
+			debugPrintf(debugTodo, "TODO: Unknown XTGETTCAP capability %q\n", name)
+			t.replyf("\x1bP0+r\x1b\\")
+			continue
+		}
+		reply := strings.ToUpper(hex.EncodeToString(name))
+		if value != "" {
+			reply += "=" + strings.ToUpper(hex.EncodeToString([]byte(value)))
+		}
+		t.replyf("\x1bP1+r%s\x1b\\", reply)
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package termemu
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func hexCap(s string) string {
+	return strings.ToUpper(hex.EncodeToString([]byte(s)))
+}
+
+func TestXTGETTCAP(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{hexCap("TN"), "\x1bP1+r" + hexCap("TN") + "=" + hexCap("xterm-256color") + "\x1b\\"},
+		{hexCap("Co"), "\x1bP1+r" + hexCap("Co") + "=" + hexCap("256") + "\x1b\\"},
+		{hexCap("RGB"), "\x1bP1+r" + hexCap("RGB") + "\x1b\\"},
+		{strings.ToLower(hexCap("Smulx")), "\x1bP1+r" + hexCap("Smulx") + "=" + hexCap("\x1b[4:%p1%dm") + "\x1b\\"},
+		{hexCap("nope"), "\x1bP0+r\x1b\\"},
+		{"zz", "\x1bP0+r\x1b\\"},
+		{hexCap("Co") + ";" + hexCap("nope"), "\x1bP1+r" + hexCap("Co") + "=" + hexCap("256") + "\x1b\\\x1bP0+r\x1b\\"},
+	}
+	for _, tt := range tests {
+		t1.mustHandleCommand(t, "P+q"+tt.query+"\x1b\\")
+		if got := readReply(t, r); got != tt.want {
+			t.Errorf("XTGETTCAP %q replied %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestXTGETTCAP_Overrides(t *testing.T) {
+	r, t1, _ := MakeTerminalWithMock(TextReadModeRune)
+	t1.SetCapability("TN", "termemu")
+	t1.RemoveCapability("Ms")
+
+	t1.mustHandleCommand(t, "P+q"+hexCap("TN")+"\x1b\\")
+	if got, want := readReply(t, r), "\x1bP1+r"+hexCap("TN")+"="+hexCap("termemu")+"\x1b\\"; got != want {
+		t.Errorf("TN replied %q, want %q", got, want)
+	}
+	t1.mustHandleCommand(t, "P+q"+hexCap("Ms")+"\x1b\\")
+	if got, want := readReply(t, r), "\x1bP0+r\x1b\\"; got != want {
+		t.Errorf("removed Ms replied %q, want %q", got, want)
+	}
+	if defaultCapabilities["TN"] != termName || defaultCapabilities["Ms"] == "" {
+		t.Errorf("overrides changed the built-in table")
+	}
+}
@@ -2,14 +2,27 @@ package termemu
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
+// Terminal is a terminal emulator connected to a Backend.
+//
+// Methods that change the terminal, such as Resize, ClearScrollback or
+// SetPaletteColor, lock it themselves, so they must not be called inside
+// WithLock or from a Frontend method. Methods that read the screen or the
+// scrollback (Size, Line, ANSILine, StyledLine, StyledLines, ScrollbackLen,
+// ScrollbackLines and TabStops) do not, and the caller must hold the lock,
+// as it already does in a Frontend method or a WaitFor condition. Other
+// methods that return state, such as PaletteColor, Commands and Snapshot,
+// return a copy and lock the terminal themselves.
 type Terminal interface {
 	SetFrontend(f Frontend)
 
@@ -19,6 +32,15 @@ type Terminal interface {
 
 	Write(b []byte) (int, error)
 	SendKey(KeyEvent) (int, error)
+	// SendMouse reports a mouse event at 0-based cell x, y if the program
+	// enabled mouse reporting.
+	SendMouse(btn MouseBtn, press bool, mods MouseFlag, x, y int) error
+	// Paste sends pasted text, bracketed if the program enabled bracketed
+	// paste (mode 2004).
+	Paste(text string) (int, error)
+	// SendFocus reports focus gain or loss if the program enabled focus
+	// events (mode 1004).
+	SendFocus(focused bool) error
 	Size() (int, int)
 	Resize(int, int) error
 	Line(int) string
@@ -26,6 +48,85 @@ type Terminal interface {
 	StyledLine(x, w, y int) Line
 	StyledLines(r Region) []Line
 
+	// ScrollbackLen returns the number of lines in the main screen's history.
+	ScrollbackLen() int
+	// ScrollbackLines returns history lines [start, end), where 0 is the oldest.
+	ScrollbackLines(start, end int) []Line
+	// ClearScrollback discards all history lines.
+	ClearScrollback()
+	// SetScrollbackMaxLines limits the history to n lines. n <= 0 disables it.
+	SetScrollbackMaxLines(n int)
+	// SetScrollbackMaxBytes limits the approximate memory used by history.
+	// n <= 0 removes the limit.
+	SetScrollbackMaxBytes(n int)
+
+	// TabStops returns the columns of the active screen's tab stops.
+	TabStops() []int
+
+	// SetClipboard sets the Clipboard that handles OSC 52. If none is set, a
+	// Frontend that implements Clipboard is used.
+	SetClipboard(c Clipboard)
+	// SetClipboardPolicy sets what OSC 52 may do, such as denying reads.
+	SetClipboardPolicy(p ClipboardPolicy)
+
+	// PaletteColor returns a palette entry: 0-255 for the indexed colors, or
+	// PaletteForeground, PaletteBackground or PaletteCursor. It locks the
+	// terminal.
+	PaletteColor(index int) RGB
+	// SetPaletteColor sets a palette entry and the color it resets to.
+	SetPaletteColor(index int, c RGB)
+
+	// Commands returns the commands reported by OSC 133 shell integration
+	// marks, oldest first. A Frontend implementing CommandFrontend is told as
+	// each one finishes. It locks the terminal.
+	Commands() []Command
+	// ClearCommands forgets the recorded commands.
+	ClearCommands()
+
+	// SetDamageTracking switches the Frontend from RegionChanged and
+	// CursorMoved calls to pulling changes with TakeDamage.
+	SetDamageTracking(on bool)
+	// TakeDamage returns and clears what changed since the last call while
+	// damage tracking is on. It locks the terminal.
+	TakeDamage() Damage
+
+	// SetCapability sets the value XTGETTCAP reports for a terminfo
+	// capability. Use an empty value for a boolean capability.
+	SetCapability(name, value string)
+	// RemoveCapability makes XTGETTCAP report a capability as unknown.
+	RemoveCapability(name string)
+
+	// Close stops the terminal and closes the Backend if it implements
+	// io.Closer.
+	Close() error
+	// Done is closed when the terminal stops: its output ended, for example
+	// because the process exited, or Close was called. A Frontend
+	// implementing ExitFrontend is told the exit code.
+	Done() <-chan struct{}
+	// Err returns the error that stopped the terminal, or nil if its output
+	// ended normally or Close was called.
+	Err() error
+
+	// WaitFor waits until cond, called with the terminal locked, returns
+	// true. It checks again after every change to the terminal.
+	WaitFor(ctx context.Context, cond func() bool) error
+	// WaitForText waits until text appears on a row of the screen.
+	WaitForText(ctx context.Context, text string) (Match, error)
+	// WaitForTextIn waits until text appears on a row of region r.
+	WaitForTextIn(ctx context.Context, r Region, text string) (Match, error)
+	// WaitForRegex waits until re matches a row of the screen.
+	WaitForRegex(ctx context.Context, re *regexp.Regexp) (Match, error)
+	// WaitForRegexIn waits until re matches a row of region r.
+	WaitForRegexIn(ctx context.Context, r Region, re *regexp.Regexp) (Match, error)
+	// WaitForCursor waits until the cursor is at x, y.
+	WaitForCursor(ctx context.Context, x, y int) error
+	// WaitForStable waits until the terminal has not changed for d.
+	WaitForStable(ctx context.Context, d time.Duration) error
+
+	// Snapshot returns the terminal's state, which RestoreTerminal can
+	// restore. It locks the terminal.
+	Snapshot() *Snapshot
+
 	PrintTerminal() // for debugging
 }
 
@@ -36,6 +137,7 @@ type terminal struct {
 	onAltScreen bool
 	mainScreen  screen
 	altScreen   screen
+	scrollback  *scrollback
 
 	backend Backend
 
@@ -46,55 +148,135 @@ type terminal struct {
 	readLoopStarted bool
 	readLoopDone    chan struct{}
 	textReadMode    TextReadMode
+	// closed is set by Close, and err is the error that stopped the read
+	// loop.
+	closed bool
+	err    error
 
 	keyboardMain keyboardMode
 	keyboardAlt  keyboardMode
+	charsetMain  charsetState
+	charsetAlt   charsetState
+
+	savedCursorMain savedCursor
+	savedCursorAlt  savedCursor
+
+	// seq is reused by the parser for every sequence it reads.
+	seq sequence
+	// replies holds responses queued by replyf until flushReplies.
+	replies []byte
+
+	clipboard       Clipboard
+	clipboardPolicy ClipboardPolicy
+
+	palette palette
+
+	// commands are the finished OSC 133 commands, and commandRunning is set
+	// between the C and D marks.
+	commands       []Command
+	commandRunning bool
+
+	// capabilities is the XTGETTCAP table, or nil for defaultCapabilities.
+	capabilities map[string]string
+
+	// primaryDA and secondaryDA are the DA1 and DA2 reply parameters, and
+	// answerback is the reply to ENQ.
+	primaryDA   string
+	secondaryDA string
+	answerback  string
+
+	clock Clock
+
+	// sync holds back notifications while synchronized output (mode 2026)
+	// is on, and is nil otherwise.
+	sync        *syncFrontend
+	syncTimeout time.Duration
+
+	// damage records screen changes for TakeDamage while damage tracking is
+	// on, and is nil otherwise.
+	damage *damageTracker
+
+	// changed is closed by notifyWaiters when the screen may have changed,
+	// and is nil while nothing is waiting for that.
+	changed chan struct{}
 }
 
 // New makes a new terminal using the provided Frontend, Backend, and default text read mode.
 func New(f Frontend, backend Backend) Terminal {
-	return NewWithMode(f, backend, TextReadModeRune)
+	return NewTerminal(backend, WithFrontend(f))
 }
 
 // NewWithBackend makes a new terminal using the provided Frontend and Backend.
 func NewWithBackend(f Frontend, backend Backend) Terminal {
-	return NewWithMode(f, backend, TextReadModeRune)
+	return NewTerminal(backend, WithFrontend(f))
 }
 
 // NewWithMode makes a new terminal using the provided Frontend, Backend, and text read mode.
 func NewWithMode(f Frontend, backend Backend, mode TextReadMode) Terminal {
-	if backend == nil {
-		return nil
-	}
-	t := newTerminal(f, backend, mode)
-	t.startReadLoop()
-	return t
+	return NewTerminal(backend, WithFrontend(f), WithTextReadMode(mode))
 }
 
 // newTerminal creates a terminal without starting the read loop.
 // Used internally and by tests that feed data synchronously.
 func newTerminal(f Frontend, backend Backend, mode TextReadMode) *terminal {
+	c := defaultConfig()
+	c.frontend = f
+	c.mode = mode
+	return newTerminalWithConfig(backend, &c)
+}
+
+// newTerminalWithConfig creates a terminal configured by NewTerminal's
+// options without starting the read loop.
+func newTerminalWithConfig(backend Backend, c *config) *terminal {
+	f := c.frontend
 	if f == nil {
 		f = &EmptyFrontend{}
 	}
 
-	return &terminal{
+	t := &terminal{
 		frontend:     f,
-		mainScreen:   newScreen(f),
-		altScreen:    newScreen(f),
+		mainScreen:   newScreenOfType(c.screenType, f),
+		altScreen:    newScreenOfType(c.screenType, f),
+		scrollback:   newScrollback(c.scrollbackLines),
 		backend:      backend,
 		viewFlags:    make([]bool, viewFlagCount),
 		viewInts:     make([]int, viewIntCount),
 		viewStrings:  make([]string, viewStringCount),
-		textReadMode: mode,
+		textReadMode: c.mode,
+		palette:      newPalette(),
+		capabilities: c.capabilities,
+		primaryDA:    c.primaryDA,
+		secondaryDA:  c.secondaryDA,
+		answerback:   c.answerback,
+		clock:        c.clock,
+		syncTimeout:  c.syncTimeout,
+		readLoopDone: make(chan struct{}),
 	}
+	t.viewFlags[VFShowCursor] = true
+	t.savedCursorMain = newSavedCursor()
+	t.savedCursorAlt = newSavedCursor()
+	t.mainScreen.setScrollback(t.scrollback)
+	if p, ok := unwrapBackend[*PTYBackend](backend); ok && c.term != "" {
+		p.Term = c.term
+	}
+	if c.width > 0 && c.height > 0 {
+		if err := t.Resize(c.width, c.height); err != nil {
+			debugPrintln(debugErrors, "ERR Resize:", err)
+		}
+	}
+	return t
 }
 
 func (t *terminal) SetFrontend(f Frontend) {
 	t.WithLock(func() {
 		t.frontend = f
-		t.mainScreen.SetFrontend(f)
-		t.altScreen.SetFrontend(f)
+		if t.damage != nil {
+			t.damage.Frontend = f
+		}
+		if t.sync != nil {
+			t.sync.Frontend = t.notifyTarget()
+		}
+		t.attachScreens()
 	})
 }
 
@@ -107,7 +289,7 @@ func (t *terminal) SetFrontend(f Frontend) {
 // 	}
 // }
 
-const termStr = "TERM=xterm-256color"
+const termStr = "TERM=" + termName
 
 // Write is for the client to write keyboard/mouse input or terminal feedback
 func (t *terminal) Write(b []byte) (int, error) {
@@ -133,6 +315,28 @@ func (t *terminal) Write(b []byte) (int, error) {
 	return total, nil
 }
 
+// replyf queues a response to the application, such as a status report.
+// The caller must hold the lock; flushReplies writes the response once the
+// lock is released, since Write takes the lock itself.
+func (t *terminal) replyf(format string, args ...interface{}) {
+	t.replies = fmt.Appendf(t.replies, format, args...)
+}
+
+// flushReplies writes the responses queued by replyf through Write.
+func (t *terminal) flushReplies() {
+	t.Lock()
+	replies := t.replies
+	t.replies = nil
+	t.Unlock()
+
+	if len(replies) == 0 {
+		return
+	}
+	if _, err := t.Write(replies); err != nil {
+		debugPrintln(debugErrors, "Error writing reply:", err)
+	}
+}
+
 // Size returns the terminal width and height.
 // The caller must ensure the terminal is locked before calling this method.
 func (t *terminal) Size() (w, h int) {
@@ -142,8 +346,9 @@ func (t *terminal) Size() (w, h int) {
 
 func (t *terminal) Resize(w, h int) error {
 	t.WithLock(func() {
-		t.mainScreen.setSize(w, h)
+		t.resizeMainScreen(w, h)
 		t.altScreen.setSize(w, h)
+		t.notifyWaiters()
 	})
 
 	t.Lock()
@@ -161,7 +366,7 @@ func (t *terminal) startReadLoop() {
 	t.Lock()
 	started := t.readLoopStarted
 	backend := t.backend
-	if started || backend == nil {
+	if started || backend == nil || t.closed {
 		t.Unlock()
 		return
 	}
@@ -171,10 +376,7 @@ func (t *terminal) startReadLoop() {
 	}
 	t.Unlock()
 
-	go func() {
-		defer close(t.readLoopDone)
-		t.ptyReadLoop()
-	}()
+	go t.ptyReadLoop()
 }
 
 // Line returns the plain text content of line y.
@@ -208,6 +410,47 @@ func (t *terminal) StyledLines(r Region) []Line {
 	return t.screen().StyledLines(r)
 }
 
+// ScrollbackLen returns the number of lines in the main screen's history.
+// The caller must lock the terminal before calling this method.
+func (t *terminal) ScrollbackLen() int {
+	return t.scrollback.Len()
+}
+
+// ScrollbackLines returns history lines [start, end), where 0 is the oldest.
+// The caller must lock the terminal before calling this method.
+func (t *terminal) ScrollbackLines(start, end int) []Line {
+	return t.scrollback.Lines(start, end)
+}
+
+// ClearScrollback discards all history lines.
+func (t *terminal) ClearScrollback() {
+	t.WithLock(func() {
+		t.scrollback.clear()
+	})
+}
+
+// SetScrollbackMaxLines limits the history to n lines, dropping the oldest
+// lines if needed. n <= 0 disables history.
+func (t *terminal) SetScrollbackMaxLines(n int) {
+	t.WithLock(func() {
+		t.scrollback.setMaxLines(n)
+	})
+}
+
+// SetScrollbackMaxBytes limits the approximate memory used by history,
+// dropping the oldest lines if needed. n <= 0 removes the limit.
+func (t *terminal) SetScrollbackMaxBytes(n int) {
+	t.WithLock(func() {
+		t.scrollback.setMaxBytes(n)
+	})
+}
+
+// TabStops returns the columns of the active screen's tab stops, in order.
+// The caller must lock the terminal before calling this method.
+func (t *terminal) TabStops() []int {
+	return t.screen().tabStops().columns()
+}
+
 func (t *terminal) PrintTerminal() {
 	t.screen().printScreen()
 }
@@ -231,9 +474,30 @@ const (
 	MWheel   MouseFlag = 64
 )
 
+// SendMouse reports a mouse event to the program if it enabled mouse
+// reporting, using the encoding it asked for. x and y are 0-based cells.
+// Wheel events should use MBtn1 for wheel up, MBtn2 for wheel down, true for
+// press, and MWheel for mods.
+func (t *terminal) SendMouse(btn MouseBtn, press bool, mods MouseFlag, x, y int) error {
+	return t.SendMouseRaw(btn, press, mods, x+1, y+1)
+}
+
 // x and y should start at 1
 // wheel events should use btn1 for wheel up, btn2 for wheel down, true for press, and M_wheel for mods
 func (t *terminal) SendMouseRaw(btn MouseBtn, press bool, mods MouseFlag, x, y int) error {
+	t.Lock()
+	seq := t.encodeMouse(btn, press, mods, x, y)
+	t.Unlock()
+	if seq == nil {
+		return nil
+	}
+	_, err := t.Write(seq)
+	return err
+}
+
+// encodeMouse returns the report for a mouse event, or nil if the current
+// mouse mode does not report it. The terminal must be locked.
+func (t *terminal) encodeMouse(btn MouseBtn, press bool, mods MouseFlag, x, y int) []byte {
 	switch t.viewInts[VIMouseMode] {
 	case MMNone:
 		return nil
@@ -267,12 +531,7 @@ func (t *terminal) SendMouseRaw(btn MouseBtn, press bool, mods MouseFlag, x, y i
 			y = 255 - 32
 		}
 
-		mouseCmd := []byte("\033[M" + string(32+btnByte) + string(byte(32+x)) + string(byte(32+y)))
-		_, err := t.Write(mouseCmd)
-		if err != nil {
-			panic(fmt.Sprintf("error %v", err))
-		}
-		return err
+		return []byte("\033[M" + string(32+btnByte) + string(byte(32+x)) + string(byte(32+y)))
 
 	case MEUTF8:
 		btnByte := (byte(btn) & mWhichBtn) | byte(mods)
@@ -280,8 +539,7 @@ func (t *terminal) SendMouseRaw(btn MouseBtn, press bool, mods MouseFlag, x, y i
 			btnByte |= byte(MRelease)
 		}
 
-		_, err := t.Write([]byte("\033[M" + string(32+btnByte) + string(rune(32+x)) + string(rune(32+y))))
-		return err
+		return []byte("\033[M" + string(32+btnByte) + string(rune(32+x)) + string(rune(32+y)))
 
 	case MESGR:
 		btnByte := (byte(btn) & mWhichBtn) | byte(mods)
@@ -289,8 +547,7 @@ func (t *terminal) SendMouseRaw(btn MouseBtn, press bool, mods MouseFlag, x, y i
 		if !press {
 			pressByte = 'm'
 		}
-		_, err := fmt.Fprintf(t, "\033[<%v;%v;%v%c", btnByte, x, y, pressByte)
-		return err
+		return fmt.Appendf(nil, "\033[<%v;%v;%v%c", btnByte, x, y, pressByte)
 	}
 	panic(fmt.Sprintf("Unhandled ViMouseEncoding?? %v", mouseEncoding))
 }
@@ -335,13 +592,117 @@ func (t *terminal) WithLock(f func()) {
 func (t *terminal) switchScreen() {
 	t.onAltScreen = !t.onAltScreen
 	size := t.screen().Size()
-	t.frontend.RegionChanged(Region{X: 0, Y: 0, X2: size.X, Y2: size.Y}, CRScreenSwitch)
+	t.regionChanged(Region{X: 0, Y: 0, X2: size.X, Y2: size.Y}, CRScreenSwitch)
+}
+
+// originX converts a 0-based column addressed by CUP or CHA into a screen
+// column, relative to and confined by the left/right margins in origin mode.
+func (t *terminal) originX(x int) int {
+	s := t.screen()
+	if !s.OriginMode() {
+		return x
+	}
+	return clamp(x+s.LeftMargin(), s.LeftMargin(), s.RightMargin())
+}
+
+// originY converts a 0-based row addressed by CUP or VPA into a screen row,
+// relative to and confined by the top/bottom margins in origin mode.
+func (t *terminal) originY(y int) int {
+	s := t.screen()
+	if !s.OriginMode() {
+		return y
+	}
+	return clamp(y+s.TopMargin(), s.TopMargin(), s.BottomMargin())
+}
+
+// cursorHome moves the cursor to the home position, which is the top-left
+// margin corner in origin mode.
+func (t *terminal) cursorHome() {
+	t.screen().setCursorPos(t.originX(0), t.originY(0))
+}
+
+// lineStart returns the column a carriage return moves the cursor to: the
+// left margin, unless the cursor is already left of it.
+func (t *terminal) lineStart() int {
+	s := t.screen()
+	if s.CursorPos().X >= s.LeftMargin() {
+		return s.LeftMargin()
+	}
+	return 0
+}
+
+// insideMargins reports whether the cursor is inside the scroll margins, as
+// IL and DL require.
+func (t *terminal) insideMargins() bool {
+	s := t.screen()
+	pos := s.CursorPos()
+	return pos.Y >= s.TopMargin() && pos.Y <= s.BottomMargin() &&
+		pos.X >= s.LeftMargin() && pos.X <= s.RightMargin()
+}
+
+// tabForward moves the cursor to the n-th next tab stop, or the last column.
+func (t *terminal) tabForward(n int) {
+	s := t.screen()
+	pos := s.CursorPos()
+	s.setCursorPos(s.tabStops().next(pos.X, n), pos.Y)
+}
+
+// tabBackward moves the cursor to the n-th previous tab stop, or column 0.
+func (t *terminal) tabBackward(n int) {
+	s := t.screen()
+	pos := s.CursorPos()
+	s.setCursorPos(s.tabStops().prev(pos.X, n), pos.Y)
+}
+
+// reset performs a full terminal reset (RIS): both screens are cleared and
+// every mode, view setting, keyboard stack and palette color returns to its
+// initial value. The scrollback is kept.
+func (t *terminal) reset() {
+	t.setSyncOutput(false)
+	wasAlt := t.onAltScreen
+	t.onAltScreen = false
+	t.mainScreen.reset()
+	t.altScreen.reset()
+	t.keyboardMain = keyboardMode{}
+	t.keyboardAlt = keyboardMode{}
+	t.charsetMain = charsetState{}
+	t.charsetAlt = charsetState{}
+	t.savedCursorMain = newSavedCursor()
+	t.savedCursorAlt = newSavedCursor()
+	for f := ViewFlag(0); f < viewFlagCount; f++ {
+		t.setViewFlag(f, f == VFShowCursor)
+	}
+	for i := ViewInt(0); i < viewIntCount; i++ {
+		t.setViewInt(i, 0)
+	}
+	for s := ViewString(0); s < viewStringCount; s++ {
+		t.setViewString(s, "")
+	}
+	t.resetPalette()
+	t.commandRunning = false
+	if wasAlt {
+		size := t.screen().Size()
+		t.regionChanged(Region{X: 0, Y: 0, X2: size.X, Y2: size.Y}, CRScreenSwitch)
+	}
+}
+
+// softReset performs a soft terminal reset (DECSTR). Unlike reset it keeps
+// the screen contents, the active screen and the keyboard stacks.
+func (t *terminal) softReset() {
+	t.screen().softReset()
+	*t.charsets() = charsetState{}
+	*t.savedCursor() = newSavedCursor()
+	t.setViewFlag(VFShowCursor, true)
+	t.setViewFlag(VFAppCursorKeys, false)
+	t.setViewFlag(VFAppKeypad, false)
 }
 
 // testHandleCommand is only for testing.
 func (t *terminal) testHandleCommand(te *testing.T, cmd string) error {
 	te.Helper()
-	if !t.handleCommand(bufio.NewReader(strings.NewReader(cmd))) {
+	ok := t.handleCommand(bufio.NewReader(strings.NewReader(cmd)))
+	t.flushReplies()
+	if !ok {
 		return fmt.Errorf("handleCommand %q failed", cmd)
 	}
 	return nil
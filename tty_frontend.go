@@ -18,6 +18,11 @@ const (
 	ansiWrapEnable    = "\x1b[?7h"
 )
 
+// ansiCursorShape returns the DECSCUSR sequence for a VICursorShape value.
+func ansiCursorShape(shape int) string {
+	return fmt.Sprintf("\x1b[%d q", shape)
+}
+
 // TTYFrontend renders changed regions to a terminal (tty) output.
 // It can be attached to a region of the screen and detached to stop updates.
 type TTYFrontend struct {
@@ -29,6 +34,8 @@ type TTYFrontend struct {
 	cursor   Pos
 	showCur  bool
 	focused  bool
+	// cursorShape is the terminal's VICursorShape, shown while focused.
+	cursorShape int
 }
 
 // NewTTYFrontend returns a frontend that writes to out (defaults to stdout).
@@ -63,6 +70,9 @@ func (t *TTYFrontend) Attach(r Region) {
 
 	t.term.WithLock(func() {
 		t.renderRegionLocked(t.region)
+		if t.cursorShape != CSDefault {
+			t.renderCursorShapeLocked()
+		}
 	})
 }
 
@@ -71,9 +81,13 @@ func (t *TTYFrontend) Detach() {
 	t.mu.Lock()
 	t.attached = false
 	out := t.out
+	shape := t.cursorShape
 	t.mu.Unlock()
 	if out != nil {
 		_, _ = out.Write([]byte(ansiCursorShow))
+		if shape != CSDefault {
+			_, _ = out.Write([]byte(ansiCursorShape(CSDefault)))
+		}
 	}
 }
 
@@ -83,16 +97,23 @@ func (t *TTYFrontend) Focus() {
 	defer t.mu.Unlock()
 	t.focused = true
 	t.renderCursorLocked()
+	if t.cursorShape != CSDefault {
+		t.renderCursorShapeLocked()
+	}
 }
 
-// Blur disables cursor updates and restores cursor visibility.
+// Blur disables cursor updates and restores cursor visibility and shape.
 func (t *TTYFrontend) Blur() {
 	t.mu.Lock()
 	t.focused = false
 	out := t.out
+	shape := t.cursorShape
 	t.mu.Unlock()
 	if out != nil {
 		_, _ = out.Write([]byte(ansiCursorShow))
+		if shape != CSDefault {
+			_, _ = out.Write([]byte(ansiCursorShape(CSDefault)))
+		}
 	}
 }
 
@@ -105,6 +126,55 @@ func (t *TTYFrontend) SetFocus(focused bool) {
 	}
 }
 
+// SetPull switches between redrawing on every change and redrawing only when
+// Render is called, for example once per frame. It turns damage tracking on
+// the terminal on or off.
+func (t *TTYFrontend) SetPull(pull bool) {
+	t.mu.Lock()
+	term := t.term
+	t.mu.Unlock()
+	if term != nil {
+		term.SetDamageTracking(pull)
+	}
+}
+
+// Render draws what changed since the last Render in pull mode. The whole
+// region is redrawn after a scroll, since the rows that only moved are not
+// reported as damaged.
+func (t *TTYFrontend) Render() {
+	t.mu.Lock()
+	term := t.term
+	t.mu.Unlock()
+	if term == nil {
+		return
+	}
+	dmg := term.TakeDamage()
+	if dmg.Empty() {
+		return
+	}
+
+	term.WithLock(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if dmg.CursorMoved {
+			t.cursor = dmg.Cursor
+		}
+		if t.out == nil || !t.attached {
+			return
+		}
+		var buf bytes.Buffer
+		if dmg.Scroll != 0 {
+			t.appendRegionLocked(&buf, t.region)
+		} else {
+			for _, r := range dmg.Rects {
+				t.appendRegionLocked(&buf, r.Intersect(t.region))
+			}
+		}
+		_, _ = t.out.Write(buf.Bytes())
+		t.renderCursorLocked()
+	})
+}
+
 func (t *TTYFrontend) Bell() {}
 
 func (t *TTYFrontend) RegionChanged(r Region, _ ChangeReason) {
@@ -137,7 +207,15 @@ func (t *TTYFrontend) ViewFlagChanged(v ViewFlag, value bool) {
 	t.showCur = value
 	t.renderCursorLocked()
 }
-func (t *TTYFrontend) ViewIntChanged(v ViewInt, value int)          {}
+func (t *TTYFrontend) ViewIntChanged(v ViewInt, value int) {
+	if v != VICursorShape {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cursorShape = value
+	t.renderCursorShapeLocked()
+}
 func (t *TTYFrontend) ViewStringChanged(v ViewString, value string) {}
 
 func (t *TTYFrontend) renderRegionLocked(r Region) {
@@ -145,13 +223,23 @@ func (t *TTYFrontend) renderRegionLocked(r Region) {
 		return
 	}
 
+	var buf bytes.Buffer
+	if !t.appendRegionLocked(&buf, r) {
+		return
+	}
+	_, _ = t.out.Write(buf.Bytes())
+	t.renderCursorLocked()
+}
+
+// appendRegionLocked appends the output that redraws r to buf, reporting
+// whether there was anything to draw.
+func (t *TTYFrontend) appendRegionLocked(buf *bytes.Buffer, r Region) bool {
 	w, h := t.term.Size()
 	r = clampRegion(r, w, h)
 	if r.Empty() {
-		return
+		return false
 	}
 
-	var buf bytes.Buffer
 	buf.WriteString(ansiSaveCursor)
 	buf.WriteString(ansiWrapDisable)
 	for y := r.Y; y < r.Y2; y++ {
@@ -162,9 +250,7 @@ func (t *TTYFrontend) renderRegionLocked(r Region) {
 	buf.WriteString(ansiReset)
 	buf.WriteString(ansiWrapEnable)
 	buf.WriteString(ansiRestoreCursor)
-
-	_, _ = t.out.Write(buf.Bytes())
-	t.renderCursorLocked()
+	return true
 }
 
 func (t *TTYFrontend) renderCursorLocked() {
@@ -182,13 +268,25 @@ func (t *TTYFrontend) renderCursorLocked() {
 	_, _ = t.out.Write([]byte(ansiMoveCursor(t.cursor.X, t.cursor.Y) + ansiCursorShow))
 }
 
+func (t *TTYFrontend) renderCursorShapeLocked() {
+	if t.out == nil || !t.attached || !t.focused {
+		return
+	}
+	_, _ = t.out.Write([]byte(ansiCursorShape(t.cursorShape)))
+}
+
 func renderStyledLineANSI(line Line) []byte {
 	if len(line.Spans) == 0 {
 		return nil
 	}
 
 	var buf bytes.Buffer
+	var link *Hyperlink
 	for _, span := range line.Spans {
+		if !sameHyperlink(link, span.Link) {
+			buf.WriteString(hyperlinkEscape(span.Link))
+			link = span.Link
+		}
 		buf.Write(span.Style.ANSIEscape())
 		if span.Text != "" {
 			buf.WriteString(span.Text)
@@ -198,6 +296,9 @@ func renderStyledLineANSI(line Line) []byte {
 			}
 		}
 	}
+	if link != nil {
+		buf.WriteString(hyperlinkEscape(nil))
+	}
 	return buf.Bytes()
 }
 
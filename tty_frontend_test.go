@@ -3,6 +3,7 @@ package termemu
 import (
 	"bytes"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -108,3 +109,26 @@ func TestTTYFrontendNestedTerminalSpacing(t *testing.T) {
 		})
 	}
 }
+
+func TestTTYFrontend_CursorShape(t *testing.T) {
+	var out bytes.Buffer
+	f := NewTTYFrontend(nil, &out)
+	term := newTerminal(f, NewNoPTYBackend(bytes.NewReader(nil), io.Discard), TextReadModeRune)
+	f.SetTerminal(term)
+	_ = term.Resize(10, 2)
+	f.Attach(Region{X2: 10, Y2: 2})
+
+	out.Reset()
+	if err := term.testFeedTerminalInputFromBackend([]byte("\x1b[5 q"), TextReadModeRune); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "\x1b[5 q" {
+		t.Errorf("output = %q, want the shape re-emitted", got)
+	}
+
+	out.Reset()
+	f.Detach()
+	if got := out.String(); !strings.HasSuffix(got, "\x1b[0 q") {
+		t.Errorf("output = %q, want the host's default shape restored", got)
+	}
+}